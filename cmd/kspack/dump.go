@@ -0,0 +1,139 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubeservice-stack/kspack-go/pack"
+)
+
+// maxShown is the number of bytes of a string or binary dump prints.
+const maxShown = 64
+
+func runDump(env *env, fs *flag.FlagSet, args []string) error {
+	in := formatFlag(fs, "in", "input format")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	inputs, err := env.inputs(fs.Args(), *in)
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	for i, in := range inputs {
+		if len(inputs) > 1 {
+			if i > 0 {
+				fmt.Fprintln(env.stdout)
+			}
+			fmt.Fprintf(env.stdout, "==> %s <==\n", in.name)
+		}
+		if err := dump(env.stdout, in.data); err != nil {
+			fmt.Fprintf(env.stderr, "%s: %v\n", in.name, err)
+			failed = true
+		}
+	}
+	if failed {
+		return errSilent
+	}
+	return nil
+}
+
+// dump writes the tree of the items of data to w, one item per line:
+//
+//	OFFSET  LENGTH  ITEM
+//	     0      43  OBJECT(0x10) {2}
+//	    10      13    "id": INT64(0x18) 42
+//	    23      20    "tags": ARRAY(0x20) [1]
+//	    38       5      [0] SHORT_STRING(0xd0) "a"
+func dump(w io.Writer, data []byte) error {
+	if err := pack.Valid(data); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%8s  %6s  %s\n", "OFFSET", "LENGTH", "ITEM")
+	return walk(data, func(it *item) error {
+		var label string
+		key := it.Key()
+		switch {
+		case it.index >= 0:
+			label = "[" + strconv.Itoa(it.index) + "] "
+			if key != "" {
+				label += strconv.Quote(key) + ": "
+			}
+		case key != "":
+			label = strconv.Quote(key) + ": "
+		}
+		_, err := fmt.Fprintf(w, "%8d  %6d  %s%s%s %s\n", it.Offset(), len(it.Raw()),
+			strings.Repeat("  ", it.depth), label, typeName(it.Type()), describe(it.Value))
+		return err
+	})
+}
+
+// describe returns the value of the item v, or its member number for
+// objects and arrays.
+func describe(v pack.Value) string {
+	switch v.Type() {
+	case pack.KSPACK_OBJECT:
+		return "{" + strconv.Itoa(v.Len()) + "}"
+	case pack.KSPACK_ARRAY:
+		return "[" + strconv.Itoa(v.Len()) + "]"
+	case pack.KSPACK_STRING, pack.KSPACK_SHORT_STRING:
+		s, _ := v.String()
+		if len(s) > maxShown {
+			return strconv.Quote(s[:maxShown]) + fmt.Sprintf("... (%d bytes)", len(s))
+		}
+		return strconv.Quote(s)
+	case pack.KSPACK_BINARY, pack.KSPACK_SHORT_BINARY:
+		b, _ := v.Bytes()
+		if len(b) > maxShown {
+			return hex.EncodeToString(b[:maxShown]) + fmt.Sprintf("... (%d bytes)", len(b))
+		}
+		return hex.EncodeToString(b) + fmt.Sprintf(" (%d bytes)", len(b))
+	case pack.KSPACK_INT8, pack.KSPACK_INT16, pack.KSPACK_INT32, pack.KSPACK_INT64:
+		n, _ := v.Int64()
+		return strconv.FormatInt(n, 10)
+	case pack.KSPACK_UINT8, pack.KSPACK_UINT16, pack.KSPACK_UINT32, pack.KSPACK_UINT64:
+		n, _ := v.Uint64()
+		return strconv.FormatUint(n, 10)
+	case pack.KSPACK_FLOAT:
+		f, _ := v.Float64()
+		return strconv.FormatFloat(f, 'g', -1, 32)
+	case pack.KSPACK_DOUBLE:
+		f, _ := v.Float64()
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	case pack.KSPACK_BOOL:
+		b, _ := v.Bool()
+		return strconv.FormatBool(b)
+	case pack.KSPACK_DATE, pack.KSPACK_ZONED_DATE:
+		t, err := v.Time()
+		if err != nil {
+			return err.Error()
+		}
+		if t.IsZero() {
+			return "zero"
+		}
+		return t.Format(time.RFC3339Nano)
+	}
+	return "null"
+}
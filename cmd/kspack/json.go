@@ -0,0 +1,138 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/kubeservice-stack/kspack-go/pack"
+)
+
+// runToJSON writes each input as a JSON value on a line of its own,
+// with the type mapping of pack.ToJSON.
+func runToJSON(env *env, fs *flag.FlagSet, args []string) error {
+	in := formatFlag(fs, "in", "input format")
+	indent := fs.Bool("indent", false, "indent the JSON output")
+	typed := fs.Bool("typed", false, `write binaries, dates and sized numbers as typed values such as {"$int8": 1}`)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	inputs, err := env.inputs(fs.Args(), *in)
+	if err != nil {
+		return err
+	}
+
+	opts := pack.JSONOptions{Typed: *typed}
+	var buf, out bytes.Buffer
+	for _, in := range inputs {
+		buf.Reset()
+		if err := opts.ToJSON(&buf, in.data); err != nil {
+			return fmt.Errorf("%s: %v", in.name, err)
+		}
+		out.Reset()
+		if *indent {
+			if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+				return fmt.Errorf("%s: %v", in.name, err)
+			}
+		} else {
+			out.Write(buf.Bytes())
+		}
+		out.WriteByte('\n')
+		if _, err := env.stdout.Write(out.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// intTypes maps the values of the -ints flag of from-json to the type
+// codes they force.
+var intTypes = map[string]byte{
+	"int8":   pack.KSPACK_INT8,
+	"int16":  pack.KSPACK_INT16,
+	"int32":  pack.KSPACK_INT32,
+	"int64":  pack.KSPACK_INT64,
+	"uint8":  pack.KSPACK_UINT8,
+	"uint16": pack.KSPACK_UINT16,
+	"uint32": pack.KSPACK_UINT32,
+	"uint64": pack.KSPACK_UINT64,
+}
+
+// runFromJSON writes each JSON value of the inputs as an item, with the
+// type mapping of pack.FromJSON.
+func runFromJSON(env *env, fs *flag.FlagSet, args []string) error {
+	out := formatFlag(fs, "out", "output format")
+	typed := fs.Bool("typed", false, `read typed values such as {"$int8": 1} as the items they describe`)
+	ints := fs.String("ints", "", "type of integers: infer for the narrowest signed type, or int8 to uint64 (default int64, uint64 beyond)")
+	floats := fs.String("floats", "double", "type of other numbers: double or float")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	opts := pack.JSONOptions{Typed: *typed}
+	switch *ints {
+	case "":
+	case "infer":
+		opts.InferIntWidth = true
+	default:
+		if opts.IntType = intTypes[*ints]; opts.IntType == 0 {
+			return env.badFlag(fs, "ints", *ints)
+		}
+	}
+	switch *floats {
+	case "double":
+		opts.FloatType = pack.KSPACK_DOUBLE
+	case "float":
+		opts.FloatType = pack.KSPACK_FLOAT
+	default:
+		return env.badFlag(fs, "floats", *floats)
+	}
+
+	inputs, err := env.inputs(fs.Args(), "raw")
+	if err != nil {
+		return err
+	}
+	for _, in := range inputs {
+		w := &itemWriter{w: env.stdout, f: *out}
+		if err := opts.FromJSON(w, bytes.NewReader(in.data)); err != nil {
+			if w.err != nil {
+				return err
+			}
+			return fmt.Errorf("%s: %v", in.name, err)
+		}
+	}
+	return nil
+}
+
+// An itemWriter writes the items pack.FromJSON writes to it, one per
+// call, to w in f.
+type itemWriter struct {
+	w   io.Writer
+	f   textFormat
+	err error // error of w
+}
+
+func (iw *itemWriter) Write(b []byte) (int, error) {
+	if iw.err = iw.f.encode(iw.w, b); iw.err != nil {
+		return 0, iw.err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Kspack inspects, converts and validates kspack items.
+//
+// Usage:
+//
+//	kspack <command> [flags] [file ...]
+//
+// The commands are:
+//
+//	dump       print an item as a tree of type codes, keys, offsets and lengths
+//	to-json    convert an item to JSON
+//	from-json  convert JSON values to items
+//	validate   check that an input holds exactly one well-formed item
+//	stats      print the number and size of the items by type
+//
+// Each file, or the standard input if no file is given or the file is
+// "-", holds one kspack item, or JSON values for from-json. Items copied
+// from logs can be read as text with -in hex or -in base64, and from-json
+// writes them the same way with -out.
+//
+// The JSON conversions keep the order of object members and follow the
+// type mapping of pack.JSONOptions. With -typed, the items whose type
+// plain JSON loses, such as binaries and sized integers, are written as
+// typed values like {"$uint16": 7} and read back unchanged.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	// errSilent is returned by the commands that already reported
+	// their failure on the output.
+	errSilent = errors.New("silent")
+	// errUsage is returned by the commands given invalid flags, once
+	// their usage is printed.
+	errUsage = errors.New("usage")
+)
+
+type command struct {
+	name  string
+	args  string
+	short string
+	run   func(env *env, fs *flag.FlagSet, args []string) error
+}
+
+var commands = []*command{
+	{"dump", "[-in format] [file ...]", "print an item as a tree of type codes, keys, offsets and lengths", runDump},
+	{"to-json", "[-in format] [-indent] [-typed] [file ...]", "convert an item to JSON, one value per line", runToJSON},
+	{"from-json", "[-out format] [-typed] [-ints type] [-floats type] [file ...]", "convert JSON values to items", runFromJSON},
+	{"validate", "[-in format] [-canonical] [file ...]", "check that an input holds exactly one well-formed item", runValidate},
+	{"stats", "[-in format] [file ...]", "print the number and size of the items by type", runStats},
+}
+
+// env holds the standard streams of a run.
+type env struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the exit status: 0 on
+// success, 1 if a command failed and 2 on a usage error.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	env := &env{stdin: stdin, stdout: stdout, stderr: stderr}
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		env.usage()
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+
+	var cmd *command
+	for _, c := range commands {
+		if c.name == args[0] {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "kspack: unknown command %q\n", args[0])
+		env.usage()
+		return 2
+	}
+
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: kspack %s %s\n\n%s.\n", cmd.name, cmd.args, strings.ToUpper(cmd.short[:1])+cmd.short[1:])
+		fs.PrintDefaults()
+	}
+	switch err := cmd.run(env, fs, args[1:]); err {
+	case nil, flag.ErrHelp:
+		return 0
+	case errUsage:
+		return 2
+	case errSilent:
+		return 1
+	default:
+		fmt.Fprintf(stderr, "kspack %s: %v\n", cmd.name, err)
+		return 1
+	}
+}
+
+func (env *env) usage() {
+	fmt.Fprintf(env.stderr, "Usage: kspack <command> [flags] [file ...]\n\nThe commands are:\n\n")
+	for _, c := range commands {
+		fmt.Fprintf(env.stderr, "\t%-10s %s\n", c.name, c.short)
+	}
+	fmt.Fprintf(env.stderr, "\nRun \"kspack <command> -h\" for the flags of a command.\n")
+}
+
+// parse parses the flags of fs from args. The flag package reports
+// syntax errors along with the usage of the command.
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return errUsage
+	}
+	return nil
+}
+
+// badFlag reports the invalid value of the flag name of fs.
+func (env *env) badFlag(fs *flag.FlagSet, name, value string) error {
+	fmt.Fprintf(env.stderr, "invalid value %q for flag -%s\n", value, name)
+	fs.Usage()
+	return errUsage
+}
+
+// A textFormat is the way kspack items are written as bytes: raw, hex
+// or base64.
+type textFormat string
+
+func (f *textFormat) String() string { return string(*f) }
+
+func (f *textFormat) Set(s string) error {
+	switch s {
+	case "raw", "hex", "base64":
+		*f = textFormat(s)
+		return nil
+	}
+	return errors.New("must be raw, hex or base64")
+}
+
+// formatFlag defines the flag name of fs, defaulting to raw.
+func formatFlag(fs *flag.FlagSet, name, usage string) *textFormat {
+	f := textFormat("raw")
+	fs.Var(&f, name, usage+": raw, hex or base64")
+	return &f
+}
+
+// decode returns the item held by the input b written in f. Spaces and
+// line breaks are ignored in hex and base64.
+func (f textFormat) decode(b []byte) ([]byte, error) {
+	if f == "raw" {
+		return b, nil
+	}
+	s := strings.Join(strings.Fields(string(b)), "")
+	if f == "hex" {
+		return hex.DecodeString(s)
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// encode writes the item b to w in f, hex and base64 on a line of
+// their own.
+func (f textFormat) encode(w io.Writer, b []byte) error {
+	var err error
+	switch f {
+	case "hex":
+		_, err = fmt.Fprintln(w, hex.EncodeToString(b))
+	case "base64":
+		_, err = fmt.Fprintln(w, base64.StdEncoding.EncodeToString(b))
+	default:
+		_, err = w.Write(b)
+	}
+	return err
+}
+
+// An input is one of the files named on the command line.
+type input struct {
+	name string // "<stdin>" for the standard input
+	data []byte
+}
+
+// inputs reads the files names, the standard input if there are none,
+// decoding them as written in f.
+func (env *env) inputs(names []string, f textFormat) ([]input, error) {
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	var list []input
+	for _, name := range names {
+		var b []byte
+		var err error
+		if name == "-" {
+			name = "<stdin>"
+			b, err = io.ReadAll(env.stdin)
+		} else {
+			b, err = os.ReadFile(name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b, err = f.decode(b); err != nil {
+			return nil, fmt.Errorf("%s: reading %s: %v", name, f, err)
+		}
+		list = append(list, input{name: name, data: b})
+	}
+	return list, nil
+}
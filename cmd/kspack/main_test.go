@@ -0,0 +1,270 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubeservice-stack/kspack-go/pack"
+)
+
+// runWith runs the command line args on stdin and returns its exit
+// status and outputs.
+func runWith(stdin []byte, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, bytes.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func sample() []byte {
+	b, err := pack.MarshalCanonical(map[string]interface{}{
+		"id":   int64(42),
+		"tags": []string{"a"},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestDump(t *testing.T) {
+	assert := assert.New(t)
+
+	code, out, _ := runWith(sample(), "dump")
+	assert.Equal(0, code)
+	assert.Equal(`  OFFSET  LENGTH  ITEM
+       0      43  OBJECT(0x10) {2}
+      10      13    "id": INT64(0x18) 42
+      23      20    "tags": ARRAY(0x20) [1]
+      38       5      [0] SHORT_STRING(0xd0) "a"
+`, out)
+
+	type values struct {
+		U  uint8
+		F  float32
+		D  float64
+		B  bool
+		T  time.Time
+		Z  time.Time
+		N  *int
+		S  string
+		Bs []byte
+	}
+	b, err := pack.Marshal(values{
+		U: 7, F: 0.5, D: -2, B: true,
+		T: time.Date(2023, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600)),
+		S: strings.Repeat("x", 100), Bs: []byte{0xca, 0xfe},
+	})
+	assert.Nil(err)
+	code, out, _ = runWith(b, "dump")
+	assert.Equal(0, code)
+	for _, s := range []string{
+		`"U": UINT8(0x21) 7`,
+		`"F": FLOAT(0x44) 0.5`,
+		`"D": DOUBLE(0x48) -2`,
+		`"B": BOOL(0x31) true`,
+		`"T": ZONED_DATE(0xd8) 2023-01-02T03:04:05+01:00`,
+		`"Z": DATE(0x58) zero`,
+		`"N": NULL(0x61) null`,
+		`"S": SHORT_STRING(0xd0) "` + strings.Repeat("x", maxShown) + `"... (100 bytes)`,
+		`"Bs": SHORT_BINARY(0xe0) cafe (2 bytes)`,
+	} {
+		assert.Contains(out, s)
+	}
+}
+
+func TestDumpInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	b := sample()
+	code, out, errOut := runWith(b[:len(b)-1], "dump")
+	assert.Equal(1, code)
+	assert.Equal("", out)
+	assert.Contains(errOut, "<stdin>: kspack: ")
+}
+
+func TestInputFormats(t *testing.T) {
+	assert := assert.New(t)
+
+	_, want, _ := runWith(sample(), "dump")
+
+	code, out, _ := runWith([]byte(hex.EncodeToString(sample())[:20]+"\n  "+hex.EncodeToString(sample())[20:]), "dump", "-in", "hex")
+	assert.Equal(0, code)
+	assert.Equal(want, out)
+
+	code, out, _ = runWith([]byte(base64.StdEncoding.EncodeToString(sample())+"\n"), "dump", "-in=base64")
+	assert.Equal(0, code)
+	assert.Equal(want, out)
+
+	code, _, errOut := runWith([]byte("zz"), "dump", "-in", "hex")
+	assert.Equal(1, code)
+	assert.Contains(errOut, "<stdin>: reading hex: ")
+
+	code, _, errOut = runWith(nil, "dump", "-in", "octal")
+	assert.Equal(2, code)
+	assert.Contains(errOut, "must be raw, hex or base64")
+}
+
+func TestFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	assert.Nil(os.WriteFile(a, sample(), 0o644))
+	assert.Nil(os.WriteFile(b, []byte{pack.KSPACK_NULL, 0, 0}, 0o644))
+
+	code, out, _ := runWith(nil, "dump", a, b)
+	assert.Equal(0, code)
+	assert.True(strings.HasPrefix(out, "==> "+a+" <==\n  OFFSET"))
+	assert.Contains(out, "\n\n==> "+b+" <==\n  OFFSET  LENGTH  ITEM\n       0       3  NULL(0x61) null\n")
+
+	code, _, errOut := runWith(nil, "dump", filepath.Join(dir, "missing"))
+	assert.Equal(1, code)
+	assert.Contains(errOut, "no such file")
+}
+
+func TestJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	code, out, _ := runWith(sample(), "to-json")
+	assert.Equal(0, code)
+	assert.Equal(`{"id":42,"tags":["a"]}`+"\n", out)
+
+	code, out, _ = runWith(sample(), "to-json", "-indent")
+	assert.Equal(0, code)
+	assert.Equal("{\n  \"id\": 42,\n  \"tags\": [\n    \"a\"\n  ]\n}\n", out)
+
+	code, out, _ = runWith([]byte(`{"id": 42, "tags": ["a"]}`), "from-json")
+	assert.Equal(0, code)
+	assert.Equal(sample(), []byte(out))
+
+	// typed values round-trip
+	b, err := pack.Marshal(struct {
+		U uint16
+		B []byte
+	}{7, []byte{1}})
+	assert.Nil(err)
+	code, out, _ = runWith(b, "to-json", "-typed")
+	assert.Equal(0, code)
+	assert.Equal(`{"U":{"$uint16":7},"B":{"$binary":"AQ=="}}`+"\n", out)
+	code, out, _ = runWith([]byte(out), "from-json", "-typed")
+	assert.Equal(0, code)
+	assert.Equal(b, []byte(out))
+
+	code, out, _ = runWith([]byte(`[1, 1.5]`), "from-json", "-ints", "infer", "-floats", "float", "-out", "hex")
+	assert.Equal(0, code)
+	b, err = hex.DecodeString(strings.TrimSpace(out))
+	assert.Nil(err)
+	var v interface{}
+	assert.Nil(pack.Unmarshal(b, &v))
+	assert.Equal([]interface{}{int8(1), float32(1.5)}, v)
+
+	code, _, errOut := runWith([]byte(`1`), "from-json", "-ints", "int128")
+	assert.Equal(2, code)
+	assert.Contains(errOut, `invalid value "int128" for flag -ints`)
+
+	code, out, _ = runWith([]byte("1 -1 18446744073709551615 1.5 null\n"), "from-json", "-out", "hex")
+	assert.Equal(0, code)
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if assert.Len(lines, 5) {
+		for i, want := range []interface{}{int64(1), int64(-1), uint64(1<<64 - 1), 1.5, nil} {
+			b, err := hex.DecodeString(lines[i])
+			assert.Nil(err)
+			var v interface{}
+			assert.Nil(pack.Unmarshal(b, &v))
+			assert.Equal(want, v)
+		}
+	}
+
+	code, _, errOut = runWith([]byte(`{"a":`), "from-json")
+	assert.Equal(1, code)
+	assert.Contains(errOut, "kspack from-json: <stdin>: ")
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	code, out, _ := runWith(sample(), "validate", "-canonical")
+	assert.Equal(0, code)
+	assert.Equal("<stdin>: ok\n", out)
+
+	b, err := pack.Marshal(struct{ B, A int }{})
+	assert.Nil(err)
+	code, out, _ = runWith(b, "validate")
+	assert.Equal(0, code)
+	assert.Equal("<stdin>: ok\n", out)
+	code, out, _ = runWith(b, "validate", "-canonical")
+	assert.Equal(1, code)
+	assert.Equal("<stdin>: kspack: item not in canonical form\n", out)
+
+	code, out, _ = runWith(append(sample(), 0), "validate")
+	assert.Equal(1, code)
+	assert.Equal("<stdin>: kspack: 1 trailing bytes after item at offset 43\n", out)
+}
+
+func TestStats(t *testing.T) {
+	assert := assert.New(t)
+
+	code, out, _ := runWith(sample(), "stats")
+	assert.Equal(0, code)
+	assert.Equal(`inputs     1
+bytes      43
+items      4
+keys       2
+key bytes  6
+max depth  2
+
+TYPE                ITEMS  BYTES
+OBJECT(0x10)        1      10
+INT64(0x18)         1      13
+ARRAY(0x20)         1      15
+SHORT_STRING(0xd0)  1      5
+`, out)
+}
+
+func TestUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	code, _, errOut := runWith(nil)
+	assert.Equal(2, code)
+	assert.Contains(errOut, "Usage: kspack <command>")
+
+	code, _, errOut = runWith(nil, "help")
+	assert.Equal(0, code)
+	assert.Contains(errOut, "from-json")
+
+	code, _, errOut = runWith(nil, "inspect")
+	assert.Equal(2, code)
+	assert.Contains(errOut, `unknown command "inspect"`)
+
+	code, _, errOut = runWith(nil, "stats", "-h")
+	assert.Equal(0, code)
+	assert.Contains(errOut, "Usage: kspack stats [-in format] [file ...]")
+
+	code, _, errOut = runWith(nil, "to-json", "-pretty")
+	assert.Equal(2, code)
+	assert.Contains(errOut, "flag provided but not defined: -pretty")
+}
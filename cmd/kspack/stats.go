@@ -0,0 +1,142 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/kubeservice-stack/kspack-go/pack"
+)
+
+func runValidate(env *env, fs *flag.FlagSet, args []string) error {
+	in := formatFlag(fs, "in", "input format")
+	canonical := fs.Bool("canonical", false, "also require the canonical form of pack.MarshalCanonical")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	inputs, err := env.inputs(fs.Args(), *in)
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	for _, in := range inputs {
+		err := pack.Valid(in.data)
+		if err == nil && *canonical {
+			err = checkCanonical(in.data)
+		}
+		if err != nil {
+			fmt.Fprintf(env.stdout, "%s: %v\n", in.name, err)
+			failed = true
+		} else {
+			fmt.Fprintf(env.stdout, "%s: ok\n", in.name)
+		}
+	}
+	if failed {
+		return errSilent
+	}
+	return nil
+}
+
+// checkCanonical reports whether data is in canonical form.
+func checkCanonical(data []byte) error {
+	c, err := pack.Canonicalize(data)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(c, data) {
+		return fmt.Errorf("kspack: item not in canonical form")
+	}
+	return nil
+}
+
+// typeStats counts the items of a type. The bytes of an object or an
+// array are those of its header and key, its members being counted on
+// their own, so that the bytes of all types add up to the input size.
+type typeStats struct {
+	items int
+	bytes int
+}
+
+func runStats(env *env, fs *flag.FlagSet, args []string) error {
+	in := formatFlag(fs, "in", "input format")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	inputs, err := env.inputs(fs.Args(), *in)
+	if err != nil {
+		return err
+	}
+
+	var size, items, keys, keyBytes, depth int
+	types := make(map[byte]*typeStats)
+	for _, in := range inputs {
+		if err := pack.Valid(in.data); err != nil {
+			return fmt.Errorf("%s: %v", in.name, err)
+		}
+		size += len(in.data)
+		err := walk(in.data, func(it *item) error {
+			items++
+			if key := it.Key(); key != "" {
+				keys++
+				keyBytes += len(key)
+			}
+			if it.depth > depth {
+				depth = it.depth
+			}
+			s := types[it.Type()]
+			if s == nil {
+				s = new(typeStats)
+				types[it.Type()] = s
+			}
+			s.items++
+			s.bytes += len(it.Raw())
+			if it.depth > 0 {
+				types[it.parent].bytes -= len(it.Raw())
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", in.name, err)
+		}
+	}
+
+	codes := make([]int, 0, len(types))
+	for typ := range types {
+		codes = append(codes, int(typ))
+	}
+	sort.Ints(codes)
+
+	w := tabwriter.NewWriter(env.stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "inputs\t%d\n", len(inputs))
+	fmt.Fprintf(w, "bytes\t%d\n", size)
+	fmt.Fprintf(w, "items\t%d\n", items)
+	fmt.Fprintf(w, "keys\t%d\n", keys)
+	fmt.Fprintf(w, "key bytes\t%d\n", keyBytes)
+	fmt.Fprintf(w, "max depth\t%d\n", depth)
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "TYPE\tITEMS\tBYTES\n")
+	for _, typ := range codes {
+		s := types[byte(typ)]
+		fmt.Fprintf(w, "%s\t%d\t%d\n", typeName(byte(typ)), s.items, s.bytes)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/kubeservice-stack/kspack-go/pack"
+)
+
+// An item is an item of a document as walk visits it.
+type item struct {
+	pack.Value
+	index  int  // index in its parent array, or -1
+	depth  int  // 0 for the top-level item
+	parent byte // type of its parent object or array
+}
+
+// walk calls visit with every item of the document data, which must
+// have been checked by pack.Valid, parents before their members.
+func walk(data []byte, visit func(it *item) error) error {
+	top, err := pack.Get(data)
+	if err != nil {
+		return err
+	}
+	return walkItem(&item{Value: top, index: -1}, visit)
+}
+
+func walkItem(it *item, visit func(it *item) error) error {
+	if err := visit(it); err != nil {
+		return err
+	}
+	var err error
+	rerr := it.Range(func(i int, m pack.Value) bool {
+		member := &item{Value: m, index: -1, depth: it.depth + 1, parent: it.Type()}
+		if it.Type() == pack.KSPACK_ARRAY {
+			member.index = i
+		}
+		err = walkItem(member, visit)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return rerr
+}
+
+// typeNames maps the type codes to the names of their KSPACK_ constants.
+var typeNames = map[byte]string{
+	pack.KSPACK_OBJECT:       "OBJECT",
+	pack.KSPACK_ARRAY:        "ARRAY",
+	pack.KSPACK_STRING:       "STRING",
+	pack.KSPACK_SHORT_STRING: "SHORT_STRING",
+	pack.KSPACK_BINARY:       "BINARY",
+	pack.KSPACK_SHORT_BINARY: "SHORT_BINARY",
+	pack.KSPACK_INT8:         "INT8",
+	pack.KSPACK_INT16:        "INT16",
+	pack.KSPACK_INT32:        "INT32",
+	pack.KSPACK_INT64:        "INT64",
+	pack.KSPACK_UINT8:        "UINT8",
+	pack.KSPACK_UINT16:       "UINT16",
+	pack.KSPACK_UINT32:       "UINT32",
+	pack.KSPACK_UINT64:       "UINT64",
+	pack.KSPACK_BOOL:         "BOOL",
+	pack.KSPACK_FLOAT:        "FLOAT",
+	pack.KSPACK_DOUBLE:       "DOUBLE",
+	pack.KSPACK_DATE:         "DATE",
+	pack.KSPACK_ZONED_DATE:   "ZONED_DATE",
+	pack.KSPACK_NULL:         "NULL",
+}
+
+// typeName returns the name and code of the type typ, e.g. "INT64(0x18)".
+func typeName(typ byte) string {
+	return fmt.Sprintf("%s(0x%02x)", typeNames[typ], typ)
+}
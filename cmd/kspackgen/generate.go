@@ -0,0 +1,916 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/types"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	packPath  = "github.com/kubeservice-stack/kspack-go/pack"
+	genrtPath = packPath + "/genrt"
+)
+
+// keyMaxLen mirrors pack.KSPACK_KEY_MAX_LEN.
+const keyMaxLen = 254
+
+// A structField is a field of a struct type as the reflective encoder
+// sees it.
+type structField struct {
+	name      string // object key
+	goName    string
+	typ       types.Type
+	omitEmpty bool
+	omitZero  bool
+	quoted    bool
+	required  bool
+}
+
+type generator struct {
+	pkg     *types.Package
+	named   map[*types.Named]bool // the types methods are generated for
+	imports map[string]string     // import path -> package name
+	buf     bytes.Buffer          // body of the function being generated
+	last    int                   // number of the last fresh identifier
+	usesErr bool                  // whether the body assigns err
+}
+
+// generate returns the source of the methods of the struct types names
+// of pkg.
+func generate(pkg *types.Package, names []string) ([]byte, error) {
+	g := &generator{
+		pkg:     pkg,
+		named:   make(map[*types.Named]bool),
+		imports: map[string]string{packPath: "pack", genrtPath: "genrt"},
+	}
+	var list []*types.Named
+	for _, name := range names {
+		tn, ok := pkg.Scope().Lookup(name).(*types.TypeName)
+		if !ok {
+			return nil, fmt.Errorf("type %s not found in package %s", name, pkg.Name())
+		}
+		n, ok := tn.Type().(*types.Named)
+		if !ok || tn.IsAlias() {
+			return nil, fmt.Errorf("%s is not a defined type", name)
+		}
+		if _, ok := n.Underlying().(*types.Struct); !ok {
+			return nil, fmt.Errorf("%s is not a struct type", name)
+		}
+		if n.TypeParams().Len() > 0 {
+			return nil, fmt.Errorf("%s: generic types are not supported", name)
+		}
+		g.named[n] = true
+		list = append(list, n)
+	}
+
+	var body bytes.Buffer
+	for _, n := range list {
+		if err := g.genType(&body, n); err != nil {
+			return nil, err
+		}
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "%s -type %s; DO NOT EDIT.\n\n", generatedHeader, strings.Join(names, ","))
+	fmt.Fprintf(&src, "package %s\n\nimport (\n", pkg.Name())
+	// standard packages first
+	var std, other []string
+	for path := range g.imports {
+		if strings.Contains(strings.SplitN(path, "/", 2)[0], ".") {
+			other = append(other, path)
+		} else {
+			std = append(std, path)
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(other)
+	for _, path := range std {
+		fmt.Fprintf(&src, "%q\n", path)
+	}
+	if len(std) > 0 && len(other) > 0 {
+		fmt.Fprintf(&src, "\n")
+	}
+	for _, path := range other {
+		fmt.Fprintf(&src, "%q\n", path)
+	}
+	fmt.Fprintf(&src, ")\n\n")
+	src.Write(body.Bytes())
+	return format.Source(src.Bytes())
+}
+
+// fields returns the fields of the struct type n that kspack encodes.
+func (g *generator) fields(n *types.Named) ([]structField, error) {
+	st := n.Underlying().(*types.Struct)
+	var fields []structField
+	seen := make(map[string]bool)
+	for i := 0; i < st.NumFields(); i++ {
+		v := st.Field(i)
+		if !v.Exported() {
+			continue
+		}
+		tag := reflect.StructTag(st.Tag(i))
+		s, ok := tag.Lookup("kspack")
+		if !ok {
+			s = tag.Get("json")
+		}
+		if s == "-" {
+			continue
+		}
+		name, opts := parseTag(s)
+		if v.Embedded() {
+			return nil, fmt.Errorf("%s.%s: embedded fields are not supported", n.Obj().Name(), v.Name())
+		}
+		if opts.contains("inline") {
+			return nil, fmt.Errorf("%s.%s: inline fields are not supported", n.Obj().Name(), v.Name())
+		}
+		if !isValidTag(name) {
+			name = v.Name()
+		}
+		if len(name) > keyMaxLen {
+			return nil, fmt.Errorf("%s.%s: key exceeds %d bytes", n.Obj().Name(), v.Name(), keyMaxLen)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s.%s: duplicate key %q", n.Obj().Name(), v.Name(), name)
+		}
+		seen[name] = true
+
+		quoted := false
+		if opts.contains("string") {
+			ft := v.Type()
+			if p, ok := ft.(*types.Pointer); ok {
+				ft = p.Elem()
+			}
+			if b, ok := ft.Underlying().(*types.Basic); ok {
+				quoted = b.Info()&(types.IsBoolean|types.IsInteger|types.IsFloat) != 0
+			}
+		}
+		fields = append(fields, structField{
+			name:      name,
+			goName:    v.Name(),
+			typ:       v.Type(),
+			omitEmpty: opts.contains("omitempty"),
+			omitZero:  opts.contains("omitzero"),
+			quoted:    quoted,
+			required:  opts.contains("required"),
+		})
+	}
+	return fields, nil
+}
+
+// genType writes the methods of the struct type n to w.
+func (g *generator) genType(w *bytes.Buffer, n *types.Named) error {
+	fields, err := g.fields(n)
+	if err != nil {
+		return err
+	}
+	name := n.Obj().Name()
+
+	g.reset()
+	g.printf("var start int\nb, start = genrt.BeginObject(b, k)\nn := 0\n")
+	for _, f := range fields {
+		expr := "x." + f.goName
+		path := []string{strconv.Quote(f.name)}
+		var conds []string
+		if f.omitEmpty {
+			if c := emptyCond(expr, f.typ); c != "" {
+				conds = append(conds, c)
+			}
+		}
+		if f.omitZero {
+			c, err := g.zeroCond(expr, f.typ)
+			if err != nil {
+				return fmt.Errorf("%s.%s: %v", name, f.goName, err)
+			}
+			conds = append(conds, c)
+		}
+		if len(conds) > 0 {
+			g.printf("if %s {\n", not(strings.Join(conds, " || ")))
+		}
+		if f.quoted {
+			g.encodeQuoted(expr, f.typ, strconv.Quote(f.name))
+		} else {
+			g.encode(expr, f.typ, strconv.Quote(f.name), true, path)
+		}
+		g.printf("n++\n")
+		if len(conds) > 0 {
+			g.printf("}\n")
+		}
+	}
+	g.printf("return genrt.EndContainer(b, start, n), nil\n")
+
+	fmt.Fprintf(w, "// MarshalKSPACK implements pack.Marshaler.\n")
+	fmt.Fprintf(w, "func (x %s) MarshalKSPACK() ([]byte, error) {\nreturn pack.Marshal(&x)\n}\n\n", name)
+	fmt.Fprintf(w, "// EncodeKSPACK appends the item of x under the key k at the end of b\n// within e, the state of the pack.Marshal call running it.\n")
+	fmt.Fprintf(w, "func (x *%s) EncodeKSPACK(e genrt.Encoder, b []byte, k string) ([]byte, error) {\n", name)
+	fmt.Fprintf(w, "if err := genrt.Enter(e, x); err != nil {\nreturn b, err\n}\n")
+	fmt.Fprintf(w, "b, err := x.appendKSPACK(e, b, k)\nreturn b, genrt.Leave(e, x, err)\n}\n\n")
+	fmt.Fprintf(w, "func (x *%s) appendKSPACK(e genrt.Encoder, b []byte, k string) ([]byte, error) {\n", name)
+	if g.usesErr {
+		fmt.Fprintf(w, "var err error\n")
+	}
+	w.Write(g.buf.Bytes())
+	fmt.Fprintf(w, "}\n\n")
+
+	g.reset()
+	g.printf("switch genrt.ItemType(data, off) {\ncase pack.KSPACK_NULL:\n*x = %s{}\nreturn nil\n", name)
+	g.printf("case pack.KSPACK_OBJECT:\ndefault:\nreturn genrt.TypeError(data, off, %s)\n}\n", g.typeOf(n))
+	required := false
+	for _, f := range fields {
+		required = required || f.required
+	}
+	if required {
+		g.printf("var seen [%d]bool\n", len(fields))
+	}
+	g.printf("err := genrt.Members(data, off, func(key []byte, o int) error {\n")
+	unknown := fmt.Sprintf("genrt.UnknownField(d, %s, key, o)\n", g.typeOf(n))
+	if len(fields) == 0 {
+		g.printf("%s", unknown)
+	} else {
+		g.printf("f := -1\nswitch string(key) {\n")
+		for i, f := range fields {
+			g.printf("case %q:\nf = %d\n", f.name, i)
+		}
+		g.imports["bytes"] = "bytes"
+		g.printf("default:\nswitch {\n")
+		for i, f := range fields {
+			g.printf("case bytes.EqualFold(key, []byte(%q)):\nf = %d\n", f.name, i)
+		}
+		g.printf("}\n}\nswitch f {\n")
+		for i, f := range fields {
+			g.printf("case %d:\n", i)
+			if f.required {
+				g.printf("seen[%d] = true\n", i)
+			}
+			expr := "x." + f.goName
+			path := []string{strconv.Quote(f.name)}
+			if f.quoted {
+				g.decodeQuoted(expr, f.typ, "o", path)
+			} else {
+				g.decode(expr, f.typ, "o", path)
+			}
+		}
+		g.printf("default:\n%s}\n", unknown)
+	}
+	g.printf("return nil\n})\nif err != nil {\nreturn err\n}\n")
+	for i, f := range fields {
+		if f.required {
+			g.printf("if !seen[%d] {\nreturn &pack.MissingFieldError{Key: %q, Type: %s}\n}\n", i, f.name, g.typeOf(n))
+		}
+	}
+	g.printf("return nil\n")
+
+	fmt.Fprintf(w, "// UnmarshalKSPACK implements pack.Unmarshaler.\n")
+	fmt.Fprintf(w, "func (x *%s) UnmarshalKSPACK(data []byte) error {\nreturn pack.Unmarshal(data, x)\n}\n\n", name)
+	fmt.Fprintf(w, "// DecodeKSPACK decodes the item at off within d, the state of the\n// pack.Unmarshal call running it.\n")
+	fmt.Fprintf(w, "func (x *%s) DecodeKSPACK(d genrt.Decoder, off int) error {\ndata := d.Data()\n", name)
+	w.Write(g.buf.Bytes())
+	fmt.Fprintf(w, "}\n\n")
+	return nil
+}
+
+func (g *generator) reset() {
+	g.buf.Reset()
+	g.last = 0
+	g.usesErr = false
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// fresh returns a new identifier starting with prefix.
+func (g *generator) fresh(prefix string) string {
+	g.last++
+	return prefix + strconv.Itoa(g.last)
+}
+
+// typeString returns the Go syntax of t in the generated file.
+func (g *generator) typeString(t types.Type) string {
+	return types.TypeString(t, func(p *types.Package) string {
+		if p == g.pkg {
+			return ""
+		}
+		g.imports[p.Path()] = p.Name()
+		return p.Name()
+	})
+}
+
+// typeOf returns an expression of the reflect.Type of t.
+func (g *generator) typeOf(t types.Type) string {
+	g.imports["reflect"] = "reflect"
+	return "reflect.TypeOf((*" + g.typeString(t) + ")(nil)).Elem()"
+}
+
+// convert returns the expression x of basic type from converted to t.
+func (g *generator) convert(x string, from types.BasicKind, t types.Type) string {
+	if types.Identical(t, types.Typ[from]) {
+		return x
+	}
+	return g.typeString(t) + "(" + unparen(x) + ")"
+}
+
+// unparen strips the parentheses around the dereference x.
+func unparen(x string) string {
+	if strings.HasPrefix(x, "(*") && strings.HasSuffix(x, ")") {
+		return x[1 : len(x)-1]
+	}
+	return x
+}
+
+// addrOf returns the address of the addressable expr.
+func addrOf(expr string) string {
+	if strings.HasPrefix(expr, "(*") && strings.HasSuffix(expr, ")") {
+		return expr[2 : len(expr)-1]
+	}
+	return "&" + expr
+}
+
+// receiver returns the expression to call the pointer methods of the
+// addressable expr on.
+func receiver(expr string) string {
+	if strings.HasPrefix(expr, "(*") && strings.HasSuffix(expr, ")") {
+		return expr[2 : len(expr)-1]
+	}
+	return expr
+}
+
+// not returns the negation of the condition c.
+func not(c string) string {
+	switch {
+	case strings.Contains(c, " || "):
+		return "!(" + c + ")"
+	case strings.HasPrefix(c, "!"):
+		return c[1:]
+	case strings.Count(c, " == ") == 1:
+		return strings.Replace(c, " == ", " != ", 1)
+	}
+	return "!" + c
+}
+
+// indexPath returns the path element of the array index i.
+func (g *generator) indexPath(i string) string {
+	g.imports["strconv"] = "strconv"
+	return `"[" + strconv.Itoa(` + i + `) + "]"`
+}
+
+// wrap returns the expression of the error err raised at path.
+func wrap(err string, path []string) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		err = "genrt.ErrorAt(" + err + ", " + path[i] + ")"
+	}
+	return err
+}
+
+// with returns path extended by elem, leaving path as is.
+func with(path []string, elem string) []string {
+	return append(path[:len(path):len(path)], elem)
+}
+
+// encode writes the statements appending the value expr of type t
+// under the key expression key. addr tells whether the reflective
+// encoder would see expr as addressable, and so call the marshaling
+// methods of its pointer.
+func (g *generator) encode(expr string, t types.Type, key string, addr bool, path []string) {
+	if g.isNamed(t) {
+		g.usesErr = true
+		g.printf("if b, err = %s.EncodeKSPACK(e, b, %s); err != nil {\nreturn b, %s\n}\n", receiver(expr), key, wrap("err", path))
+		return
+	}
+	if isTime(t) {
+		g.usesErr = true
+		g.printf("if b, err = genrt.AppendTime(b, %s, %s); err != nil {\nreturn b, %s\n}\n", key, unparen(expr), wrap("err", path))
+		return
+	}
+	if p, ok := t.(*types.Pointer); !ok || !g.isNamed(p.Elem()) && !isTime(p.Elem()) {
+		if marshals(t, addr) {
+			g.encodeValue(expr, t, key, addr, path)
+			return
+		}
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		var fn string
+		var kind types.BasicKind
+		switch u.Kind() {
+		case types.Bool:
+			fn, kind = "AppendBool", types.Bool
+		case types.Int, types.Int64:
+			fn, kind = "AppendInt64", types.Int64
+		case types.Int8:
+			fn, kind = "AppendInt8", types.Int8
+		case types.Int16:
+			fn, kind = "AppendInt16", types.Int16
+		case types.Int32:
+			fn, kind = "AppendInt32", types.Int32
+		case types.Uint, types.Uint64, types.Uintptr:
+			fn, kind = "AppendUint64", types.Uint64
+		case types.Uint8:
+			fn, kind = "AppendUint8", types.Uint8
+		case types.Uint16:
+			fn, kind = "AppendUint16", types.Uint16
+		case types.Uint32:
+			fn, kind = "AppendUint32", types.Uint32
+		case types.Float32:
+			fn, kind = "AppendFloat32", types.Float32
+		case types.Float64:
+			fn, kind = "AppendFloat64", types.Float64
+		case types.String:
+			fn, kind = "AppendString", types.String
+		default:
+			g.encodeValue(expr, t, key, addr, path)
+			return
+		}
+		g.printf("b = genrt.%s(b, %s, %s)\n", fn, key, g.convertTo(expr, t, kind))
+	case *types.Pointer:
+		g.printf("if %s == nil {\nb = genrt.AppendNull(b, %s)\n} else {\n", expr, key)
+		g.encode("(*"+expr+")", u.Elem(), key, true, path)
+		g.printf("}\n")
+	case *types.Slice:
+		switch {
+		case isByte(u.Elem()):
+			if _, ok := t.(*types.Named); ok {
+				expr = "[]byte(" + expr + ")"
+			}
+			g.printf("b = genrt.AppendBinary(b, %s, %s)\n", key, expr)
+		case isUint8(u.Elem()):
+			g.encodeValue(expr, t, key, addr, path)
+		default:
+			g.encodeElems(expr, u.Elem(), key, true, path)
+		}
+	case *types.Array:
+		g.encodeElems(expr, u.Elem(), key, addr, path)
+	case *types.Map:
+		k, v := g.fresh("k"), g.fresh("v")
+		var ks string
+		kb, ok := u.Key().Underlying().(*types.Basic)
+		switch {
+		case !ok:
+		case kb.Info()&types.IsString != 0:
+			ks = g.convertTo(k, u.Key(), types.String)
+		case marshals(u.Key(), false):
+		case kb.Info()&types.IsInteger != 0:
+			g.imports["strconv"] = "strconv"
+			if kb.Info()&types.IsUnsigned != 0 {
+				ks = "strconv.FormatUint(uint64(" + k + "), 10)"
+			} else {
+				ks = "strconv.FormatInt(int64(" + k + "), 10)"
+			}
+		case kb.Kind() == types.Bool:
+			g.imports["strconv"] = "strconv"
+			ks = "strconv.FormatBool(bool(" + k + "))"
+		}
+		if ks == "" {
+			g.encodeValue(expr, t, key, addr, path)
+			return
+		}
+		s, mk := g.fresh("start"), g.fresh("key")
+		g.usesErr = true
+		g.printf("var %s int\nb, %s = genrt.BeginObject(b, %s)\n", s, s, key)
+		g.printf("for %s, %s := range %s {\n%s := %s\n", k, v, expr, mk, ks)
+		g.printf("if err = genrt.CheckKey(%s); err != nil {\nreturn b, %s\n}\n", mk, wrap("err", path))
+		g.encode(v, u.Elem(), mk, false, with(path, mk))
+		g.printf("}\nb = genrt.EndContainer(b, %s, len(%s))\n", s, expr)
+	default:
+		g.encodeValue(expr, t, key, addr, path)
+	}
+}
+
+// convertTo returns the expression x of type t converted to the basic
+// type kind.
+func (g *generator) convertTo(x string, t types.Type, kind types.BasicKind) string {
+	if types.Identical(t, types.Typ[kind]) {
+		return x
+	}
+	return types.Typ[kind].Name() + "(" + unparen(x) + ")"
+}
+
+// encodeElems writes the statements appending the slice or array expr
+// as an ARRAY item.
+func (g *generator) encodeElems(expr string, elem types.Type, key string, addr bool, path []string) {
+	s, i := g.fresh("start"), g.fresh("i")
+	g.printf("var %s int\nb, %s = genrt.BeginArray(b, %s)\n", s, s, key)
+	g.printf("for %s := range %s {\n", i, expr)
+	g.encode(expr+"["+i+"]", elem, `""`, addr, with(path, g.indexPath(i)))
+	g.printf("}\nb = genrt.EndContainer(b, %s, len(%s))\n", s, expr)
+}
+
+// encodeValue writes the statements appending expr through the
+// reflective encoder.
+func (g *generator) encodeValue(expr string, t types.Type, key string, addr bool, path []string) {
+	if addr && !isPointer(t) && !isInterface(t) {
+		expr = addrOf(expr)
+	}
+	g.usesErr = true
+	g.printf("if b, err = genrt.AppendValue(e, b, %s, %s); err != nil {\nreturn b, %s\n}\n", key, expr, wrap("err", path))
+}
+
+// encodeQuoted writes the statements appending the bool or number expr
+// of a field tagged ",string" as a string.
+func (g *generator) encodeQuoted(expr string, t types.Type, key string) {
+	if p, ok := t.(*types.Pointer); ok {
+		g.printf("if %s == nil {\nb = genrt.AppendNull(b, %s)\n} else {\n", expr, key)
+		g.encodeQuoted("(*"+expr+")", p.Elem(), key)
+		g.printf("}\n")
+		return
+	}
+	g.imports["strconv"] = "strconv"
+	b := t.Underlying().(*types.Basic)
+	var s string
+	switch {
+	case b.Info()&types.IsBoolean != 0:
+		s = "strconv.FormatBool(" + g.convertTo(expr, t, types.Bool) + ")"
+	case b.Info()&types.IsUnsigned != 0:
+		s = "strconv.FormatUint(" + g.convertTo(expr, t, types.Uint64) + ", 10)"
+	case b.Info()&types.IsInteger != 0:
+		s = "strconv.FormatInt(" + g.convertTo(expr, t, types.Int64) + ", 10)"
+	case b.Kind() == types.Float32:
+		s = "strconv.FormatFloat(float64(" + expr + "), 'g', -1, 32)"
+	default:
+		s = "strconv.FormatFloat(" + g.convertTo(expr, t, types.Float64) + ", 'g', -1, 64)"
+	}
+	g.printf("b = genrt.AppendString(b, %s, %s)\n", key, s)
+}
+
+// decode writes the statements decoding the item at the offset off of
+// data into the addressable expr of type t.
+func (g *generator) decode(expr string, t types.Type, off string, path []string) {
+	fail := func(err string) string {
+		return "return " + wrap(err, path)
+	}
+	lhs := unparen(expr)
+	switch {
+	case g.isNamed(t):
+		g.printf("if err := %s.DecodeKSPACK(d, %s); err != nil {\n%s\n}\n", receiver(expr), off, fail("err"))
+		return
+	case isTime(t):
+		g.printf("if err := genrt.DecodeTime(data, %s, %s); err != nil {\n%s\n}\n", off, addrOf(expr), fail("err"))
+		return
+	case !isPointer(t) && unmarshals(t):
+		g.decodeValue(expr, off, path)
+		return
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		var call string
+		var kind types.BasicKind
+		switch i := u.Info(); {
+		case i&types.IsBoolean != 0:
+			call, kind = "DecodeBool(data, "+off+")", types.Bool
+		case i&types.IsInteger != 0:
+			bits := g.bits(u)
+			if i&types.IsUnsigned != 0 {
+				call, kind = "DecodeUint(data, "+off+", "+bits+")", types.Uint64
+			} else {
+				call, kind = "DecodeInt(data, "+off+", "+bits+")", types.Int64
+			}
+		case i&types.IsFloat != 0:
+			call, kind = "DecodeFloat(data, "+off+", "+g.bits(u)+")", types.Float64
+		case i&types.IsString != 0:
+			call, kind = "DecodeText(d, "+off+")", types.String
+		default:
+			g.decodeValue(expr, off, path)
+			return
+		}
+		v := g.fresh("v")
+		g.printf("if %s, ok := genrt.%s; ok {\n%s = %s\n} else ", v, call, lhs, g.convert(v, kind, t))
+		g.decodeValue(expr, off, path)
+	case *types.Pointer:
+		g.printf("if genrt.ItemType(data, %s) != pack.KSPACK_NULL || %s != nil {\n", off, expr)
+		g.printf("if %s == nil {\n%s\n%s = new(%s)\n}\n", expr, alloc(off, g.sizeof("*"+expr)), expr, g.typeString(u.Elem()))
+		g.decode("(*"+expr+")", u.Elem(), off, path)
+		g.printf("}\n")
+	case *types.Slice:
+		switch {
+		case isByte(u.Elem()):
+			v := g.fresh("v")
+			g.printf("if %s, ok := genrt.DecodeBlob(d, %s); ok {\n%s = %s\n} else ", v, off, lhs, g.convertSlice(v, t))
+			g.decodeValue(expr, off, path)
+			return
+		case isUint8(u.Elem()):
+			g.decodeValue(expr, off, path)
+			return
+		}
+		n, i, o := g.fresh("n"), g.fresh("i"), g.fresh("o")
+		ts := g.typeString(t)
+		g.printf("switch genrt.ItemType(data, %s) {\ncase pack.KSPACK_ARRAY:\n", off)
+		g.printf("%s := genrt.ItemCount(data, %s)\n", n, off)
+		g.printf("if %s > cap(%s) {\n%s\n%s = make(%s, %s)\n}\n%s = %s[:%s]\n", n, lhs, alloc(off, n+"*"+g.sizeof(expr+"[0]")), lhs, ts, n, lhs, expr, n)
+		g.printf("if %s == 0 {\n%s = %s{}\n}\n", n, lhs, ts)
+		g.printf("if err := genrt.Elements(data, %s, func(%s, %s int) error {\n", off, i, o)
+		g.decode(expr+"["+i+"]", u.Elem(), o, with(path, g.indexPath(i)))
+		g.printf("return nil\n}); err != nil {\nreturn err\n}\n")
+		g.printf("case pack.KSPACK_NULL:\n%s = nil\ndefault:\n", lhs)
+		g.decodeValue(expr, off, path)
+		g.printf("}\n")
+	case *types.Array:
+		n, i, o, z := g.fresh("n"), g.fresh("i"), g.fresh("o"), g.fresh("z")
+		g.printf("switch genrt.ItemType(data, %s) {\ncase pack.KSPACK_ARRAY:\n", off)
+		g.printf("%s := genrt.ItemCount(data, %s)\n", n, off)
+		g.printf("if err := genrt.Elements(data, %s, func(%s, %s int) error {\n", off, i, o)
+		g.printf("if %s < len(%s) {\n", i, expr)
+		g.decode(expr+"["+i+"]", u.Elem(), o, with(path, g.indexPath(i)))
+		g.printf("}\nreturn nil\n}); err != nil {\nreturn err\n}\n")
+		g.printf("for ; %s < len(%s); %s++ {\nvar %s %s\n%s[%s] = %s\n}\n", n, expr, n, z, g.typeString(u.Elem()), expr, n, z)
+		g.printf("case pack.KSPACK_NULL:\n%s = %s{}\ndefault:\n", lhs, g.typeString(t))
+		g.decodeValue(expr, off, path)
+		g.printf("}\n")
+	case *types.Map:
+		kb, ok := u.Key().Underlying().(*types.Basic)
+		if !ok || kb.Info()&types.IsString == 0 || unmarshals(u.Key()) {
+			g.decodeValue(expr, off, path)
+			return
+		}
+		k, o, v := g.fresh("k"), g.fresh("o"), g.fresh("v")
+		g.printf("switch genrt.ItemType(data, %s) {\ncase pack.KSPACK_OBJECT:\n", off)
+		g.printf("if %s == nil {\n%s = make(%s)\n}\n", lhs, lhs, g.typeString(t))
+		g.printf("if err := genrt.Members(data, %s, func(%s []byte, %s int) error {\n", off, k, o)
+		g.printf("var %s %s\n", v, g.typeString(u.Elem()))
+		g.decode(v, u.Elem(), o, with(path, "string("+k+")"))
+		g.printf("%s\n", alloc(o, g.sizeof(`""`)+"+"+g.sizeof(v)))
+		g.printf("%s[%s] = %s\nreturn nil\n}); err != nil {\nreturn err\n}\n", expr, g.convert("genrt.Key(d, "+k+", "+o+")", types.String, u.Key()), v)
+		g.printf("case pack.KSPACK_NULL:\n%s = nil\ndefault:\n", lhs)
+		g.decodeValue(expr, off, path)
+		g.printf("}\n")
+	default:
+		g.decodeValue(expr, off, path)
+	}
+}
+
+// bits returns the expression of the bit size of the number type b.
+func (g *generator) bits(b *types.Basic) string {
+	switch b.Kind() {
+	case types.Int, types.Uint, types.Uintptr:
+		g.imports["strconv"] = "strconv"
+		return "strconv.IntSize"
+	case types.Int8, types.Uint8:
+		return "8"
+	case types.Int16, types.Uint16:
+		return "16"
+	case types.Int32, types.Uint32, types.Float32:
+		return "32"
+	}
+	return "64"
+}
+
+// convertSlice returns the []byte x converted to t, a byte slice or a
+// string type.
+func (g *generator) convertSlice(x string, t types.Type) string {
+	if types.Identical(t, types.NewSlice(types.Typ[types.Byte])) {
+		return x
+	}
+	return g.typeString(t) + "(" + x + ")"
+}
+
+// decodeValue writes the statements decoding the item at off into expr
+// through the reflective decoder.
+func (g *generator) decodeValue(expr string, off string, path []string) {
+	g.printf("if err := genrt.UnmarshalItem(d, %s, %s); err != nil {\nreturn %s\n}\n", off, addrOf(expr), wrap("err", path))
+}
+
+// alloc returns the statement accounting for size bytes about to be
+// allocated for the item at off, as the reflective decoder does.
+func alloc(off, size string) string {
+	return "genrt.Alloc(d, " + off + ", " + size + ")"
+}
+
+// sizeof returns the int expression of the size of the type of x.
+func (g *generator) sizeof(x string) string {
+	g.imports["unsafe"] = "unsafe"
+	return "int(unsafe.Sizeof(" + x + "))"
+}
+
+// decodeQuoted writes the statements decoding the item at off into the
+// bool or number expr of a field tagged ",string".
+func (g *generator) decodeQuoted(expr string, t types.Type, off string, path []string) {
+	s := g.fresh("s")
+	g.printf("if %s, ok := genrt.DecodeQuoted(data, %s); ok {\n", s, off)
+	target, tt := expr, t
+	if p, ok := t.(*types.Pointer); ok {
+		g.printf("if %s == nil {\n%s = new(%s)\n}\n", expr, expr, g.typeString(p.Elem()))
+		target, tt = "(*"+expr+")", p.Elem()
+	}
+	g.imports["strconv"] = "strconv"
+	b := tt.Underlying().(*types.Basic)
+	v := g.fresh("v")
+	var kind types.BasicKind
+	switch {
+	case b.Info()&types.IsBoolean != 0:
+		g.printf("%s, err := strconv.ParseBool(%s)\n", v, s)
+		kind = types.Bool
+	case b.Info()&types.IsUnsigned != 0:
+		g.printf("%s, err := strconv.ParseUint(%s, 10, %s)\n", v, s, g.bits(b))
+		kind = types.Uint64
+	case b.Info()&types.IsInteger != 0:
+		g.printf("%s, err := strconv.ParseInt(%s, 10, %s)\n", v, s, g.bits(b))
+		kind = types.Int64
+	default:
+		g.printf("%s, err := strconv.ParseFloat(%s, %s)\n", v, s, g.bits(b))
+		kind = types.Float64
+	}
+	g.printf("if err != nil {\nreturn genrt.QuotedError(%s, %s, %s)\n}\n", s, off, g.typeOf(t))
+	g.printf("%s = %s\n} else {\n", unparen(target), g.convert(v, kind, tt))
+	g.decode(expr, t, off, path)
+	g.printf("}\n")
+}
+
+// emptyCond returns the condition under which the reflective encoder
+// omits expr of type t for ",omitempty", or "" if it never does.
+func emptyCond(expr string, t types.Type) string {
+	switch u := t.Underlying().(type) {
+	case *types.Array, *types.Map, *types.Slice:
+		return "len(" + expr + ") == 0"
+	case *types.Pointer, *types.Interface:
+		return expr + " == nil"
+	case *types.Basic:
+		switch i := u.Info(); {
+		case u.Kind() == types.Uintptr:
+		case i&types.IsString != 0:
+			return "len(" + expr + ") == 0"
+		case i&types.IsBoolean != 0:
+			return "!" + expr
+		case i&(types.IsInteger|types.IsFloat) != 0:
+			return expr + " == 0"
+		}
+	}
+	return ""
+}
+
+// zeroCond returns the condition under which the reflective encoder
+// omits expr of type t for ",omitzero".
+func (g *generator) zeroCond(expr string, t types.Type) (string, error) {
+	switch {
+	case isInterface(t) && hasMethod(t, "IsZero", "func() (bool)"):
+		return "", fmt.Errorf("omitzero on an interface with an IsZero method is not supported")
+	case isPointer(t) && hasMethod(t, "IsZero", "func() (bool)"):
+		return expr + " == nil || " + expr + ".IsZero()", nil
+	case hasMethod(t, "IsZero", "func() (bool)"), hasMethod(types.NewPointer(t), "IsZero", "func() (bool)"):
+		return expr + ".IsZero()", nil
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch i := u.Info(); {
+		case i&types.IsBoolean != 0:
+			return "!" + expr, nil
+		case i&types.IsString != 0:
+			return expr + ` == ""`, nil
+		case i&types.IsInteger != 0:
+			return expr + " == 0", nil
+		case i&types.IsFloat != 0:
+			// -0 is not zero
+			g.imports["math"] = "math"
+			return "math.Float64bits(float64(" + expr + ")) == 0", nil
+		case u.Kind() == types.UnsafePointer:
+			return expr + " == nil", nil
+		}
+	case *types.Pointer, *types.Slice, *types.Map, *types.Chan, *types.Signature, *types.Interface:
+		return expr + " == nil", nil
+	}
+	g.imports["reflect"] = "reflect"
+	return "reflect.ValueOf(" + expr + ").IsZero()", nil
+}
+
+// isNamed reports whether t is one of the types methods are generated
+// for.
+func (g *generator) isNamed(t types.Type) bool {
+	n, ok := t.(*types.Named)
+	return ok && g.named[n]
+}
+
+func isTime(t types.Type) bool {
+	n, ok := t.(*types.Named)
+	return ok && n.Obj().Pkg() != nil && n.Obj().Pkg().Path() == "time" && n.Obj().Name() == "Time"
+}
+
+func isPointer(t types.Type) bool {
+	_, ok := t.Underlying().(*types.Pointer)
+	return ok
+}
+
+func isInterface(t types.Type) bool {
+	return types.IsInterface(t)
+}
+
+// isByte reports whether t is byte itself.
+func isByte(t types.Type) bool {
+	return types.Identical(t, types.Typ[types.Byte])
+}
+
+// isUint8 reports whether t is of kind uint8, which the reflective
+// encoder writes slices of as binaries.
+func isUint8(t types.Type) bool {
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Kind() == types.Uint8
+}
+
+// hasMethod reports whether the method set of t holds a method name of
+// signature sig, written without parameter names.
+func hasMethod(t types.Type, name, sig string) bool {
+	sel := types.NewMethodSet(t).Lookup(nil, name)
+	if sel == nil {
+		return false
+	}
+	st := sel.Type().(*types.Signature)
+	return "func"+tupleString(st.Params())+" "+tupleString(st.Results()) == sig
+}
+
+// tupleString returns the types of t in parentheses.
+func tupleString(t *types.Tuple) string {
+	s := make([]string, t.Len())
+	for i := range s {
+		s[i] = types.TypeString(t.At(i).Type(), nil)
+	}
+	return "(" + strings.Join(s, ", ") + ")"
+}
+
+// marshals reports whether the reflective encoder writes values of
+// type t with a marshaling method, also looking at the methods of *t
+// if the value is addressable.
+func marshals(t types.Type, addr bool) bool {
+	for _, name := range []string{"MarshalKSPACK", "MarshalText", "MarshalBinary"} {
+		if hasMethod(t, name, "func() ([]byte, error)") {
+			return true
+		}
+		if addr && !isPointer(t) && !isInterface(t) && hasMethod(types.NewPointer(t), name, "func() ([]byte, error)") {
+			return true
+		}
+	}
+	return false
+}
+
+// unmarshals reports whether the reflective decoder goes through an
+// unmarshaling method of *t, or the dynamic value of the interface t.
+func unmarshals(t types.Type) bool {
+	if isInterface(t) {
+		return true
+	}
+	for _, name := range []string{"UnmarshalKSPACK", "UnmarshalText", "UnmarshalBinary"} {
+		if hasMethod(types.NewPointer(t), name, "func([]byte) (error)") {
+			return true
+		}
+	}
+	return false
+}
+
+// tagOptions is the string following a comma in a struct field's tag,
+// or the empty string.
+type tagOptions string
+
+func parseTag(tag string) (string, tagOptions) {
+	if i := strings.Index(tag, ","); i != -1 {
+		return tag[:i], tagOptions(tag[i+1:])
+	}
+	return tag, ""
+}
+
+func (o tagOptions) contains(name string) bool {
+	for s := string(o); s != ""; {
+		var next string
+		if i := strings.Index(s, ","); i >= 0 {
+			s, next = s[:i], s[i+1:]
+		}
+		if s == name {
+			return true
+		}
+		s = next
+	}
+	return false
+}
+
+// isValidTag mirrors the check of the reflective encoder on tag names.
+func isValidTag(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:<=>?@[]^_{|}~ ", c):
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,1461 @@
+// Code generated by kspackgen -type Scalars,Order,Item,Options,Node,Tagged; DO NOT EDIT.
+
+package parity
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+	"unsafe"
+
+	"github.com/kubeservice-stack/kspack-go/pack"
+	"github.com/kubeservice-stack/kspack-go/pack/genrt"
+)
+
+// MarshalKSPACK implements pack.Marshaler.
+func (x Scalars) MarshalKSPACK() ([]byte, error) {
+	return pack.Marshal(&x)
+}
+
+// EncodeKSPACK appends the item of x under the key k at the end of b
+// within e, the state of the pack.Marshal call running it.
+func (x *Scalars) EncodeKSPACK(e genrt.Encoder, b []byte, k string) ([]byte, error) {
+	if err := genrt.Enter(e, x); err != nil {
+		return b, err
+	}
+	b, err := x.appendKSPACK(e, b, k)
+	return b, genrt.Leave(e, x, err)
+}
+
+func (x *Scalars) appendKSPACK(e genrt.Encoder, b []byte, k string) ([]byte, error) {
+	var err error
+	var start int
+	b, start = genrt.BeginObject(b, k)
+	n := 0
+	b = genrt.AppendBool(b, "Bool", x.Bool)
+	n++
+	b = genrt.AppendInt64(b, "Int", int64(x.Int))
+	n++
+	b = genrt.AppendInt8(b, "Int8", x.Int8)
+	n++
+	b = genrt.AppendInt16(b, "Int16", x.Int16)
+	n++
+	b = genrt.AppendInt32(b, "Int32", x.Int32)
+	n++
+	b = genrt.AppendInt64(b, "Int64", x.Int64)
+	n++
+	b = genrt.AppendUint64(b, "Uint", uint64(x.Uint))
+	n++
+	b = genrt.AppendUint8(b, "Uint8", x.Uint8)
+	n++
+	b = genrt.AppendUint16(b, "Uint16", x.Uint16)
+	n++
+	b = genrt.AppendUint32(b, "Uint32", x.Uint32)
+	n++
+	b = genrt.AppendUint64(b, "Uint64", x.Uint64)
+	n++
+	b = genrt.AppendUint64(b, "Uintptr", uint64(x.Uintptr))
+	n++
+	b = genrt.AppendFloat32(b, "Float32", x.Float32)
+	n++
+	b = genrt.AppendFloat64(b, "Float64", x.Float64)
+	n++
+	b = genrt.AppendString(b, "String", x.String)
+	n++
+	b = genrt.AppendBinary(b, "Bytes", x.Bytes)
+	n++
+	b = genrt.AppendInt8(b, "Level", int8(x.Level))
+	n++
+	b = genrt.AppendString(b, "Name", string(x.Name))
+	n++
+	b = genrt.AppendBinary(b, "Blob", []byte(x.Blob))
+	n++
+	if b, err = genrt.AppendTime(b, "Time", x.Time); err != nil {
+		return b, genrt.ErrorAt(err, "Time")
+	}
+	n++
+	return genrt.EndContainer(b, start, n), nil
+}
+
+// UnmarshalKSPACK implements pack.Unmarshaler.
+func (x *Scalars) UnmarshalKSPACK(data []byte) error {
+	return pack.Unmarshal(data, x)
+}
+
+// DecodeKSPACK decodes the item at off within d, the state of the
+// pack.Unmarshal call running it.
+func (x *Scalars) DecodeKSPACK(d genrt.Decoder, off int) error {
+	data := d.Data()
+	switch genrt.ItemType(data, off) {
+	case pack.KSPACK_NULL:
+		*x = Scalars{}
+		return nil
+	case pack.KSPACK_OBJECT:
+	default:
+		return genrt.TypeError(data, off, reflect.TypeOf((*Scalars)(nil)).Elem())
+	}
+	err := genrt.Members(data, off, func(key []byte, o int) error {
+		f := -1
+		switch string(key) {
+		case "Bool":
+			f = 0
+		case "Int":
+			f = 1
+		case "Int8":
+			f = 2
+		case "Int16":
+			f = 3
+		case "Int32":
+			f = 4
+		case "Int64":
+			f = 5
+		case "Uint":
+			f = 6
+		case "Uint8":
+			f = 7
+		case "Uint16":
+			f = 8
+		case "Uint32":
+			f = 9
+		case "Uint64":
+			f = 10
+		case "Uintptr":
+			f = 11
+		case "Float32":
+			f = 12
+		case "Float64":
+			f = 13
+		case "String":
+			f = 14
+		case "Bytes":
+			f = 15
+		case "Level":
+			f = 16
+		case "Name":
+			f = 17
+		case "Blob":
+			f = 18
+		case "Time":
+			f = 19
+		default:
+			switch {
+			case bytes.EqualFold(key, []byte("Bool")):
+				f = 0
+			case bytes.EqualFold(key, []byte("Int")):
+				f = 1
+			case bytes.EqualFold(key, []byte("Int8")):
+				f = 2
+			case bytes.EqualFold(key, []byte("Int16")):
+				f = 3
+			case bytes.EqualFold(key, []byte("Int32")):
+				f = 4
+			case bytes.EqualFold(key, []byte("Int64")):
+				f = 5
+			case bytes.EqualFold(key, []byte("Uint")):
+				f = 6
+			case bytes.EqualFold(key, []byte("Uint8")):
+				f = 7
+			case bytes.EqualFold(key, []byte("Uint16")):
+				f = 8
+			case bytes.EqualFold(key, []byte("Uint32")):
+				f = 9
+			case bytes.EqualFold(key, []byte("Uint64")):
+				f = 10
+			case bytes.EqualFold(key, []byte("Uintptr")):
+				f = 11
+			case bytes.EqualFold(key, []byte("Float32")):
+				f = 12
+			case bytes.EqualFold(key, []byte("Float64")):
+				f = 13
+			case bytes.EqualFold(key, []byte("String")):
+				f = 14
+			case bytes.EqualFold(key, []byte("Bytes")):
+				f = 15
+			case bytes.EqualFold(key, []byte("Level")):
+				f = 16
+			case bytes.EqualFold(key, []byte("Name")):
+				f = 17
+			case bytes.EqualFold(key, []byte("Blob")):
+				f = 18
+			case bytes.EqualFold(key, []byte("Time")):
+				f = 19
+			}
+		}
+		switch f {
+		case 0:
+			if v1, ok := genrt.DecodeBool(data, o); ok {
+				x.Bool = v1
+			} else if err := genrt.UnmarshalItem(d, o, &x.Bool); err != nil {
+				return genrt.ErrorAt(err, "Bool")
+			}
+		case 1:
+			if v2, ok := genrt.DecodeInt(data, o, strconv.IntSize); ok {
+				x.Int = int(v2)
+			} else if err := genrt.UnmarshalItem(d, o, &x.Int); err != nil {
+				return genrt.ErrorAt(err, "Int")
+			}
+		case 2:
+			if v3, ok := genrt.DecodeInt(data, o, 8); ok {
+				x.Int8 = int8(v3)
+			} else if err := genrt.UnmarshalItem(d, o, &x.Int8); err != nil {
+				return genrt.ErrorAt(err, "Int8")
+			}
+		case 3:
+			if v4, ok := genrt.DecodeInt(data, o, 16); ok {
+				x.Int16 = int16(v4)
+			} else if err := genrt.UnmarshalItem(d, o, &x.Int16); err != nil {
+				return genrt.ErrorAt(err, "Int16")
+			}
+		case 4:
+			if v5, ok := genrt.DecodeInt(data, o, 32); ok {
+				x.Int32 = int32(v5)
+			} else if err := genrt.UnmarshalItem(d, o, &x.Int32); err != nil {
+				return genrt.ErrorAt(err, "Int32")
+			}
+		case 5:
+			if v6, ok := genrt.DecodeInt(data, o, 64); ok {
+				x.Int64 = v6
+			} else if err := genrt.UnmarshalItem(d, o, &x.Int64); err != nil {
+				return genrt.ErrorAt(err, "Int64")
+			}
+		case 6:
+			if v7, ok := genrt.DecodeUint(data, o, strconv.IntSize); ok {
+				x.Uint = uint(v7)
+			} else if err := genrt.UnmarshalItem(d, o, &x.Uint); err != nil {
+				return genrt.ErrorAt(err, "Uint")
+			}
+		case 7:
+			if v8, ok := genrt.DecodeUint(data, o, 8); ok {
+				x.Uint8 = uint8(v8)
+			} else if err := genrt.UnmarshalItem(d, o, &x.Uint8); err != nil {
+				return genrt.ErrorAt(err, "Uint8")
+			}
+		case 8:
+			if v9, ok := genrt.DecodeUint(data, o, 16); ok {
+				x.Uint16 = uint16(v9)
+			} else if err := genrt.UnmarshalItem(d, o, &x.Uint16); err != nil {
+				return genrt.ErrorAt(err, "Uint16")
+			}
+		case 9:
+			if v10, ok := genrt.DecodeUint(data, o, 32); ok {
+				x.Uint32 = uint32(v10)
+			} else if err := genrt.UnmarshalItem(d, o, &x.Uint32); err != nil {
+				return genrt.ErrorAt(err, "Uint32")
+			}
+		case 10:
+			if v11, ok := genrt.DecodeUint(data, o, 64); ok {
+				x.Uint64 = v11
+			} else if err := genrt.UnmarshalItem(d, o, &x.Uint64); err != nil {
+				return genrt.ErrorAt(err, "Uint64")
+			}
+		case 11:
+			if v12, ok := genrt.DecodeUint(data, o, strconv.IntSize); ok {
+				x.Uintptr = uintptr(v12)
+			} else if err := genrt.UnmarshalItem(d, o, &x.Uintptr); err != nil {
+				return genrt.ErrorAt(err, "Uintptr")
+			}
+		case 12:
+			if v13, ok := genrt.DecodeFloat(data, o, 32); ok {
+				x.Float32 = float32(v13)
+			} else if err := genrt.UnmarshalItem(d, o, &x.Float32); err != nil {
+				return genrt.ErrorAt(err, "Float32")
+			}
+		case 13:
+			if v14, ok := genrt.DecodeFloat(data, o, 64); ok {
+				x.Float64 = v14
+			} else if err := genrt.UnmarshalItem(d, o, &x.Float64); err != nil {
+				return genrt.ErrorAt(err, "Float64")
+			}
+		case 14:
+			if v15, ok := genrt.DecodeText(d, o); ok {
+				x.String = v15
+			} else if err := genrt.UnmarshalItem(d, o, &x.String); err != nil {
+				return genrt.ErrorAt(err, "String")
+			}
+		case 15:
+			if v16, ok := genrt.DecodeBlob(d, o); ok {
+				x.Bytes = v16
+			} else if err := genrt.UnmarshalItem(d, o, &x.Bytes); err != nil {
+				return genrt.ErrorAt(err, "Bytes")
+			}
+		case 16:
+			if v17, ok := genrt.DecodeInt(data, o, 8); ok {
+				x.Level = Level(v17)
+			} else if err := genrt.UnmarshalItem(d, o, &x.Level); err != nil {
+				return genrt.ErrorAt(err, "Level")
+			}
+		case 17:
+			if v18, ok := genrt.DecodeText(d, o); ok {
+				x.Name = Name(v18)
+			} else if err := genrt.UnmarshalItem(d, o, &x.Name); err != nil {
+				return genrt.ErrorAt(err, "Name")
+			}
+		case 18:
+			if v19, ok := genrt.DecodeBlob(d, o); ok {
+				x.Blob = Blob(v19)
+			} else if err := genrt.UnmarshalItem(d, o, &x.Blob); err != nil {
+				return genrt.ErrorAt(err, "Blob")
+			}
+		case 19:
+			if err := genrt.DecodeTime(data, o, &x.Time); err != nil {
+				return genrt.ErrorAt(err, "Time")
+			}
+		default:
+			genrt.UnknownField(d, reflect.TypeOf((*Scalars)(nil)).Elem(), key, o)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// MarshalKSPACK implements pack.Marshaler.
+func (x Order) MarshalKSPACK() ([]byte, error) {
+	return pack.Marshal(&x)
+}
+
+// EncodeKSPACK appends the item of x under the key k at the end of b
+// within e, the state of the pack.Marshal call running it.
+func (x *Order) EncodeKSPACK(e genrt.Encoder, b []byte, k string) ([]byte, error) {
+	if err := genrt.Enter(e, x); err != nil {
+		return b, err
+	}
+	b, err := x.appendKSPACK(e, b, k)
+	return b, genrt.Leave(e, x, err)
+}
+
+func (x *Order) appendKSPACK(e genrt.Encoder, b []byte, k string) ([]byte, error) {
+	var err error
+	var start int
+	b, start = genrt.BeginObject(b, k)
+	n := 0
+	b = genrt.AppendInt64(b, "id", x.ID)
+	n++
+	if x.Customer == nil {
+		b = genrt.AppendNull(b, "customer")
+	} else {
+		b = genrt.AppendString(b, "customer", string(*x.Customer))
+	}
+	n++
+	var start1 int
+	b, start1 = genrt.BeginArray(b, "items")
+	for i2 := range x.Items {
+		if b, err = x.Items[i2].EncodeKSPACK(e, b, ""); err != nil {
+			return b, genrt.ErrorAt(genrt.ErrorAt(err, "["+strconv.Itoa(i2)+"]"), "items")
+		}
+	}
+	b = genrt.EndContainer(b, start1, len(x.Items))
+	n++
+	if x.Gift == nil {
+		b = genrt.AppendNull(b, "gift")
+	} else {
+		if b, err = x.Gift.EncodeKSPACK(e, b, "gift"); err != nil {
+			return b, genrt.ErrorAt(err, "gift")
+		}
+	}
+	n++
+	var start3 int
+	b, start3 = genrt.BeginArray(b, "totals")
+	for i4 := range x.Totals {
+		b = genrt.AppendFloat64(b, "", x.Totals[i4])
+	}
+	b = genrt.EndContainer(b, start3, len(x.Totals))
+	n++
+	var start7 int
+	b, start7 = genrt.BeginObject(b, "labels")
+	for k5, v6 := range x.Labels {
+		key8 := k5
+		if err = genrt.CheckKey(key8); err != nil {
+			return b, genrt.ErrorAt(err, "labels")
+		}
+		b = genrt.AppendString(b, key8, v6)
+	}
+	b = genrt.EndContainer(b, start7, len(x.Labels))
+	n++
+	var start11 int
+	b, start11 = genrt.BeginObject(b, "counts")
+	for k9, v10 := range x.Counts {
+		key12 := strconv.FormatInt(int64(k9), 10)
+		if err = genrt.CheckKey(key12); err != nil {
+			return b, genrt.ErrorAt(err, "counts")
+		}
+		b = genrt.AppendUint16(b, key12, v10)
+	}
+	b = genrt.EndContainer(b, start11, len(x.Counts))
+	n++
+	var start15 int
+	b, start15 = genrt.BeginObject(b, "by_name")
+	for k13, v14 := range x.ByName {
+		key16 := string(k13)
+		if err = genrt.CheckKey(key16); err != nil {
+			return b, genrt.ErrorAt(err, "by_name")
+		}
+		if v14 == nil {
+			b = genrt.AppendNull(b, key16)
+		} else {
+			if b, err = v14.EncodeKSPACK(e, b, key16); err != nil {
+				return b, genrt.ErrorAt(genrt.ErrorAt(err, key16), "by_name")
+			}
+		}
+	}
+	b = genrt.EndContainer(b, start15, len(x.ByName))
+	n++
+	var start17 int
+	b, start17 = genrt.BeginArray(b, "matrix")
+	for i18 := range x.Matrix {
+		var start19 int
+		b, start19 = genrt.BeginArray(b, "")
+		for i20 := range x.Matrix[i18] {
+			b = genrt.AppendInt32(b, "", x.Matrix[i18][i20])
+		}
+		b = genrt.EndContainer(b, start19, len(x.Matrix[i18]))
+	}
+	b = genrt.EndContainer(b, start17, len(x.Matrix))
+	n++
+	if b, err = genrt.AppendTime(b, "placed", x.Placed); err != nil {
+		return b, genrt.ErrorAt(err, "placed")
+	}
+	n++
+	if x.Shipped == nil {
+		b = genrt.AppendNull(b, "shipped")
+	} else {
+		if b, err = genrt.AppendTime(b, "shipped", *x.Shipped); err != nil {
+			return b, genrt.ErrorAt(err, "shipped")
+		}
+	}
+	n++
+	if b, err = genrt.AppendValue(e, b, "extra", x.Extra); err != nil {
+		return b, genrt.ErrorAt(err, "extra")
+	}
+	n++
+	if b, err = genrt.AppendValue(e, b, "raw", &x.Raw); err != nil {
+		return b, genrt.ErrorAt(err, "raw")
+	}
+	n++
+	if b, err = genrt.AppendValue(e, b, "code", &x.Code); err != nil {
+		return b, genrt.ErrorAt(err, "code")
+	}
+	n++
+	var start21 int
+	b, start21 = genrt.BeginArray(b, "codes")
+	for i22 := range x.Codes {
+		if b, err = genrt.AppendValue(e, b, "", &x.Codes[i22]); err != nil {
+			return b, genrt.ErrorAt(genrt.ErrorAt(err, "["+strconv.Itoa(i22)+"]"), "codes")
+		}
+	}
+	b = genrt.EndContainer(b, start21, len(x.Codes))
+	n++
+	if b, err = genrt.AppendValue(e, b, "note", &x.Note); err != nil {
+		return b, genrt.ErrorAt(err, "note")
+	}
+	n++
+	return genrt.EndContainer(b, start, n), nil
+}
+
+// UnmarshalKSPACK implements pack.Unmarshaler.
+func (x *Order) UnmarshalKSPACK(data []byte) error {
+	return pack.Unmarshal(data, x)
+}
+
+// DecodeKSPACK decodes the item at off within d, the state of the
+// pack.Unmarshal call running it.
+func (x *Order) DecodeKSPACK(d genrt.Decoder, off int) error {
+	data := d.Data()
+	switch genrt.ItemType(data, off) {
+	case pack.KSPACK_NULL:
+		*x = Order{}
+		return nil
+	case pack.KSPACK_OBJECT:
+	default:
+		return genrt.TypeError(data, off, reflect.TypeOf((*Order)(nil)).Elem())
+	}
+	err := genrt.Members(data, off, func(key []byte, o int) error {
+		f := -1
+		switch string(key) {
+		case "id":
+			f = 0
+		case "customer":
+			f = 1
+		case "items":
+			f = 2
+		case "gift":
+			f = 3
+		case "totals":
+			f = 4
+		case "labels":
+			f = 5
+		case "counts":
+			f = 6
+		case "by_name":
+			f = 7
+		case "matrix":
+			f = 8
+		case "placed":
+			f = 9
+		case "shipped":
+			f = 10
+		case "extra":
+			f = 11
+		case "raw":
+			f = 12
+		case "code":
+			f = 13
+		case "codes":
+			f = 14
+		case "note":
+			f = 15
+		default:
+			switch {
+			case bytes.EqualFold(key, []byte("id")):
+				f = 0
+			case bytes.EqualFold(key, []byte("customer")):
+				f = 1
+			case bytes.EqualFold(key, []byte("items")):
+				f = 2
+			case bytes.EqualFold(key, []byte("gift")):
+				f = 3
+			case bytes.EqualFold(key, []byte("totals")):
+				f = 4
+			case bytes.EqualFold(key, []byte("labels")):
+				f = 5
+			case bytes.EqualFold(key, []byte("counts")):
+				f = 6
+			case bytes.EqualFold(key, []byte("by_name")):
+				f = 7
+			case bytes.EqualFold(key, []byte("matrix")):
+				f = 8
+			case bytes.EqualFold(key, []byte("placed")):
+				f = 9
+			case bytes.EqualFold(key, []byte("shipped")):
+				f = 10
+			case bytes.EqualFold(key, []byte("extra")):
+				f = 11
+			case bytes.EqualFold(key, []byte("raw")):
+				f = 12
+			case bytes.EqualFold(key, []byte("code")):
+				f = 13
+			case bytes.EqualFold(key, []byte("codes")):
+				f = 14
+			case bytes.EqualFold(key, []byte("note")):
+				f = 15
+			}
+		}
+		switch f {
+		case 0:
+			if v1, ok := genrt.DecodeInt(data, o, 64); ok {
+				x.ID = v1
+			} else if err := genrt.UnmarshalItem(d, o, &x.ID); err != nil {
+				return genrt.ErrorAt(err, "id")
+			}
+		case 1:
+			if genrt.ItemType(data, o) != pack.KSPACK_NULL || x.Customer != nil {
+				if x.Customer == nil {
+					genrt.Alloc(d, o, int(unsafe.Sizeof(*x.Customer)))
+					x.Customer = new(Name)
+				}
+				if v2, ok := genrt.DecodeText(d, o); ok {
+					*x.Customer = Name(v2)
+				} else if err := genrt.UnmarshalItem(d, o, x.Customer); err != nil {
+					return genrt.ErrorAt(err, "customer")
+				}
+			}
+		case 2:
+			switch genrt.ItemType(data, o) {
+			case pack.KSPACK_ARRAY:
+				n3 := genrt.ItemCount(data, o)
+				if n3 > cap(x.Items) {
+					genrt.Alloc(d, o, n3*int(unsafe.Sizeof(x.Items[0])))
+					x.Items = make([]Item, n3)
+				}
+				x.Items = x.Items[:n3]
+				if n3 == 0 {
+					x.Items = []Item{}
+				}
+				if err := genrt.Elements(data, o, func(i4, o5 int) error {
+					if err := x.Items[i4].DecodeKSPACK(d, o5); err != nil {
+						return genrt.ErrorAt(genrt.ErrorAt(err, "["+strconv.Itoa(i4)+"]"), "items")
+					}
+					return nil
+				}); err != nil {
+					return err
+				}
+			case pack.KSPACK_NULL:
+				x.Items = nil
+			default:
+				if err := genrt.UnmarshalItem(d, o, &x.Items); err != nil {
+					return genrt.ErrorAt(err, "items")
+				}
+			}
+		case 3:
+			if genrt.ItemType(data, o) != pack.KSPACK_NULL || x.Gift != nil {
+				if x.Gift == nil {
+					genrt.Alloc(d, o, int(unsafe.Sizeof(*x.Gift)))
+					x.Gift = new(Item)
+				}
+				if err := x.Gift.DecodeKSPACK(d, o); err != nil {
+					return genrt.ErrorAt(err, "gift")
+				}
+			}
+		case 4:
+			switch genrt.ItemType(data, o) {
+			case pack.KSPACK_ARRAY:
+				n6 := genrt.ItemCount(data, o)
+				if err := genrt.Elements(data, o, func(i7, o8 int) error {
+					if i7 < len(x.Totals) {
+						if v10, ok := genrt.DecodeFloat(data, o8, 64); ok {
+							x.Totals[i7] = v10
+						} else if err := genrt.UnmarshalItem(d, o8, &x.Totals[i7]); err != nil {
+							return genrt.ErrorAt(genrt.ErrorAt(err, "["+strconv.Itoa(i7)+"]"), "totals")
+						}
+					}
+					return nil
+				}); err != nil {
+					return err
+				}
+				for ; n6 < len(x.Totals); n6++ {
+					var z9 float64
+					x.Totals[n6] = z9
+				}
+			case pack.KSPACK_NULL:
+				x.Totals = [3]float64{}
+			default:
+				if err := genrt.UnmarshalItem(d, o, &x.Totals); err != nil {
+					return genrt.ErrorAt(err, "totals")
+				}
+			}
+		case 5:
+			switch genrt.ItemType(data, o) {
+			case pack.KSPACK_OBJECT:
+				if x.Labels == nil {
+					x.Labels = make(map[string]string)
+				}
+				if err := genrt.Members(data, o, func(k11 []byte, o12 int) error {
+					var v13 string
+					if v14, ok := genrt.DecodeText(d, o12); ok {
+						v13 = v14
+					} else if err := genrt.UnmarshalItem(d, o12, &v13); err != nil {
+						return genrt.ErrorAt(genrt.ErrorAt(err, string(k11)), "labels")
+					}
+					genrt.Alloc(d, o12, int(unsafe.Sizeof(""))+int(unsafe.Sizeof(v13)))
+					x.Labels[genrt.Key(d, k11, o12)] = v13
+					return nil
+				}); err != nil {
+					return err
+				}
+			case pack.KSPACK_NULL:
+				x.Labels = nil
+			default:
+				if err := genrt.UnmarshalItem(d, o, &x.Labels); err != nil {
+					return genrt.ErrorAt(err, "labels")
+				}
+			}
+		case 6:
+			if err := genrt.UnmarshalItem(d, o, &x.Counts); err != nil {
+				return genrt.ErrorAt(err, "counts")
+			}
+		case 7:
+			switch genrt.ItemType(data, o) {
+			case pack.KSPACK_OBJECT:
+				if x.ByName == nil {
+					x.ByName = make(map[Name]*Item)
+				}
+				if err := genrt.Members(data, o, func(k15 []byte, o16 int) error {
+					var v17 *Item
+					if genrt.ItemType(data, o16) != pack.KSPACK_NULL || v17 != nil {
+						if v17 == nil {
+							genrt.Alloc(d, o16, int(unsafe.Sizeof(*v17)))
+							v17 = new(Item)
+						}
+						if err := v17.DecodeKSPACK(d, o16); err != nil {
+							return genrt.ErrorAt(genrt.ErrorAt(err, string(k15)), "by_name")
+						}
+					}
+					genrt.Alloc(d, o16, int(unsafe.Sizeof(""))+int(unsafe.Sizeof(v17)))
+					x.ByName[Name(genrt.Key(d, k15, o16))] = v17
+					return nil
+				}); err != nil {
+					return err
+				}
+			case pack.KSPACK_NULL:
+				x.ByName = nil
+			default:
+				if err := genrt.UnmarshalItem(d, o, &x.ByName); err != nil {
+					return genrt.ErrorAt(err, "by_name")
+				}
+			}
+		case 8:
+			switch genrt.ItemType(data, o) {
+			case pack.KSPACK_ARRAY:
+				n18 := genrt.ItemCount(data, o)
+				if n18 > cap(x.Matrix) {
+					genrt.Alloc(d, o, n18*int(unsafe.Sizeof(x.Matrix[0])))
+					x.Matrix = make([][2]int32, n18)
+				}
+				x.Matrix = x.Matrix[:n18]
+				if n18 == 0 {
+					x.Matrix = [][2]int32{}
+				}
+				if err := genrt.Elements(data, o, func(i19, o20 int) error {
+					switch genrt.ItemType(data, o20) {
+					case pack.KSPACK_ARRAY:
+						n21 := genrt.ItemCount(data, o20)
+						if err := genrt.Elements(data, o20, func(i22, o23 int) error {
+							if i22 < len(x.Matrix[i19]) {
+								if v25, ok := genrt.DecodeInt(data, o23, 32); ok {
+									x.Matrix[i19][i22] = int32(v25)
+								} else if err := genrt.UnmarshalItem(d, o23, &x.Matrix[i19][i22]); err != nil {
+									return genrt.ErrorAt(genrt.ErrorAt(genrt.ErrorAt(err, "["+strconv.Itoa(i22)+"]"), "["+strconv.Itoa(i19)+"]"), "matrix")
+								}
+							}
+							return nil
+						}); err != nil {
+							return err
+						}
+						for ; n21 < len(x.Matrix[i19]); n21++ {
+							var z24 int32
+							x.Matrix[i19][n21] = z24
+						}
+					case pack.KSPACK_NULL:
+						x.Matrix[i19] = [2]int32{}
+					default:
+						if err := genrt.UnmarshalItem(d, o20, &x.Matrix[i19]); err != nil {
+							return genrt.ErrorAt(genrt.ErrorAt(err, "["+strconv.Itoa(i19)+"]"), "matrix")
+						}
+					}
+					return nil
+				}); err != nil {
+					return err
+				}
+			case pack.KSPACK_NULL:
+				x.Matrix = nil
+			default:
+				if err := genrt.UnmarshalItem(d, o, &x.Matrix); err != nil {
+					return genrt.ErrorAt(err, "matrix")
+				}
+			}
+		case 9:
+			if err := genrt.DecodeTime(data, o, &x.Placed); err != nil {
+				return genrt.ErrorAt(err, "placed")
+			}
+		case 10:
+			if genrt.ItemType(data, o) != pack.KSPACK_NULL || x.Shipped != nil {
+				if x.Shipped == nil {
+					genrt.Alloc(d, o, int(unsafe.Sizeof(*x.Shipped)))
+					x.Shipped = new(time.Time)
+				}
+				if err := genrt.DecodeTime(data, o, x.Shipped); err != nil {
+					return genrt.ErrorAt(err, "shipped")
+				}
+			}
+		case 11:
+			if err := genrt.UnmarshalItem(d, o, &x.Extra); err != nil {
+				return genrt.ErrorAt(err, "extra")
+			}
+		case 12:
+			if err := genrt.UnmarshalItem(d, o, &x.Raw); err != nil {
+				return genrt.ErrorAt(err, "raw")
+			}
+		case 13:
+			if err := genrt.UnmarshalItem(d, o, &x.Code); err != nil {
+				return genrt.ErrorAt(err, "code")
+			}
+		case 14:
+			switch genrt.ItemType(data, o) {
+			case pack.KSPACK_ARRAY:
+				n26 := genrt.ItemCount(data, o)
+				if n26 > cap(x.Codes) {
+					genrt.Alloc(d, o, n26*int(unsafe.Sizeof(x.Codes[0])))
+					x.Codes = make([]Code, n26)
+				}
+				x.Codes = x.Codes[:n26]
+				if n26 == 0 {
+					x.Codes = []Code{}
+				}
+				if err := genrt.Elements(data, o, func(i27, o28 int) error {
+					if err := genrt.UnmarshalItem(d, o28, &x.Codes[i27]); err != nil {
+						return genrt.ErrorAt(genrt.ErrorAt(err, "["+strconv.Itoa(i27)+"]"), "codes")
+					}
+					return nil
+				}); err != nil {
+					return err
+				}
+			case pack.KSPACK_NULL:
+				x.Codes = nil
+			default:
+				if err := genrt.UnmarshalItem(d, o, &x.Codes); err != nil {
+					return genrt.ErrorAt(err, "codes")
+				}
+			}
+		case 15:
+			if err := genrt.UnmarshalItem(d, o, &x.Note); err != nil {
+				return genrt.ErrorAt(err, "note")
+			}
+		default:
+			genrt.UnknownField(d, reflect.TypeOf((*Order)(nil)).Elem(), key, o)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// MarshalKSPACK implements pack.Marshaler.
+func (x Item) MarshalKSPACK() ([]byte, error) {
+	return pack.Marshal(&x)
+}
+
+// EncodeKSPACK appends the item of x under the key k at the end of b
+// within e, the state of the pack.Marshal call running it.
+func (x *Item) EncodeKSPACK(e genrt.Encoder, b []byte, k string) ([]byte, error) {
+	if err := genrt.Enter(e, x); err != nil {
+		return b, err
+	}
+	b, err := x.appendKSPACK(e, b, k)
+	return b, genrt.Leave(e, x, err)
+}
+
+func (x *Item) appendKSPACK(e genrt.Encoder, b []byte, k string) ([]byte, error) {
+	var start int
+	b, start = genrt.BeginObject(b, k)
+	n := 0
+	b = genrt.AppendString(b, "sku", x.SKU)
+	n++
+	b = genrt.AppendInt64(b, "qty", int64(x.Qty))
+	n++
+	b = genrt.AppendFloat32(b, "price", x.Price)
+	n++
+	return genrt.EndContainer(b, start, n), nil
+}
+
+// UnmarshalKSPACK implements pack.Unmarshaler.
+func (x *Item) UnmarshalKSPACK(data []byte) error {
+	return pack.Unmarshal(data, x)
+}
+
+// DecodeKSPACK decodes the item at off within d, the state of the
+// pack.Unmarshal call running it.
+func (x *Item) DecodeKSPACK(d genrt.Decoder, off int) error {
+	data := d.Data()
+	switch genrt.ItemType(data, off) {
+	case pack.KSPACK_NULL:
+		*x = Item{}
+		return nil
+	case pack.KSPACK_OBJECT:
+	default:
+		return genrt.TypeError(data, off, reflect.TypeOf((*Item)(nil)).Elem())
+	}
+	err := genrt.Members(data, off, func(key []byte, o int) error {
+		f := -1
+		switch string(key) {
+		case "sku":
+			f = 0
+		case "qty":
+			f = 1
+		case "price":
+			f = 2
+		default:
+			switch {
+			case bytes.EqualFold(key, []byte("sku")):
+				f = 0
+			case bytes.EqualFold(key, []byte("qty")):
+				f = 1
+			case bytes.EqualFold(key, []byte("price")):
+				f = 2
+			}
+		}
+		switch f {
+		case 0:
+			if v1, ok := genrt.DecodeText(d, o); ok {
+				x.SKU = v1
+			} else if err := genrt.UnmarshalItem(d, o, &x.SKU); err != nil {
+				return genrt.ErrorAt(err, "sku")
+			}
+		case 1:
+			if v2, ok := genrt.DecodeInt(data, o, strconv.IntSize); ok {
+				x.Qty = int(v2)
+			} else if err := genrt.UnmarshalItem(d, o, &x.Qty); err != nil {
+				return genrt.ErrorAt(err, "qty")
+			}
+		case 2:
+			if v3, ok := genrt.DecodeFloat(data, o, 32); ok {
+				x.Price = float32(v3)
+			} else if err := genrt.UnmarshalItem(d, o, &x.Price); err != nil {
+				return genrt.ErrorAt(err, "price")
+			}
+		default:
+			genrt.UnknownField(d, reflect.TypeOf((*Item)(nil)).Elem(), key, o)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// MarshalKSPACK implements pack.Marshaler.
+func (x Options) MarshalKSPACK() ([]byte, error) {
+	return pack.Marshal(&x)
+}
+
+// EncodeKSPACK appends the item of x under the key k at the end of b
+// within e, the state of the pack.Marshal call running it.
+func (x *Options) EncodeKSPACK(e genrt.Encoder, b []byte, k string) ([]byte, error) {
+	if err := genrt.Enter(e, x); err != nil {
+		return b, err
+	}
+	b, err := x.appendKSPACK(e, b, k)
+	return b, genrt.Leave(e, x, err)
+}
+
+func (x *Options) appendKSPACK(e genrt.Encoder, b []byte, k string) ([]byte, error) {
+	var err error
+	var start int
+	b, start = genrt.BeginObject(b, k)
+	n := 0
+	b = genrt.AppendString(b, "req", x.Req)
+	n++
+	if len(x.Empty) != 0 {
+		b = genrt.AppendString(b, "empty", x.Empty)
+		n++
+	}
+	if len(x.List) != 0 {
+		var start1 int
+		b, start1 = genrt.BeginArray(b, "list")
+		for i2 := range x.List {
+			b = genrt.AppendInt64(b, "", int64(x.List[i2]))
+		}
+		b = genrt.EndContainer(b, start1, len(x.List))
+		n++
+	}
+	if x.Ptr != nil {
+		if x.Ptr == nil {
+			b = genrt.AppendNull(b, "ptr")
+		} else {
+			b = genrt.AppendInt64(b, "ptr", int64(*x.Ptr))
+		}
+		n++
+	}
+	if math.Float64bits(float64(x.Zero)) != 0 {
+		b = genrt.AppendFloat64(b, "zero", x.Zero)
+		n++
+	}
+	if !x.When.IsZero() {
+		if b, err = genrt.AppendTime(b, "when", x.When); err != nil {
+			return b, genrt.ErrorAt(err, "when")
+		}
+		n++
+	}
+	if !x.Money.IsZero() {
+		if b, err = genrt.AppendValue(e, b, "money", &x.Money); err != nil {
+			return b, genrt.ErrorAt(err, "money")
+		}
+		n++
+	}
+	b = genrt.AppendString(b, "num", strconv.FormatInt(int64(x.Num), 10))
+	n++
+	if x.NumPtr == nil {
+		b = genrt.AppendNull(b, "num_ptr")
+	} else {
+		b = genrt.AppendString(b, "num_ptr", strconv.FormatUint(uint64(*x.NumPtr), 10))
+	}
+	n++
+	b = genrt.AppendString(b, "flag", strconv.FormatBool(x.Flag))
+	n++
+	b = genrt.AppendString(b, "ratio", strconv.FormatFloat(float64(x.Ratio), 'g', -1, 32))
+	n++
+	if x.Default != 0 {
+		b = genrt.AppendInt64(b, "Default", int64(x.Default))
+		n++
+	}
+	b = genrt.AppendString(b, "json_name", x.JSON)
+	n++
+	return genrt.EndContainer(b, start, n), nil
+}
+
+// UnmarshalKSPACK implements pack.Unmarshaler.
+func (x *Options) UnmarshalKSPACK(data []byte) error {
+	return pack.Unmarshal(data, x)
+}
+
+// DecodeKSPACK decodes the item at off within d, the state of the
+// pack.Unmarshal call running it.
+func (x *Options) DecodeKSPACK(d genrt.Decoder, off int) error {
+	data := d.Data()
+	switch genrt.ItemType(data, off) {
+	case pack.KSPACK_NULL:
+		*x = Options{}
+		return nil
+	case pack.KSPACK_OBJECT:
+	default:
+		return genrt.TypeError(data, off, reflect.TypeOf((*Options)(nil)).Elem())
+	}
+	var seen [13]bool
+	err := genrt.Members(data, off, func(key []byte, o int) error {
+		f := -1
+		switch string(key) {
+		case "req":
+			f = 0
+		case "empty":
+			f = 1
+		case "list":
+			f = 2
+		case "ptr":
+			f = 3
+		case "zero":
+			f = 4
+		case "when":
+			f = 5
+		case "money":
+			f = 6
+		case "num":
+			f = 7
+		case "num_ptr":
+			f = 8
+		case "flag":
+			f = 9
+		case "ratio":
+			f = 10
+		case "Default":
+			f = 11
+		case "json_name":
+			f = 12
+		default:
+			switch {
+			case bytes.EqualFold(key, []byte("req")):
+				f = 0
+			case bytes.EqualFold(key, []byte("empty")):
+				f = 1
+			case bytes.EqualFold(key, []byte("list")):
+				f = 2
+			case bytes.EqualFold(key, []byte("ptr")):
+				f = 3
+			case bytes.EqualFold(key, []byte("zero")):
+				f = 4
+			case bytes.EqualFold(key, []byte("when")):
+				f = 5
+			case bytes.EqualFold(key, []byte("money")):
+				f = 6
+			case bytes.EqualFold(key, []byte("num")):
+				f = 7
+			case bytes.EqualFold(key, []byte("num_ptr")):
+				f = 8
+			case bytes.EqualFold(key, []byte("flag")):
+				f = 9
+			case bytes.EqualFold(key, []byte("ratio")):
+				f = 10
+			case bytes.EqualFold(key, []byte("Default")):
+				f = 11
+			case bytes.EqualFold(key, []byte("json_name")):
+				f = 12
+			}
+		}
+		switch f {
+		case 0:
+			seen[0] = true
+			if v1, ok := genrt.DecodeText(d, o); ok {
+				x.Req = v1
+			} else if err := genrt.UnmarshalItem(d, o, &x.Req); err != nil {
+				return genrt.ErrorAt(err, "req")
+			}
+		case 1:
+			if v2, ok := genrt.DecodeText(d, o); ok {
+				x.Empty = v2
+			} else if err := genrt.UnmarshalItem(d, o, &x.Empty); err != nil {
+				return genrt.ErrorAt(err, "empty")
+			}
+		case 2:
+			switch genrt.ItemType(data, o) {
+			case pack.KSPACK_ARRAY:
+				n3 := genrt.ItemCount(data, o)
+				if n3 > cap(x.List) {
+					genrt.Alloc(d, o, n3*int(unsafe.Sizeof(x.List[0])))
+					x.List = make([]int, n3)
+				}
+				x.List = x.List[:n3]
+				if n3 == 0 {
+					x.List = []int{}
+				}
+				if err := genrt.Elements(data, o, func(i4, o5 int) error {
+					if v6, ok := genrt.DecodeInt(data, o5, strconv.IntSize); ok {
+						x.List[i4] = int(v6)
+					} else if err := genrt.UnmarshalItem(d, o5, &x.List[i4]); err != nil {
+						return genrt.ErrorAt(genrt.ErrorAt(err, "["+strconv.Itoa(i4)+"]"), "list")
+					}
+					return nil
+				}); err != nil {
+					return err
+				}
+			case pack.KSPACK_NULL:
+				x.List = nil
+			default:
+				if err := genrt.UnmarshalItem(d, o, &x.List); err != nil {
+					return genrt.ErrorAt(err, "list")
+				}
+			}
+		case 3:
+			if genrt.ItemType(data, o) != pack.KSPACK_NULL || x.Ptr != nil {
+				if x.Ptr == nil {
+					genrt.Alloc(d, o, int(unsafe.Sizeof(*x.Ptr)))
+					x.Ptr = new(int)
+				}
+				if v7, ok := genrt.DecodeInt(data, o, strconv.IntSize); ok {
+					*x.Ptr = int(v7)
+				} else if err := genrt.UnmarshalItem(d, o, x.Ptr); err != nil {
+					return genrt.ErrorAt(err, "ptr")
+				}
+			}
+		case 4:
+			if v8, ok := genrt.DecodeFloat(data, o, 64); ok {
+				x.Zero = v8
+			} else if err := genrt.UnmarshalItem(d, o, &x.Zero); err != nil {
+				return genrt.ErrorAt(err, "zero")
+			}
+		case 5:
+			if err := genrt.DecodeTime(data, o, &x.When); err != nil {
+				return genrt.ErrorAt(err, "when")
+			}
+		case 6:
+			if err := genrt.UnmarshalItem(d, o, &x.Money); err != nil {
+				return genrt.ErrorAt(err, "money")
+			}
+		case 7:
+			if s9, ok := genrt.DecodeQuoted(data, o); ok {
+				v10, err := strconv.ParseInt(s9, 10, strconv.IntSize)
+				if err != nil {
+					return genrt.QuotedError(s9, o, reflect.TypeOf((*int)(nil)).Elem())
+				}
+				x.Num = int(v10)
+			} else {
+				if v11, ok := genrt.DecodeInt(data, o, strconv.IntSize); ok {
+					x.Num = int(v11)
+				} else if err := genrt.UnmarshalItem(d, o, &x.Num); err != nil {
+					return genrt.ErrorAt(err, "num")
+				}
+			}
+		case 8:
+			if s12, ok := genrt.DecodeQuoted(data, o); ok {
+				if x.NumPtr == nil {
+					x.NumPtr = new(uint8)
+				}
+				v13, err := strconv.ParseUint(s12, 10, 8)
+				if err != nil {
+					return genrt.QuotedError(s12, o, reflect.TypeOf((**uint8)(nil)).Elem())
+				}
+				*x.NumPtr = uint8(v13)
+			} else {
+				if genrt.ItemType(data, o) != pack.KSPACK_NULL || x.NumPtr != nil {
+					if x.NumPtr == nil {
+						genrt.Alloc(d, o, int(unsafe.Sizeof(*x.NumPtr)))
+						x.NumPtr = new(uint8)
+					}
+					if v14, ok := genrt.DecodeUint(data, o, 8); ok {
+						*x.NumPtr = uint8(v14)
+					} else if err := genrt.UnmarshalItem(d, o, x.NumPtr); err != nil {
+						return genrt.ErrorAt(err, "num_ptr")
+					}
+				}
+			}
+		case 9:
+			if s15, ok := genrt.DecodeQuoted(data, o); ok {
+				v16, err := strconv.ParseBool(s15)
+				if err != nil {
+					return genrt.QuotedError(s15, o, reflect.TypeOf((*bool)(nil)).Elem())
+				}
+				x.Flag = v16
+			} else {
+				if v17, ok := genrt.DecodeBool(data, o); ok {
+					x.Flag = v17
+				} else if err := genrt.UnmarshalItem(d, o, &x.Flag); err != nil {
+					return genrt.ErrorAt(err, "flag")
+				}
+			}
+		case 10:
+			if s18, ok := genrt.DecodeQuoted(data, o); ok {
+				v19, err := strconv.ParseFloat(s18, 32)
+				if err != nil {
+					return genrt.QuotedError(s18, o, reflect.TypeOf((*float32)(nil)).Elem())
+				}
+				x.Ratio = float32(v19)
+			} else {
+				if v20, ok := genrt.DecodeFloat(data, o, 32); ok {
+					x.Ratio = float32(v20)
+				} else if err := genrt.UnmarshalItem(d, o, &x.Ratio); err != nil {
+					return genrt.ErrorAt(err, "ratio")
+				}
+			}
+		case 11:
+			if v21, ok := genrt.DecodeInt(data, o, strconv.IntSize); ok {
+				x.Default = int(v21)
+			} else if err := genrt.UnmarshalItem(d, o, &x.Default); err != nil {
+				return genrt.ErrorAt(err, "Default")
+			}
+		case 12:
+			if v22, ok := genrt.DecodeText(d, o); ok {
+				x.JSON = v22
+			} else if err := genrt.UnmarshalItem(d, o, &x.JSON); err != nil {
+				return genrt.ErrorAt(err, "json_name")
+			}
+		default:
+			genrt.UnknownField(d, reflect.TypeOf((*Options)(nil)).Elem(), key, o)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !seen[0] {
+		return &pack.MissingFieldError{Key: "req", Type: reflect.TypeOf((*Options)(nil)).Elem()}
+	}
+	return nil
+}
+
+// MarshalKSPACK implements pack.Marshaler.
+func (x Node) MarshalKSPACK() ([]byte, error) {
+	return pack.Marshal(&x)
+}
+
+// EncodeKSPACK appends the item of x under the key k at the end of b
+// within e, the state of the pack.Marshal call running it.
+func (x *Node) EncodeKSPACK(e genrt.Encoder, b []byte, k string) ([]byte, error) {
+	if err := genrt.Enter(e, x); err != nil {
+		return b, err
+	}
+	b, err := x.appendKSPACK(e, b, k)
+	return b, genrt.Leave(e, x, err)
+}
+
+func (x *Node) appendKSPACK(e genrt.Encoder, b []byte, k string) ([]byte, error) {
+	var err error
+	var start int
+	b, start = genrt.BeginObject(b, k)
+	n := 0
+	b = genrt.AppendString(b, "name", x.Name)
+	n++
+	if x.Next == nil {
+		b = genrt.AppendNull(b, "next")
+	} else {
+		if b, err = x.Next.EncodeKSPACK(e, b, "next"); err != nil {
+			return b, genrt.ErrorAt(err, "next")
+		}
+	}
+	n++
+	if b, err = genrt.AppendValue(e, b, "any", x.Any); err != nil {
+		return b, genrt.ErrorAt(err, "any")
+	}
+	n++
+	if len(x.Kids) != 0 {
+		var start1 int
+		b, start1 = genrt.BeginArray(b, "kids")
+		for i2 := range x.Kids {
+			if b, err = x.Kids[i2].EncodeKSPACK(e, b, ""); err != nil {
+				return b, genrt.ErrorAt(genrt.ErrorAt(err, "["+strconv.Itoa(i2)+"]"), "kids")
+			}
+		}
+		b = genrt.EndContainer(b, start1, len(x.Kids))
+		n++
+	}
+	return genrt.EndContainer(b, start, n), nil
+}
+
+// UnmarshalKSPACK implements pack.Unmarshaler.
+func (x *Node) UnmarshalKSPACK(data []byte) error {
+	return pack.Unmarshal(data, x)
+}
+
+// DecodeKSPACK decodes the item at off within d, the state of the
+// pack.Unmarshal call running it.
+func (x *Node) DecodeKSPACK(d genrt.Decoder, off int) error {
+	data := d.Data()
+	switch genrt.ItemType(data, off) {
+	case pack.KSPACK_NULL:
+		*x = Node{}
+		return nil
+	case pack.KSPACK_OBJECT:
+	default:
+		return genrt.TypeError(data, off, reflect.TypeOf((*Node)(nil)).Elem())
+	}
+	err := genrt.Members(data, off, func(key []byte, o int) error {
+		f := -1
+		switch string(key) {
+		case "name":
+			f = 0
+		case "next":
+			f = 1
+		case "any":
+			f = 2
+		case "kids":
+			f = 3
+		default:
+			switch {
+			case bytes.EqualFold(key, []byte("name")):
+				f = 0
+			case bytes.EqualFold(key, []byte("next")):
+				f = 1
+			case bytes.EqualFold(key, []byte("any")):
+				f = 2
+			case bytes.EqualFold(key, []byte("kids")):
+				f = 3
+			}
+		}
+		switch f {
+		case 0:
+			if v1, ok := genrt.DecodeText(d, o); ok {
+				x.Name = v1
+			} else if err := genrt.UnmarshalItem(d, o, &x.Name); err != nil {
+				return genrt.ErrorAt(err, "name")
+			}
+		case 1:
+			if genrt.ItemType(data, o) != pack.KSPACK_NULL || x.Next != nil {
+				if x.Next == nil {
+					genrt.Alloc(d, o, int(unsafe.Sizeof(*x.Next)))
+					x.Next = new(Node)
+				}
+				if err := x.Next.DecodeKSPACK(d, o); err != nil {
+					return genrt.ErrorAt(err, "next")
+				}
+			}
+		case 2:
+			if err := genrt.UnmarshalItem(d, o, &x.Any); err != nil {
+				return genrt.ErrorAt(err, "any")
+			}
+		case 3:
+			switch genrt.ItemType(data, o) {
+			case pack.KSPACK_ARRAY:
+				n2 := genrt.ItemCount(data, o)
+				if n2 > cap(x.Kids) {
+					genrt.Alloc(d, o, n2*int(unsafe.Sizeof(x.Kids[0])))
+					x.Kids = make([]Node, n2)
+				}
+				x.Kids = x.Kids[:n2]
+				if n2 == 0 {
+					x.Kids = []Node{}
+				}
+				if err := genrt.Elements(data, o, func(i3, o4 int) error {
+					if err := x.Kids[i3].DecodeKSPACK(d, o4); err != nil {
+						return genrt.ErrorAt(genrt.ErrorAt(err, "["+strconv.Itoa(i3)+"]"), "kids")
+					}
+					return nil
+				}); err != nil {
+					return err
+				}
+			case pack.KSPACK_NULL:
+				x.Kids = nil
+			default:
+				if err := genrt.UnmarshalItem(d, o, &x.Kids); err != nil {
+					return genrt.ErrorAt(err, "kids")
+				}
+			}
+		default:
+			genrt.UnknownField(d, reflect.TypeOf((*Node)(nil)).Elem(), key, o)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// MarshalKSPACK implements pack.Marshaler.
+func (x Tagged) MarshalKSPACK() ([]byte, error) {
+	return pack.Marshal(&x)
+}
+
+// EncodeKSPACK appends the item of x under the key k at the end of b
+// within e, the state of the pack.Marshal call running it.
+func (x *Tagged) EncodeKSPACK(e genrt.Encoder, b []byte, k string) ([]byte, error) {
+	if err := genrt.Enter(e, x); err != nil {
+		return b, err
+	}
+	b, err := x.appendKSPACK(e, b, k)
+	return b, genrt.Leave(e, x, err)
+}
+
+func (x *Tagged) appendKSPACK(e genrt.Encoder, b []byte, k string) ([]byte, error) {
+	var err error
+	var start int
+	b, start = genrt.BeginObject(b, k)
+	n := 0
+	b = genrt.AppendInt64(b, "a", int64(x.A))
+	n++
+	b = genrt.AppendString(b, "B", x.B)
+	n++
+	if x.Any != nil {
+		if b, err = genrt.AppendValue(e, b, "any", x.Any); err != nil {
+			return b, genrt.ErrorAt(err, "any")
+		}
+		n++
+	}
+	return genrt.EndContainer(b, start, n), nil
+}
+
+// UnmarshalKSPACK implements pack.Unmarshaler.
+func (x *Tagged) UnmarshalKSPACK(data []byte) error {
+	return pack.Unmarshal(data, x)
+}
+
+// DecodeKSPACK decodes the item at off within d, the state of the
+// pack.Unmarshal call running it.
+func (x *Tagged) DecodeKSPACK(d genrt.Decoder, off int) error {
+	data := d.Data()
+	switch genrt.ItemType(data, off) {
+	case pack.KSPACK_NULL:
+		*x = Tagged{}
+		return nil
+	case pack.KSPACK_OBJECT:
+	default:
+		return genrt.TypeError(data, off, reflect.TypeOf((*Tagged)(nil)).Elem())
+	}
+	err := genrt.Members(data, off, func(key []byte, o int) error {
+		f := -1
+		switch string(key) {
+		case "a":
+			f = 0
+		case "B":
+			f = 1
+		case "any":
+			f = 2
+		default:
+			switch {
+			case bytes.EqualFold(key, []byte("a")):
+				f = 0
+			case bytes.EqualFold(key, []byte("B")):
+				f = 1
+			case bytes.EqualFold(key, []byte("any")):
+				f = 2
+			}
+		}
+		switch f {
+		case 0:
+			if v1, ok := genrt.DecodeInt(data, o, strconv.IntSize); ok {
+				x.A = int(v1)
+			} else if err := genrt.UnmarshalItem(d, o, &x.A); err != nil {
+				return genrt.ErrorAt(err, "a")
+			}
+		case 1:
+			if v2, ok := genrt.DecodeText(d, o); ok {
+				x.B = v2
+			} else if err := genrt.UnmarshalItem(d, o, &x.B); err != nil {
+				return genrt.ErrorAt(err, "B")
+			}
+		case 2:
+			if err := genrt.UnmarshalItem(d, o, &x.Any); err != nil {
+				return genrt.ErrorAt(err, "any")
+			}
+		default:
+			genrt.UnknownField(d, reflect.TypeOf((*Tagged)(nil)).Elem(), key, o)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
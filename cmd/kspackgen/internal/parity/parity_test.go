@@ -0,0 +1,454 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package parity
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubeservice-stack/kspack-go/pack"
+)
+
+// The plain types share the fields of the generated ones but not their
+// methods, so that they go through the reflective encoder and decoder.
+type (
+	plainScalars Scalars
+	plainOrder   Order
+	plainItem    Item
+	plainOptions Options
+)
+
+// plain returns v, a pointer to a generated type, as a pointer to its
+// plain type.
+func plain(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *Scalars:
+		return (*plainScalars)(v)
+	case *Order:
+		return (*plainOrder)(v)
+	case *Item:
+		return (*plainItem)(v)
+	case *Options:
+		return (*plainOptions)(v)
+	}
+	panic("no plain type")
+}
+
+func rawItem(v interface{}) pack.RawMessage {
+	b, err := pack.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func sampleScalars() *Scalars {
+	return &Scalars{
+		Bool: true, Int: -1 << 40, Int8: -8, Int16: -16, Int32: -32, Int64: -64,
+		Uint: 1 << 40, Uint8: 8, Uint16: 16, Uint32: 32, Uint64: 1 << 63, Uintptr: 0xff,
+		Float32: 1.5, Float64: -2.25,
+		String: "text", Bytes: []byte{0, 1, 2},
+		Level: 3, Name: "name", Blob: Blob(strings.Repeat("b", 300)),
+		Time: time.Date(2023, 5, 6, 7, 8, 9, 10, time.UTC),
+	}
+}
+
+func sampleOrder() *Order {
+	name := Name(strings.Repeat("long customer name ", 20))
+	shipped := time.Date(2023, 5, 7, 8, 0, 0, 0, time.FixedZone("", 2*3600))
+	return &Order{
+		ID:       42,
+		Customer: &name,
+		Items:    []Item{{"a-1", 3, 9.5}, {"b-2", 1, 0.25}},
+		Gift:     &Item{SKU: "gift"},
+		Totals:   [3]float64{1, 2.5, -1},
+		Labels:   map[string]string{"color": "red"},
+		Counts:   map[int]uint16{7: 70},
+		ByName:   map[Name]*Item{"a-1": {SKU: "a-1", Qty: 3}},
+		Matrix:   [][2]int32{{1, 2}, {3, 4}},
+		Placed:   time.Date(2023, 5, 6, 7, 8, 9, 10, time.UTC),
+		Shipped:  &shipped,
+		Extra:    map[string]interface{}{"n": int64(1)},
+		Raw:      rawItem([]string{"x"}),
+		Code:     Code{Major: 2, Minor: 1},
+		Codes:    []Code{{Major: 1}},
+		Note:     note{Text: "fragile", At: []int{1, 2}},
+		Skipped:  "skipped",
+		internal: "internal",
+	}
+}
+
+func sampleOptions() *Options {
+	n := 7
+	u := uint8(200)
+	return &Options{
+		Req: "r", Empty: "e", List: []int{1}, Ptr: &n, Zero: -0.0 * -1,
+		When:  time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Money: money{Units: 5}, Num: -12, NumPtr: &u, Flag: true, Ratio: 0.1,
+		Default: 1, JSON: "j",
+	}
+}
+
+func parityValues() []interface{} {
+	return []interface{}{
+		sampleScalars(),
+		&Scalars{},
+		sampleOrder(),
+		&Order{},
+		&Order{Items: []Item{}, ByName: map[Name]*Item{"nil": nil}, Extra: []interface{}{nil, "x"}},
+		&Item{SKU: "sku", Qty: -3, Price: 2},
+		sampleOptions(),
+		&Options{},
+	}
+}
+
+func TestMarshalParity(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, v := range parityValues() {
+		want, err := pack.Marshal(plain(v))
+		assert.Nil(err)
+
+		got, err := pack.Marshal(v)
+		assert.Nil(err)
+		assert.Equal(want, got, "%T", v)
+
+		got, err = v.(pack.Marshaler).MarshalKSPACK()
+		assert.Nil(err)
+		assert.Equal(want, got, "%T", v)
+	}
+}
+
+func TestMarshalCanonicalParity(t *testing.T) {
+	assert := assert.New(t)
+
+	o := sampleOrder()
+	o.Labels = map[string]string{"c": "3", "a": "1", "b": "2"}
+	o.Counts = map[int]uint16{3: 3, 1: 1, 20: 20}
+	o.ByName = map[Name]*Item{"z": nil, "y": {Qty: 1}}
+	s := sampleScalars()
+	s.Float64 = -0.0 * -1
+	for _, v := range append(parityValues(), o, s) {
+		want, err := pack.MarshalCanonical(plain(v))
+		assert.Nil(err)
+		got, err := pack.MarshalCanonical(v)
+		assert.Nil(err)
+		assert.Equal(want, got, "%T", v)
+	}
+}
+
+func TestMarshalOptionsParity(t *testing.T) {
+	assert := assert.New(t)
+
+	type plainTagged Tagged
+	for _, tt := range []struct {
+		opts pack.EncodeOptions
+		v    Tagged
+	}{
+		{pack.EncodeOptions{TagKey: "msgpack"}, Tagged{A: 1, B: "b"}},
+		{pack.EncodeOptions{TagKey: "json"}, Tagged{A: 1, B: "b"}},
+		{pack.EncodeOptions{SkipUnsupported: true}, Tagged{A: 1, Any: make(chan int)}},
+		{pack.EncodeOptions{SkipUnsupported: true}, Tagged{A: 1, Any: []interface{}{func() {}, 2}}},
+	} {
+		want, err := tt.opts.Marshal((*plainTagged)(&tt.v))
+		assert.Nil(err)
+		got, err := tt.opts.Marshal(&tt.v)
+		assert.Nil(err)
+		assert.Equal(want, got, "%+v", tt.opts)
+		got, err = tt.opts.Marshal(tt.v)
+		assert.Nil(err)
+		assert.Equal(want, got, "%+v", tt.opts)
+
+		// the generated decoder reads the same names back
+		var out Tagged
+		dopts := pack.DecodeOptions{TagKey: tt.opts.TagKey, DisallowUnknownFields: true}
+		if assert.Nil(dopts.Unmarshal(got, &out), "%+v", tt.opts) && tt.v.Any == nil {
+			assert.Equal(tt.v, out)
+		}
+	}
+}
+
+func TestMarshalErrorParity(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, o := range []*Order{
+		{Items: []Item{{}}, Placed: time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Extra: []interface{}{1, make(chan int)}},
+	} {
+		_, want := pack.Marshal(plain(o))
+		_, got := o.MarshalKSPACK()
+		if assert.NotNil(want) && assert.NotNil(got) {
+			assert.Equal(want.Error(), got.Error())
+		}
+
+		// the generated method runs within Marshal
+		_, got = pack.Marshal(o)
+		if assert.NotNil(got) {
+			assert.Equal(want.Error(), got.Error())
+		}
+	}
+}
+
+// reflNode mirrors Node without its generated methods.
+type reflNode struct {
+	Name string      `kspack:"name"`
+	Next *reflNode   `kspack:"next"`
+	Any  interface{} `kspack:"any"`
+	Kids []reflNode  `kspack:"kids,omitempty"`
+}
+
+func TestMarshalCycleParity(t *testing.T) {
+	assert := assert.New(t)
+
+	self, reflSelf := &Node{Name: "self"}, &reflNode{Name: "self"}
+	self.Next, reflSelf.Next = self, reflSelf
+	ring, reflRing := &Node{Name: "a", Next: &Node{Name: "b"}}, &reflNode{Name: "a", Next: &reflNode{Name: "b"}}
+	ring.Next.Any, reflRing.Next.Any = ring, reflRing
+	m, reflM := map[string]interface{}{}, map[string]interface{}{}
+	m["node"], reflM["node"] = &Node{Any: m}, &reflNode{Any: reflM}
+	for _, tt := range []struct {
+		v, refl interface{}
+	}{
+		{self, reflSelf},
+		{ring, reflRing},
+		{[]interface{}{ring}, []interface{}{reflRing}},
+		{m, reflM},
+	} {
+		_, want := pack.Marshal(tt.refl)
+		_, got := pack.Marshal(tt.v)
+		if assert.NotNil(want) && assert.NotNil(got) {
+			assert.Equal(strings.Replace(want.Error(), "reflNode", "Node", -1), got.Error())
+		}
+	}
+	_, err := self.MarshalKSPACK()
+	assert.EqualError(err, "kspack: unsupported value encountered a cycle via *parity.Node through next")
+
+	// the generated code tracks the structs it enters, where the
+	// reflective encoder tracks the slice holding them
+	kids := make([]Node, 1)
+	kids[0].Kids = kids
+	_, err = pack.Marshal(kids[0])
+	assert.EqualError(err, "kspack: unsupported value encountered a cycle via *parity.Node through kids[0]")
+
+	// deep but acyclic values are fine
+	deep, reflDeep := &Node{}, &reflNode{}
+	for i := 0; i < 3000; i++ {
+		deep, reflDeep = &Node{Next: deep}, &reflNode{Next: reflDeep}
+	}
+	want, err := pack.Marshal(reflDeep)
+	assert.Nil(err)
+	got, err := pack.Marshal(deep)
+	assert.Nil(err)
+	assert.Equal(want, got)
+}
+
+// newOf returns a new zero value of the type v points to.
+func newOf(v interface{}) interface{} {
+	switch v.(type) {
+	case *Scalars:
+		return new(Scalars)
+	case *Order:
+		return new(Order)
+	case *Item:
+		return new(Item)
+	case *Options:
+		return new(Options)
+	}
+	panic("unknown type")
+}
+
+func TestUnmarshalParity(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, v := range parityValues() {
+		data, err := pack.Marshal(plain(v))
+		assert.Nil(err)
+
+		want := plain(newOf(v))
+		assert.Nil(pack.Unmarshal(data, want))
+
+		got := newOf(v)
+		assert.Nil(pack.Unmarshal(data, got))
+		assert.Equal(want, plain(got), "%T", v)
+
+		got = newOf(v)
+		assert.Nil(got.(pack.Unmarshaler).UnmarshalKSPACK(data))
+		assert.Equal(want, plain(got), "%T", v)
+
+		// decoding into a value already set merges the same way
+		if _, ok := v.(*Order); ok {
+			want, got := plain(sampleOrder()), sampleOrder()
+			assert.Nil(pack.Unmarshal(data, want))
+			assert.Nil(got.UnmarshalKSPACK(data))
+			assert.Equal(want, plain(got))
+		}
+	}
+}
+
+func TestUnmarshalCaseInsensitiveParity(t *testing.T) {
+	assert := assert.New(t)
+
+	data := rawItem(map[string]interface{}{"SKU": "x", "qTy": 2, "price": nil})
+	var want plainItem
+	assert.Nil(pack.Unmarshal(data, &want))
+	var got Item
+	assert.Nil(pack.Unmarshal(data, &got))
+	assert.Equal(want, plainItem(got))
+	assert.Equal(Item{SKU: "x", Qty: 2}, got)
+}
+
+// The reflective types mirror Order and Item without any generated
+// type inside, so that errors are reported on the same paths.
+type (
+	reflItem struct {
+		SKU   string  `kspack:"sku"`
+		Qty   int     `kspack:"qty"`
+		Price float32 `kspack:"price"`
+	}
+	reflOrder struct {
+		Items  []reflItem           `kspack:"items"`
+		ByName map[Name]*reflItem   `kspack:"by_name"`
+		Matrix [][2]int32           `kspack:"matrix"`
+		Labels map[string]string    `kspack:"labels"`
+		Gift   *reflItem            `kspack:"gift"`
+		Extra  interface{}          `kspack:"extra"`
+		Shared map[string]*reflItem `kspack:"shared"`
+	}
+)
+
+// reflNames renames the reflective types to the generated ones in the
+// errors they are reported in.
+var reflNames = strings.NewReplacer(
+	"plainScalars", "Scalars",
+	"plainOrder", "Order",
+	"plainItem", "Item",
+	"plainOptions", "Options",
+	"reflItem", "Item",
+	"reflOrder", "Order",
+)
+
+func TestUnmarshalErrorParity(t *testing.T) {
+	assert := assert.New(t)
+
+	type obj = map[string]interface{}
+	type arr = []interface{}
+	tests := []struct {
+		data interface{}
+		into interface{}
+		refl interface{}
+	}{
+		{obj{"Int8": 300}, new(Scalars), new(plainScalars)},
+		{obj{"Uint": -1}, new(Scalars), new(plainScalars)},
+		{obj{"Float32": 1e300}, new(Scalars), new(plainScalars)},
+		{obj{"String": 1}, new(Scalars), new(plainScalars)},
+		{obj{"Bytes": "text"}, new(Scalars), new(plainScalars)},
+		{obj{"Time": 1}, new(Scalars), new(plainScalars)},
+		{obj{"Time": "not a time"}, new(Scalars), new(plainScalars)},
+		{"not an object", new(Scalars), new(plainScalars)},
+		{obj{"items": arr{obj{"qty": 1}, obj{"qty": "x"}}}, new(Order), new(reflOrder)},
+		{obj{"items": obj{}}, new(Order), new(reflOrder)},
+		{obj{"by_name": obj{"a": obj{"price": true}}}, new(Order), new(reflOrder)},
+		{obj{"matrix": arr{arr{1}, arr{int64(1) << 40}}}, new(Order), new(reflOrder)},
+		{obj{"labels": obj{"a": 1}}, new(Order), new(reflOrder)},
+		{obj{"gift": obj{"sku": arr{}}}, new(Order), new(reflOrder)},
+		{obj{"req": "r", "num": "x"}, new(Options), new(plainOptions)},
+		{obj{"req": "r", "num_ptr": "300"}, new(Options), new(plainOptions)},
+		{obj{"empty": "e"}, new(Options), new(plainOptions)},
+		{obj{"": 1}, new(Item), new(plainItem)},
+	}
+	for _, tt := range tests {
+		data := rawItem(tt.data)
+		want := pack.Unmarshal(data, tt.refl)
+		got := pack.Unmarshal(data, tt.into)
+		if assert.NotNil(want, "%v", tt.data) && assert.NotNil(got, "%v", tt.data) {
+			assert.Equal(reflNames.Replace(want.Error()), got.Error())
+		}
+	}
+}
+
+func TestUnmarshalOptionsParity(t *testing.T) {
+	assert := assert.New(t)
+
+	type obj = map[string]interface{}
+	order := rawItem(plain(sampleOrder()))
+	tests := []struct {
+		opts pack.DecodeOptions
+		data []byte
+		into interface{}
+	}{
+		{pack.DecodeOptions{DisallowUnknownFields: true}, rawItem(obj{"sku": "x", "color": "red"}), new(Item)},
+		{pack.DecodeOptions{DisallowUnknownFields: true}, rawItem(obj{"gift": obj{"size": 1}}), new(Order)},
+		{pack.DecodeOptions{Lenient: true}, rawItem(obj{"Int8": int64(5), "Uint": 7, "Float32": 2, "String": 12}), new(Scalars)},
+		{pack.DecodeOptions{Lenient: true}, rawItem(obj{"Int8": 300}), new(Scalars)},
+		{pack.DecodeOptions{TagKey: "json"}, rawItem(obj{"ID": 1, "customer": "c", "Items": []obj{{"SKU": "s"}}}), new(Order)},
+	}
+	for max := 1; max <= 1024; max += 32 {
+		tests = append(tests, struct {
+			opts pack.DecodeOptions
+			data []byte
+			into interface{}
+		}{pack.DecodeOptions{MaxTotalAllocation: max}, order, new(Order)})
+	}
+	for _, tt := range tests {
+		want := plain(newOf(tt.into))
+		werr := tt.opts.Unmarshal(tt.data, want)
+		gerr := tt.opts.Unmarshal(tt.data, tt.into)
+		if werr != nil && assert.Error(gerr, "%+v", tt.opts) {
+			// the reflective decoder reports the offset it reached
+			// within the item, the generated code the item itself
+			var wl, gl *pack.LimitError
+			if errors.As(werr, &wl) && errors.As(gerr, &gl) {
+				assert.Equal(wl.Limit, gl.Limit)
+				assert.LessOrEqual(gl.Offset, wl.Offset)
+				continue
+			}
+			assert.Equal(reflNames.Replace(werr.Error()), gerr.Error(), "%+v", tt.opts)
+			continue
+		}
+		if assert.NoError(gerr, "%+v", tt.opts) {
+			assert.Equal(want, plain(tt.into), "%+v", tt.opts)
+		}
+	}
+
+	// unknown fields are collected alike, nested ones included
+	data := rawItem(obj{"color": "red", "gift": obj{"sku": "s", "size": 1}, "items": []obj{{"x": 1}}})
+	var wantUnknown, gotUnknown []pack.UnknownFieldError
+	assert.Nil(pack.DecodeOptions{UnknownFields: &wantUnknown}.Unmarshal(data, new(reflOrder)))
+	assert.Nil(pack.DecodeOptions{UnknownFields: &gotUnknown}.Unmarshal(data, new(Order)))
+	if assert.Len(gotUnknown, 3) && assert.Len(wantUnknown, 3) {
+		for i, f := range gotUnknown {
+			assert.Equal(wantUnknown[i].Key, f.Key)
+			assert.Equal(wantUnknown[i].Offset, f.Offset)
+			assert.Equal(reflNames.Replace(wantUnknown[i].Type.String()), f.Type.String())
+		}
+	}
+
+	// strings and binaries alias the input under ZeroCopy
+	data = rawItem(plain(sampleScalars()))
+	var got Scalars
+	assert.Nil(pack.DecodeOptions{ZeroCopy: true}.Unmarshal(data, &got))
+	assert.Equal(plain(sampleScalars()), plain(&got))
+	copy(data[bytes.Index(data, []byte("text")):], "TEXT")
+	copy(data[bytes.Index(data, []byte{0, 1, 2}):], []byte{3})
+	assert.Equal("TEXT", got.String)
+	assert.Equal([]byte{3, 1, 2}, got.Bytes)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package parity holds the types the parity tests of kspackgen encode
+// and decode both reflectively and with the generated methods.
+package parity
+
+import (
+	"strings"
+	"time"
+
+	"github.com/kubeservice-stack/kspack-go/pack"
+)
+
+//go:generate go run github.com/kubeservice-stack/kspack-go/cmd/kspackgen -type Scalars,Order,Item,Options,Node,Tagged
+
+type Level int8
+
+type Name string
+
+type Blob []byte
+
+// Scalars has a field of every basic kind.
+type Scalars struct {
+	Bool    bool
+	Int     int
+	Int8    int8
+	Int16   int16
+	Int32   int32
+	Int64   int64
+	Uint    uint
+	Uint8   uint8
+	Uint16  uint16
+	Uint32  uint32
+	Uint64  uint64
+	Uintptr uintptr
+	Float32 float32
+	Float64 float64
+	String  string
+	Bytes   []byte
+	Level   Level
+	Name    Name
+	Blob    Blob
+	Time    time.Time
+}
+
+type Order struct {
+	ID       int64             `kspack:"id"`
+	Customer *Name             `json:"customer"`
+	Items    []Item            `kspack:"items"`
+	Gift     *Item             `kspack:"gift"`
+	Totals   [3]float64        `kspack:"totals"`
+	Labels   map[string]string `kspack:"labels"`
+	Counts   map[int]uint16    `kspack:"counts"`
+	ByName   map[Name]*Item    `kspack:"by_name"`
+	Matrix   [][2]int32        `kspack:"matrix"`
+	Placed   time.Time         `kspack:"placed"`
+	Shipped  *time.Time        `kspack:"shipped"`
+	Extra    interface{}       `kspack:"extra"`
+	Raw      pack.RawMessage   `kspack:"raw"`
+	Code     Code              `kspack:"code"`
+	Codes    []Code            `kspack:"codes"`
+	Note     note              `kspack:"note"`
+	Skipped  string            `kspack:"-"`
+	internal string
+}
+
+type Item struct {
+	SKU   string  `kspack:"sku"`
+	Qty   int     `kspack:"qty"`
+	Price float32 `kspack:"price"`
+}
+
+// Options exercises the tag options.
+type Options struct {
+	Req     string    `kspack:"req,required"`
+	Empty   string    `kspack:"empty,omitempty"`
+	List    []int     `kspack:"list,omitempty"`
+	Ptr     *int      `kspack:"ptr,omitempty"`
+	Zero    float64   `kspack:"zero,omitzero"`
+	When    time.Time `kspack:"when,omitzero"`
+	Money   money     `kspack:"money,omitzero"`
+	Num     int       `kspack:"num,string"`
+	NumPtr  *uint8    `kspack:"num_ptr,string"`
+	Flag    bool      `kspack:"flag,string"`
+	Ratio   float32   `kspack:"ratio,string"`
+	Default int       `kspack:",omitempty"`
+	JSON    string    `json:"json_name"`
+}
+
+// Node may refer to itself.
+type Node struct {
+	Name string      `kspack:"name"`
+	Next *Node       `kspack:"next"`
+	Any  interface{} `kspack:"any"`
+	Kids []Node      `kspack:"kids,omitempty"`
+}
+
+// Tagged has other names under other tag keys.
+type Tagged struct {
+	A   int         `kspack:"a" msgpack:"alpha"`
+	B   string      `msgpack:"beta"`
+	Any interface{} `kspack:"any,omitempty" msgpack:"any,omitempty"`
+}
+
+// Code is written as text through pointer methods.
+type Code struct {
+	Major, Minor int
+}
+
+func (c *Code) MarshalText() ([]byte, error) {
+	return []byte(strings.Repeat("I", c.Major) + "." + strings.Repeat("I", c.Minor)), nil
+}
+
+func (c *Code) UnmarshalText(b []byte) error {
+	major, minor, _ := strings.Cut(string(b), ".")
+	c.Major, c.Minor = len(major), len(minor)
+	return nil
+}
+
+// note has no methods and is left to the reflective encoder.
+type note struct {
+	Text string
+	At   []int
+}
+
+type money struct {
+	Units int64
+}
+
+func (m money) IsZero() bool { return m.Units == 0 }
@@ -0,0 +1,197 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Kspackgen generates MarshalKSPACK and UnmarshalKSPACK methods for Go
+// struct types, so that they are encoded and decoded without reflection.
+//
+// Usage:
+//
+//	kspackgen -type T[,U...] [-output file] [dir]
+//
+// It is meant to be run by go generate:
+//
+//	//go:generate kspackgen -type Order,Item
+//
+// and writes by default the methods of the types of the package in dir,
+// the current directory if omitted, to <package>_kspack.go. The methods
+// write and read the same items as pack.Marshal and pack.Unmarshal, and
+// honour the kspack struct tags, or the json ones when absent, with the
+// omitempty, omitzero, string and required options. Embedded fields and
+// the inline option are not supported.
+//
+// The generated code imports package pack/genrt, its runtime.
+//
+// Fields of types the generated code does not handle itself, such as
+// interfaces, structs of other packages and types with marshaling
+// methods, go through the reflective encoder and decoder. The generated
+// EncodeKSPACK and DecodeKSPACK methods run within the encoder of
+// pack.Marshal and the decoder of pack.Unmarshal, which MarshalKSPACK
+// and UnmarshalKSPACK call: the encoder detects the cycles through the
+// generated types as through pointers, and the decoder honours its
+// DecodeOptions and allocation budget. Under a TagKey other than the
+// default one, the types are encoded and decoded reflectively, as they
+// are encoded under Canonical or SkipUnsupported. Unlike the reflective decoder, the
+// generated code stops decoding a value at its first error.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// generatedHeader starts the files written by kspackgen, which are left
+// out when reading the package again.
+const generatedHeader = "// Code generated by kspackgen"
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of struct type names; must be set")
+	output    = flag.String("output", "", "output file name; default <package>_kspack.go in dir")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage of kspackgen:\n")
+	fmt.Fprintf(os.Stderr, "\tkspackgen -type T[,U...] [-output file] [dir]\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("kspackgen: ")
+	flag.Usage = usage
+	flag.Parse()
+	if *typeNames == "" || flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := "."
+	if flag.NArg() == 1 {
+		dir = flag.Arg(0)
+	}
+
+	names := strings.Split(*typeNames, ",")
+	pkg, err := loadPackage(dir, names)
+	if err != nil {
+		log.Fatal(err)
+	}
+	src, err := generate(pkg, names)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	name := *output
+	if name == "" {
+		name = filepath.Join(dir, pkg.Name()+"_kspack.go")
+	}
+	if err := os.WriteFile(name, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadPackage parses and type-checks the package in dir, leaving out
+// its test files and the files previously generated by kspackgen. As
+// the package may refer to the methods about to be generated, the
+// errors about the methods of kspackgen missing on the types names are
+// ignored; any other error fails.
+func loadPackage(dir string, names []string) (*types.Package, error) {
+	bp, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range bp.GoFiles {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if isGenerated(f) {
+			continue
+		}
+		files = append(files, f)
+	}
+
+	var errs []string
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error: func(err error) {
+			if !isMissingMethod(err, names) {
+				errs = append(errs, err.Error())
+			}
+		},
+	}
+	pkg, _ := conf.Check(bp.ImportPath, fset, files, nil)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("type-checking package %s:\n\t%s", bp.Name, strings.Join(errs, "\n\t"))
+	}
+	return pkg, nil
+}
+
+// generatedMethods are the methods written by kspackgen.
+var generatedMethods = map[string]bool{
+	"MarshalKSPACK":   true,
+	"UnmarshalKSPACK": true,
+	"EncodeKSPACK":    true,
+	"appendKSPACK":    true,
+	"DecodeKSPACK":    true,
+}
+
+var (
+	undefinedMethod = regexp.MustCompile(`undefined \(type \*?(\w+) has no field or method (\w+)`)
+	missingMethod   = regexp.MustCompile(`(\w+) does not implement .*\(missing method (\w+)\)`)
+)
+
+// isMissingMethod reports whether the type-checking error err is about
+// a method of generatedMethods missing on one of the types names.
+func isMissingMethod(err error, names []string) bool {
+	msg := err.(types.Error).Msg
+	m := undefinedMethod.FindStringSubmatch(msg)
+	if m == nil {
+		m = missingMethod.FindStringSubmatch(msg)
+	}
+	if m == nil || !generatedMethods[m[2]] {
+		return false
+	}
+	for _, name := range names {
+		if m[1] == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isGenerated(f *ast.File) bool {
+	for _, c := range f.Comments {
+		if c.Pos() > f.Package {
+			break
+		}
+		if strings.HasPrefix(c.List[0].Text, generatedHeader) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadPackage(t *testing.T) {
+	assert := assert.New(t)
+
+	load := func(src string) error {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "a.go"), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadPackage(dir, []string{"T"})
+		return err
+	}
+
+	// the methods about to be generated may be referred to
+	assert.NoError(load(`package a
+
+type Marshaler interface{ MarshalKSPACK() ([]byte, error) }
+
+type T struct{ A int }
+
+var _ Marshaler = T{}
+
+func f(t *T) error { return t.UnmarshalKSPACK(nil) }
+`))
+
+	err := load(`package a
+
+type T struct {
+	A int
+	B Undefined
+}
+`)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "undefined: Undefined")
+	}
+
+	// only on the requested types
+	err = load(`package a
+
+type T struct{ A int }
+
+type U struct{ A int }
+
+func f(u *U) error { return u.UnmarshalKSPACK(nil) }
+`)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "u.UnmarshalKSPACK undefined")
+	}
+}
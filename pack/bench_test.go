@@ -0,0 +1,181 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// benchPerson is the flat struct of the serialization benchmarks listed
+// in benchmark/README.md.
+type benchPerson struct {
+	Name     string
+	BirthDay time.Time
+	Phone    string
+	Siblings int
+	Spouse   bool
+	Money    float64
+}
+
+var benchValue = benchPerson{
+	Name:     "dongjiang",
+	BirthDay: time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC),
+	Phone:    "13800000000",
+	Siblings: 2,
+	Spouse:   true,
+	Money:    1234.56,
+}
+
+// benchOrder nests benchPerson among slices of structs and strings, for
+// decoding that descends through several compiled decoders.
+type benchOrder struct {
+	ID       uint64
+	Customer benchPerson
+	Lines    []benchLine
+	Tags     []string
+	Note     string
+}
+
+type benchLine struct {
+	Sku      string
+	Qty      int32
+	Price    float64
+	Discount float32
+}
+
+var benchOrderValue = benchOrder{
+	ID:       42,
+	Customer: benchValue,
+	Lines: []benchLine{
+		{Sku: "a-100", Qty: 1, Price: 9.99},
+		{Sku: "b-200", Qty: 3, Price: 1.5, Discount: 0.1},
+		{Sku: "c-300", Qty: 12, Price: 0.25},
+		{Sku: "d-400", Qty: 2, Price: 120},
+	},
+	Tags: []string{"express", "gift"},
+	Note: "leave at the door",
+}
+
+// benchWide has enough fields for their lookup by key to dominate.
+type benchWide struct {
+	Host, Path, Method, Agent, Referer, Proto string
+	Status, Bytes, Port, Retries              int
+	Latency, Upstream                         float64
+	Cached, Secure, Internal                  bool
+	Region                                    string
+}
+
+var benchWideValue = benchWide{
+	Host: "example.com", Path: "/api/v1/orders", Method: "GET", Agent: "curl/8.0",
+	Referer: "-", Proto: "HTTP/1.1", Status: 200, Bytes: 5120, Port: 443, Retries: 1,
+	Latency: 0.012, Upstream: 0.009, Secure: true, Region: "north",
+}
+
+func benchUnmarshal(b *testing.B, in interface{}, newOut func() interface{}) {
+	data, err := Marshal(in)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		if err := Unmarshal(data, newOut()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Marshal(&benchValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalAppend(b *testing.B) {
+	b.ReportAllocs()
+	var buf []byte
+	for i := 0; i < b.N; i++ {
+		var err error
+		if buf, err = MarshalAppend(buf[:0], &benchValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncoder(b *testing.B) {
+	b.ReportAllocs()
+	enc := NewEncoder(io.Discard)
+	for i := 0; i < b.N; i++ {
+		if err := enc.Encode(&benchValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	benchUnmarshal(b, &benchValue, func() interface{} { return new(benchPerson) })
+}
+
+func BenchmarkUnmarshalNested(b *testing.B) {
+	benchUnmarshal(b, &benchOrderValue, func() interface{} { return new(benchOrder) })
+}
+
+func BenchmarkUnmarshalWide(b *testing.B) {
+	benchUnmarshal(b, &benchWideValue, func() interface{} { return new(benchWide) })
+}
+
+func BenchmarkUnmarshalInterface(b *testing.B) {
+	data := querySample()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		var out interface{}
+		if err := Unmarshal(data, &out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	data := querySample()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		if _, err := Get(data, "orders", 2, "items", 1, "sku"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkQuery(b *testing.B) {
+	data := querySample()
+	q := MustCompileQuery(`$.orders[*].items[?(@.qty > 2)].sku`)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	var out []Value
+	for i := 0; i < b.N; i++ {
+		var err error
+		if out, err = q.FindAppend(out[:0], data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
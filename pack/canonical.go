@@ -0,0 +1,147 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"math"
+	"sort"
+)
+
+// MarshalCanonical returns the canonical kspack encoding of v.
+//
+// Object members, from both structs and maps, are sorted bytewise by
+// key, strings and binaries always use their shortest form, negative
+// zero is written as zero and every NaN as the same NaN. Equal values
+// therefore produce identical bytes, which makes the output suitable
+// for hashing and signing.
+func MarshalCanonical(v interface{}) ([]byte, error) {
+	return EncodeOptions{Canonical: true}.Marshal(v)
+}
+
+// Canonicalize rewrites the kspack document data into the canonical form
+// produced by MarshalCanonical. Keys the decoder ignores, those of array
+// elements and of the top-level item, are dropped.
+func Canonicalize(data []byte) ([]byte, error) {
+	if err := checkValid(data); err != nil {
+		return nil, err
+	}
+	e := &encodeState{canonical: true}
+	e.canonicalItem("", data)
+	return e.data[:e.off], nil
+}
+
+// canonicalFloat maps all zeros to +0 and all NaNs to a single NaN.
+func canonicalFloat(f float64) float64 {
+	if f == 0 {
+		return 0
+	}
+	if f != f {
+		return math.NaN()
+	}
+	return f
+}
+
+type member struct {
+	key  string
+	item []byte
+}
+
+// canonicalItem writes the canonical form of the well-formed item b
+// under the key k.
+func (e *encodeState) canonicalItem(k string, b []byte) {
+	h := itemHeaderLen(b[0])
+	klen := int(b[1])
+	body := b[h+klen:]
+
+	switch b[0] {
+	case KSPACK_STRING, KSPACK_SHORT_STRING:
+		e.string(k, string(body[:len(body)-1]))
+	case KSPACK_BINARY, KSPACK_SHORT_BINARY:
+		e.binary(k, body)
+	case KSPACK_BOOL:
+		e.resizeIfNeeded(1 + 1 + len(k) + 1 + 1)
+		e.setType(KSPACK_BOOL)
+		e.setKey(k, e.setKeyLen(k))
+		if body[0] != 0 {
+			e.data[e.off] = 1
+		} else {
+			e.data[e.off] = 0
+		}
+		e.off++
+	case KSPACK_NULL:
+		e.resizeIfNeeded(1 + 1 + len(k) + 1 + 1)
+		e.setType(KSPACK_NULL)
+		e.setKey(k, e.setKeyLen(k))
+		e.data[e.off] = 0
+		e.off++
+	case KSPACK_FLOAT:
+		e.resizeIfNeeded(1 + 1 + len(k) + 1 + 4)
+		e.setType(KSPACK_FLOAT)
+		e.setKey(k, e.setKeyLen(k))
+		PutFloat32(e.data[e.off:], float32(canonicalFloat(float64(Float32(body)))))
+		e.off += 4
+	case KSPACK_DOUBLE:
+		e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
+		e.setType(KSPACK_DOUBLE)
+		e.setKey(k, e.setKeyLen(k))
+		PutFloat64(e.data[e.off:], canonicalFloat(Float64(body)))
+		e.off += 8
+	case KSPACK_OBJECT, KSPACK_ARRAY:
+		n := int(Uint32(body))
+		members := make([]member, n)
+		p := 4
+		for i := range members {
+			size := itemLen(body[p:])
+			if b[0] == KSPACK_OBJECT {
+				members[i].key = itemKey(body[p:])
+			}
+			members[i].item = body[p : p+size]
+			p += size
+		}
+		if b[0] == KSPACK_OBJECT {
+			sort.SliceStable(members, func(i, j int) bool { return members[i].key < members[j].key })
+		}
+
+		// type(1) | klen(1) | vlen(4) | key(len(k)) | 0x00 | count(4)
+		e.resizeIfNeeded(1 + 1 + 4 + len(k) + 1 + 4)
+		e.setType(b[0])
+		l := e.setKeyLen(k)
+		vlenpos := e.off
+		e.off += 4
+		e.setKey(k, l)
+		vpos := e.off
+		PutInt32(e.data[e.off:], int32(n))
+		e.off += 4
+		for _, m := range members {
+			e.canonicalItem(m.key, m.item)
+		}
+		PutInt32(e.data[vlenpos:], int32(e.off-vpos))
+	default:
+		// integers and dates have a single form
+		e.item(k, b)
+	}
+}
+
+// itemKey returns the raw name of the item starting at b[0].
+func itemKey(b []byte) string {
+	klen := int(b[1])
+	if klen == 0 {
+		return ""
+	}
+	h := itemHeaderLen(b[0])
+	return string(b[h : h+klen-1])
+}
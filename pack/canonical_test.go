@@ -0,0 +1,148 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type signed struct {
+	Zeta  string
+	Alpha map[string]int32
+	Mid   []float64
+	Blob  []byte
+	Inner *signed
+}
+
+func newSigned() *signed {
+	return &signed{
+		Zeta:  strings.Repeat("z", 254),
+		Alpha: map[string]int32{"b": 2, "a": 1, "c": 3, "aa": 4, "B": 5},
+		Mid:   []float64{math.Copysign(0, -1), math.NaN(), 1.5},
+		Blob:  []byte{1, 2, 3},
+		Inner: &signed{Zeta: "inner", Alpha: map[string]int32{"y": 1, "x": 2}},
+	}
+}
+
+func TestMarshalCanonical(t *testing.T) {
+	assert := assert.New(t)
+	first, err := MarshalCanonical(newSigned())
+	assert.NoError(err)
+	for i := 0; i < 50; i++ {
+		b, err := MarshalCanonical(newSigned())
+		assert.NoError(err)
+		assert.Equal(first, b)
+	}
+
+	// members sorted by key, shortest string form
+	assert.Equal([]byte{KSPACK_OBJECT, 0}, first[:2])
+	keys := []string{}
+	d := decodeState{data: first, off: 10}
+	for i := 0; i < 5; i++ {
+		keys = append(keys, string(d.key()))
+		d.next()
+	}
+	assert.Equal([]string{"Alpha", "Blob", "Inner", "Mid", "Zeta"}, keys)
+	assert.True(bytes.Contains(first, []byte{KSPACK_SHORT_STRING, 5, 0xff, 'Z', 'e', 't', 'a', 0}))
+
+	// floats are normalized
+	b, err := MarshalCanonical([]float32{float32(math.Copysign(0, -1))})
+	assert.NoError(err)
+	c, err := MarshalCanonical([]float32{0})
+	assert.NoError(err)
+	assert.Equal(c, b)
+
+	// the default encoding is unchanged
+	b, err = Marshal(newSigned())
+	assert.NoError(err)
+	assert.True(bytes.Contains(b, []byte{KSPACK_STRING, 5, 0xff, 0, 0, 0, 'Z', 'e', 't', 'a', 0}))
+
+	// structs and maps holding the same values are identical
+	type pair struct {
+		B string
+		A int
+	}
+	b, err = MarshalCanonical(pair{B: "b", A: 1})
+	assert.NoError(err)
+	c, err = MarshalCanonical(map[string]interface{}{"A": 1, "B": "b"})
+	assert.NoError(err)
+	assert.Equal(c, b)
+}
+
+func TestCanonicalize(t *testing.T) {
+	assert := assert.New(t)
+	want, err := MarshalCanonical(newSigned())
+	assert.NoError(err)
+
+	for i := 0; i < 10; i++ {
+		b, err := Marshal(newSigned())
+		assert.NoError(err)
+		got, err := Canonicalize(b)
+		assert.NoError(err)
+		assert.Equal(want, got)
+	}
+
+	// dropped array element keys, long strings and raw bools
+	got, err := Canonicalize([]byte{
+		KSPACK_ARRAY, 2, 19, 0, 0, 0, 'k', 0,
+		2, 0, 0, 0,
+		KSPACK_STRING, 2, 2, 0, 0, 0, 'e', 0, 'a', 0,
+		KSPACK_BOOL, 2, 'f', 0, 7,
+	})
+	assert.NoError(err)
+	assert.Equal([]byte{
+		KSPACK_ARRAY, 0, 12, 0, 0, 0,
+		2, 0, 0, 0,
+		KSPACK_SHORT_STRING, 0, 2, 'a', 0,
+		KSPACK_BOOL, 0, 1,
+	}, got)
+
+	_, err = Canonicalize([]byte{KSPACK_OBJECT, 0, 4})
+	assert.Error(err)
+}
+
+func TestEncoderCanonical(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetOptions(EncodeOptions{Canonical: true})
+	assert.NoError(enc.Encode(newSigned()))
+
+	want, err := MarshalCanonical(newSigned())
+	assert.NoError(err)
+	assert.Equal(want, buf.Bytes())
+}
+
+func TestCanonicalMarshaler(t *testing.T) {
+	assert := assert.New(t)
+	raw, err := Marshal(map[string]string{"b": "2", "a": "1", "c": "3"})
+	assert.NoError(err)
+
+	b, err := MarshalCanonical(&envelope{Route: "r", Payload: raw})
+	assert.NoError(err)
+	c, err := MarshalCanonical(map[string]interface{}{
+		"route":   "r",
+		"payload": map[string]string{"a": "1", "b": "2", "c": "3"},
+	})
+	assert.NoError(err)
+	assert.Equal(c, b)
+}
@@ -42,6 +42,14 @@ const (
 	KSPACK_SHORT_STRING = KSPACK_STRING | KSPACK_SHORT_ITEM
 	KSPACK_SHORT_BINARY = KSPACK_BINARY | KSPACK_SHORT_ITEM
 
+	// KSPACK_DATE holds a UTC instant as an 8 byte unix nanoseconds value:
+	// type(1) | name length(1) | raw name bytes | 0x00 | unix nanoseconds(8)
+	// KSPACK_ZONED_DATE adds the zone offset east of UTC in seconds:
+	// type(1) | name length(1) | content length(1) | raw name bytes | 0x00
+	// | unix nanoseconds(8) | zone offset(4)
+	// The zero time.Time is written as math.MinInt64 nanoseconds.
+	KSPACK_ZONED_DATE = KSPACK_DATE | KSPACK_SHORT_ITEM
+
 	KSPACK_KEY_MAX_LEN = 254
 
 	MAX_SHORT_VITEM_LEN = 255
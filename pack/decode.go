@@ -17,10 +17,16 @@ limitations under the License.
 package pack
 
 import (
-	"bytes"
+	"encoding"
 	"errors"
+	"fmt"
+	"math"
 	"reflect"
 	"runtime"
+	"strconv"
+	"sync"
+	"time"
+	"unsafe"
 )
 
 var (
@@ -28,12 +34,88 @@ var (
 	errUnexpectedEnd = errors.New("unexpected end")
 )
 
+var (
+	unmarshalerType       = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	interfaceType         = reflect.TypeOf((*interface{})(nil)).Elem()
+	stringType            = reflect.TypeOf("")
+)
+
 func Unmarshal(data []byte, v interface{}) error {
-	var d decodeState
+	return DecodeOptions{}.Unmarshal(data, v)
+}
+
+// DecodeOptions configures how kspack items are decoded. The zero value
+// gives the behavior of Unmarshal. Limits left at zero are not
+// enforced; set them all when decoding input from untrusted sources.
+// Exceeding a limit fails decoding with a *LimitError.
+type DecodeOptions struct {
+	// TagKey is the struct tag key read for field names and options,
+	// such as "msgpack" or "bson". Fields without it fall back to their
+	// json tag. It defaults to DefaultTagKey.
+	TagKey string
+
+	// Lenient converts between integer widths and signedness, integers
+	// and floats, and numbers and strings, as long as the value is
+	// preserved. Otherwise an item is only stored into a Go value of
+	// its own kind, and an *UnmarshalTypeError is reported.
+	Lenient bool
+
+	// ZeroCopy makes decoded strings, []byte values, string map keys
+	// and RawMessages alias the input instead of copying it, which
+	// saves memory when decoding large payloads that are only read.
+	// The caller must then neither modify the input nor release it to
+	// a buffer pool while the decoded value is in use: changes to the
+	// input show through the strings, whose immutability Go otherwise
+	// guarantees. With a Decoder, the input is its internal buffer,
+	// which later calls to Decode or More overwrite.
+	// Aliased bytes do not count against MaxTotalAllocation.
+	ZeroCopy bool
+
+	// DisallowUnknownFields fails decoding with an *UnknownFieldError
+	// when an object member matches no field of the destination struct.
+	DisallowUnknownFields bool
+
+	// UnknownFields, if not nil, receives an UnknownFieldError for each
+	// object member that matches no field of the destination struct, in
+	// input order, without interrupting decoding. They are appended to
+	// the slice it points to, which is left as is when there are none.
+	UnknownFields *[]UnknownFieldError
+
+	// MaxDepth bounds the nesting of objects and arrays. Nesting is
+	// always bounded to 10000 levels.
+	MaxDepth int
+	// MaxElements bounds the member number of any object or array.
+	MaxElements int
+	// MaxStringLen bounds the length in bytes of any string.
+	MaxStringLen int
+	// MaxBinaryLen bounds the length in bytes of any binary.
+	MaxBinaryLen int
+	// MaxTotalAllocation bounds the memory, in bytes, allocated for the
+	// strings, binaries, slices, maps and pointers of the decoded value.
+	MaxTotalAllocation int
+	// MaxItemSize bounds the length in bytes of the items a Decoder
+	// reads, checked against the length declared in their header before
+	// they are buffered. Unmarshal, given its item in full, ignores it.
+	MaxItemSize int
+}
+
+// Unmarshal decodes the kspack item data into the value pointed to by v
+// according to o.
+func (o DecodeOptions) Unmarshal(data []byte, v interface{}) error {
+	d := newDecodeState()
+	defer d.release()
+
+	d.opts = o
 	d.init(data)
 	return d.unmarshal(v)
 }
 
+// Unmarshaler is the interface implemented by types that can unmarshal
+// a kspack item of themselves. The item still carries the key it was
+// stored under. UnmarshalKSPACK must copy the data if it wishes to retain
+// it after returning.
 type Unmarshaler interface {
 	UnmarshalKSPACK([]byte) error
 }
@@ -42,19 +124,138 @@ type decodeState struct {
 	data       []byte
 	off        int
 	savedError error
+	opts       DecodeOptions
+	allocated  int // bytes allocated so far, counted against opts.MaxTotalAllocation
+	path       []pathElem
+	root       string // name of the struct type decoded into, if any
+
+	collectUnknownFields bool
+	unknownFields        []UnknownFieldError
+}
+
+var decodeStatePool sync.Pool
+
+// newDecodeState returns a decodeState from the pool, to be initialized
+// with init and given back with release.
+func newDecodeState() *decodeState {
+	if v := decodeStatePool.Get(); v != nil {
+		return v.(*decodeState)
+	}
+	return new(decodeState)
+}
+
+// release puts d back into the pool, dropping its references to the
+// input and the decoded value.
+func (d *decodeState) release() {
+	path := d.path[:cap(d.path)]
+	for i := range path {
+		path[i] = pathElem{}
+	}
+	*d = decodeState{path: path[:0]}
+	decodeStatePool.Put(d)
 }
 
 func (d *decodeState) init(data []byte) *decodeState {
 	d.data = data
 	d.off = 0
 	d.savedError = nil
+	d.allocated = 0
+	d.path = d.path[:0]
+	d.root = ""
+	d.unknownFields = nil
 	return d
 }
 
+// A pathElem is a step from a value being decoded to one of its parts:
+// a struct field, a map entry or an array element.
+type pathElem struct {
+	name  string // struct field name
+	key   []byte // map key, if name is empty
+	index int    // array index, if name and key are empty
+}
+
+// field returns the path of the value being decoded from the root,
+// e.g. "Items[3].Price".
+func (d *decodeState) field() string {
+	var b []byte
+	for _, p := range d.path {
+		switch {
+		case p.name != "" || p.key != nil:
+			if len(b) > 0 {
+				b = append(b, '.')
+			}
+			b = append(b, p.name...)
+			b = append(b, p.key...)
+		default:
+			b = append(b, '[')
+			b = strconv.AppendInt(b, int64(p.index), 10)
+			b = append(b, ']')
+		}
+	}
+	return string(b)
+}
+
+// typeError records that the item at offset off, described by what,
+// cannot be stored in a Go value of type t.
+func (d *decodeState) typeError(what string, t reflect.Type, off int) {
+	d.saveError(&UnmarshalTypeError{Value: what, Type: t, Offset: int64(off), Field: d.rootedField(d.field())})
+}
+
+// rootedField prefixes the non-empty field path with the name of the
+// struct type decoded into, if any.
+func (d *decodeState) rootedField(field string) string {
+	if field == "" || d.root == "" {
+		return field
+	}
+	return joinPath(d.root, field)
+}
+
+// tagKey returns the struct tag key to read field names from.
+func (d *decodeState) tagKey() string {
+	if d.opts.TagKey == "" {
+		return DefaultTagKey
+	}
+	return d.opts.TagKey
+}
+
+// alloc accounts for n bytes about to be allocated for the decoded value.
+func (d *decodeState) alloc(n int) {
+	max := d.opts.MaxTotalAllocation
+	if max <= 0 {
+		return
+	}
+	if n > max-d.allocated {
+		d.error(&LimitError{Limit: "MaxTotalAllocation", Max: max, Offset: int64(d.off)})
+	}
+	d.allocated += n
+}
+
+// unknownField reports an object member at offset off whose key matches
+// no field of the struct type t.
+func (d *decodeState) unknownField(t reflect.Type, key []byte, off int) {
+	if d.opts.DisallowUnknownFields {
+		d.error(&UnknownFieldError{Key: string(key), Type: t, Offset: int64(off)})
+	}
+	if d.collectUnknownFields {
+		d.unknownFields = append(d.unknownFields, UnknownFieldError{Key: string(key), Type: t, Offset: int64(off)})
+	}
+	if p := d.opts.UnknownFields; p != nil {
+		*p = append(*p, UnknownFieldError{Key: string(key), Type: t, Offset: int64(off)})
+	}
+}
+
 func (d *decodeState) error(err error) {
 	panic(err)
 }
 
+// saveError saves the first err it is called with,
+// for reporting at the end of the unmarshal.
+func (d *decodeState) saveError(err error) {
+	if d.savedError == nil {
+		d.savedError = err
+	}
+}
+
 func (d *decodeState) unmarshal(v interface{}) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -69,6 +270,26 @@ func (d *decodeState) unmarshal(v interface{}) (err error) {
 	if rv.Kind() != reflect.Ptr || rv.IsNil() {
 		return &InvalidUnmarshalError{reflect.TypeOf(v)}
 	}
+	t := rv.Type().Elem()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct {
+		d.root = t.Name()
+	}
+
+	// Check for well-formedness first, so that the decoder
+	// never reads past the end of an item.
+	s := scanner{limits: d.opts}
+	if err := s.scan(d.data[d.off:]); err != nil {
+		switch err := err.(type) {
+		case *SyntaxError:
+			err.Offset += int64(d.off)
+		case *LimitError:
+			err.Offset += int64(d.off)
+		}
+		return err
+	}
 
 	d.value(rv)
 	if d.savedError != nil {
@@ -83,11 +304,14 @@ func (d *decodeState) unmarshal(v interface{}) (err error) {
 // indirect walks down v allocating pointers as needed,
 // until it gets to a non-pointer.
 // if it encounters an Unmarshaler, indirect stops and returns that.
+// if it encounters an encoding.TextUnmarshaler or BinaryUnmarshaler,
+// indirect stops and returns that along with the value it points to, so
+// that items they cannot handle are decoded as usual.
 // if decodingNull is true, indirect stops at the last pointer so that
 // it can be set to nil.
-func (d *decodeState) indirect(v reflect.Value, decodingNull bool) (Unmarshaler, reflect.Value) {
+func (d *decodeState) indirect(v reflect.Value, decodingNull bool) (Unmarshaler, encoding.TextUnmarshaler, encoding.BinaryUnmarshaler, reflect.Value) {
 	if !v.IsValid() {
-		return nil, reflect.Value{}
+		return nil, nil, nil, reflect.Value{}
 	}
 	// If v is a named type and is addressable
 	// start with its address, so that is the type has pointer
@@ -118,42 +342,169 @@ func (d *decodeState) indirect(v reflect.Value, decodingNull bool) (Unmarshaler,
 		if v.IsNil() {
 			// nil pointer
 			if d.data[d.off] == KSPACK_NULL {
-				return nil, v
+				return nil, nil, nil, v
 			}
+			d.alloc(int(v.Type().Elem().Size()))
 			v.Set(reflect.New(v.Type().Elem()))
 		}
 
 		if v.Type().NumMethod() > 0 {
 			if u, ok := v.Interface().(Unmarshaler); ok {
-				return u, reflect.Value{}
+				return u, nil, nil, reflect.Value{}
+			}
+			ut, _ := v.Interface().(encoding.TextUnmarshaler)
+			bu, _ := v.Interface().(encoding.BinaryUnmarshaler)
+			if ut != nil || bu != nil {
+				return nil, ut, bu, v.Elem()
 			}
 		}
 		v = v.Elem()
 	}
-	return nil, v
+	return nil, nil, nil, v
 }
 
+// value decodes the item at d.off into v, or skips it if v is invalid.
 func (d *decodeState) value(v reflect.Value) {
 	if !v.IsValid() {
 		d.next()
 		return
 	}
+	typeDecoder(v.Type(), d.tagKey())(d, v)
+}
+
+type decoderFunc func(d *decodeState, v reflect.Value)
+
+var decoderCache sync.Map // map[cacheKey]decoderFunc
+
+func typeDecoder(t reflect.Type, tagKey string) decoderFunc {
+	key := cacheKey{t, tagKey}
+	if fi, ok := decoderCache.Load(key); ok {
+		return fi.(decoderFunc)
+	}
+
+	// To deal with recursive types, populate the map with an
+	// indirect func before we build it. This type waits on the
+	// real func (f) to be ready and then calls it. This indirect
+	// func is only used for recursive types.
+	var (
+		wg sync.WaitGroup
+		f  decoderFunc
+	)
+	wg.Add(1)
+	fi, loaded := decoderCache.LoadOrStore(key, decoderFunc(func(d *decodeState, v reflect.Value) {
+		wg.Wait()
+		f(d, v)
+	}))
+	if loaded {
+		return fi.(decoderFunc)
+	}
+
+	// Compute the real decoder and replace the indirect func with it.
+	f = newTypeDecoder(t, tagKey, true)
+	wg.Done()
+	decoderCache.Store(key, f)
+	return f
+}
+
+// newTypeDecoder constructs a decoderFunc for a type.
+// If allowIndirect is true, values of t are first walked by indirect to
+// reach pointed-to values and unmarshalers; types that have neither are
+// decoded in place.
+func newTypeDecoder(t reflect.Type, tagKey string, allowIndirect bool) decoderFunc {
+	if allowIndirect && needsIndirect(t) {
+		return newIndirectDecoder(t, newTypeDecoder(t, tagKey, false))
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return newStructDecoder(t, tagKey)
+	case reflect.Map:
+		return newMapDecoder(t, tagKey)
+	case reflect.Slice, reflect.Array:
+		return newArrayDecoder(t, tagKey)
+	}
+	return (*decodeState).scalar
+}
+
+// needsIndirect reports whether values of t must go through indirect:
+// pointers, interfaces and types whose pointer implements Unmarshaler,
+// encoding.TextUnmarshaler or encoding.BinaryUnmarshaler.
+func needsIndirect(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		return true
+	}
+	pt := reflect.PtrTo(t)
+	return pt.Implements(unmarshalerType) || pt.Implements(textUnmarshalerType) || pt.Implements(binaryUnmarshalerType)
+}
 
-	u, pv := d.indirect(v, false)
+type indirectDecoder struct {
+	typ     reflect.Type
+	elemDec decoderFunc
+}
+
+func (id indirectDecoder) decode(d *decodeState, v reflect.Value) {
+	u, ut, bu, pv := d.indirect(v, false)
 	if u != nil {
+		if m, ok := u.(*RawMessage); ok && d.opts.ZeroCopy {
+			item := d.next()
+			*m = item[:len(item):len(item)]
+			return
+		}
+		if g, ok := u.(generatedDecoder); ok {
+			d.generated(g)
+			return
+		}
 		if err := u.UnmarshalKSPACK(d.next()); err != nil {
 			d.error(err)
 		}
 		return
 	}
+	if ut != nil || bu != nil {
+		if d.unmarshalEncoding(ut, bu) {
+			return
+		}
+	}
+	if pv.Type() == id.typ {
+		id.elemDec(d, pv)
+		return
+	}
+	// indirect stopped at a value of another type, e.g. the one a
+	// pointer points to. Walking that value again stops at itself.
+	d.value(pv)
+}
+
+// newIndirectDecoder returns a decoder for values of t that runs elemDec,
+// the decoder of t itself, once indirect stops at a value of type t.
+func newIndirectDecoder(t reflect.Type, elemDec decoderFunc) decoderFunc {
+	dec := indirectDecoder{t, elemDec}
+	return dec.decode
+}
+
+// scalar decodes the item at d.off into v, which is neither a
+// pointer nor an unmarshaler to be walked through by indirect.
+// Objects and arrays reach it only to be reported as mismatches or
+// stored in an empty interface.
+func (d *decodeState) scalar(v reflect.Value) {
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 && d.data[d.off] != KSPACK_NULL {
+		v.Set(reflect.ValueOf(d.valueInterface()))
+		return
+	}
 
-	v = pv
+	if itemHeaderLen(d.data[d.off]) == 0 {
+		d.error(&SyntaxError{Msg: fmt.Sprintf("unknown item type 0x%02x", d.data[d.off]), Offset: int64(d.off)})
+	}
+	if !assignable(d.data[d.off], v) {
+		if d.opts.Lenient && d.coerce(v) {
+			return
+		}
+		d.typeError(typeName(d.data[d.off]), v.Type(), d.off)
+		d.next()
+		return
+	}
 
 	switch d.data[d.off] {
-	case KSPACK_OBJECT:
-		d.object(v)
-	case KSPACK_ARRAY:
-		d.array(v)
+	case KSPACK_OBJECT, KSPACK_ARRAY:
+		// decoded by the struct, map and array decoders of v
+		d.value(v)
 	case KSPACK_STRING:
 		d.string(v)
 	case KSPACK_SHORT_STRING:
@@ -184,11 +535,264 @@ func (d *decodeState) value(v reflect.Value) {
 		d.float(v)
 	case KSPACK_DOUBLE:
 		d.double(v)
+	case KSPACK_DATE:
+		d.date(v)
+	case KSPACK_ZONED_DATE:
+		d.zonedDate(v)
 	case KSPACK_NULL:
 		d.null(v)
 	}
 }
 
+// setInt stores n, decoded from the item at offset off, into the signed
+// integer v unless it overflows.
+func (d *decodeState) setInt(v reflect.Value, n int64, off int) {
+	if v.OverflowInt(n) {
+		d.typeError(typeName(d.data[off])+" "+strconv.FormatInt(n, 10), v.Type(), off)
+		return
+	}
+	v.SetInt(n)
+}
+
+// setUint stores n, decoded from the item at offset off, into the
+// unsigned integer v unless it overflows.
+func (d *decodeState) setUint(v reflect.Value, n uint64, off int) {
+	if v.OverflowUint(n) {
+		d.typeError(typeName(d.data[off])+" "+strconv.FormatUint(n, 10), v.Type(), off)
+		return
+	}
+	v.SetUint(n)
+}
+
+// coerce stores a number or string item into v, a number or string of
+// another kind, converting it as allowed by DecodeOptions.Lenient. It
+// reports false, consuming nothing, if there is no conversion between
+// the item and v at all.
+func (d *decodeState) coerce(v reflect.Value) bool {
+	start := d.off
+	typ := d.data[d.off]
+	switch typ {
+	case KSPACK_OBJECT, KSPACK_ARRAY, KSPACK_BINARY, KSPACK_SHORT_BINARY,
+		KSPACK_BOOL, KSPACK_DATE, KSPACK_ZONED_DATE, KSPACK_NULL:
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.String:
+	default:
+		return false
+	}
+
+	// the item as text, or as the one of i, u and f its type tells
+	var (
+		s     string
+		i     int64
+		u     uint64
+		f     float64
+		class byte
+	)
+	switch x := d.valueInterface().(type) {
+	case int8:
+		i, class = int64(x), 'i'
+	case int16:
+		i, class = int64(x), 'i'
+	case int32:
+		i, class = int64(x), 'i'
+	case int64:
+		i, class = x, 'i'
+	case uint8:
+		u, class = uint64(x), 'u'
+	case uint16:
+		u, class = uint64(x), 'u'
+	case uint32:
+		u, class = uint64(x), 'u'
+	case uint64:
+		u, class = x, 'u'
+	case float32:
+		f, class = float64(x), 'f'
+	case float64:
+		f, class = x, 'f'
+	case string:
+		s, class = x, 's'
+	}
+	switch class {
+	case 'i':
+		s = strconv.FormatInt(i, 10)
+	case 'u':
+		s = strconv.FormatUint(u, 10)
+	case 'f':
+		bits := 64
+		if typ == KSPACK_FLOAT {
+			bits = 32
+		}
+		s = strconv.FormatFloat(f, 'g', -1, bits)
+	}
+
+	ok := false
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+		ok = true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch class {
+		case 'u':
+			i = int64(u)
+			ok = u <= math.MaxInt64
+		case 'f':
+			i = int64(f)
+			ok = f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64
+		case 's':
+			var err error
+			i, err = strconv.ParseInt(s, 10, 64)
+			ok = err == nil
+		}
+		if ok = ok && !v.OverflowInt(i); ok {
+			v.SetInt(i)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch class {
+		case 'i':
+			u = uint64(i)
+			ok = i >= 0
+		case 'f':
+			u = uint64(f)
+			ok = f == math.Trunc(f) && f >= 0 && f < math.MaxUint64
+		case 's':
+			var err error
+			u, err = strconv.ParseUint(s, 10, 64)
+			ok = err == nil
+		}
+		if ok = ok && !v.OverflowUint(u); ok {
+			v.SetUint(u)
+		}
+	case reflect.Float32, reflect.Float64:
+		// integers must convert exactly at the width of v
+		switch class {
+		case 'i':
+			if f = float64(i); v.Kind() == reflect.Float32 {
+				f = float64(float32(i))
+			}
+			ok = f >= math.MinInt64 && f < math.MaxInt64 && int64(f) == i
+		case 'u':
+			if f = float64(u); v.Kind() == reflect.Float32 {
+				f = float64(float32(u))
+			}
+			ok = f < math.MaxUint64 && uint64(f) == u
+		case 's':
+			var err error
+			f, err = strconv.ParseFloat(s, v.Type().Bits())
+			ok = err == nil
+		}
+		if ok = ok && !v.OverflowFloat(f); ok {
+			v.SetFloat(f)
+		}
+	}
+	if !ok {
+		d.typeError(typeName(typ)+" "+s, v.Type(), start)
+	}
+	return true
+}
+
+// assignable reports whether an item of type typ can be stored in v.
+func assignable(typ byte, v reflect.Value) bool {
+	switch typ {
+	case KSPACK_OBJECT:
+		return v.Kind() == reflect.Map || v.Kind() == reflect.Struct
+	case KSPACK_ARRAY:
+		return v.Kind() == reflect.Slice || v.Kind() == reflect.Array
+	case KSPACK_STRING, KSPACK_SHORT_STRING:
+		return v.Kind() == reflect.String
+	case KSPACK_BINARY, KSPACK_SHORT_BINARY:
+		return v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8
+	case KSPACK_INT8, KSPACK_INT16, KSPACK_INT32, KSPACK_INT64:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return true
+		}
+	case KSPACK_UINT8, KSPACK_UINT16, KSPACK_UINT32, KSPACK_UINT64:
+		switch v.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return true
+		}
+	case KSPACK_BOOL:
+		return v.Kind() == reflect.Bool
+	case KSPACK_FLOAT, KSPACK_DOUBLE:
+		return v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64
+	case KSPACK_DATE, KSPACK_ZONED_DATE:
+		return timeType.AssignableTo(v.Type())
+	case KSPACK_NULL:
+		return true
+	}
+	return false
+}
+
+// unmarshalEncoding hands a string item to ut or a binary item to bu.
+// It reports false, consuming nothing, if the item is of any other kind
+// or the matching interface is not implemented.
+func (d *decodeState) unmarshalEncoding(ut encoding.TextUnmarshaler, bu encoding.BinaryUnmarshaler) bool {
+	var err error
+	switch typ := d.data[d.off]; {
+	case ut != nil && (typ == KSPACK_STRING || typ == KSPACK_SHORT_STRING):
+		err = ut.UnmarshalText(d.stringBytes())
+	case bu != nil && (typ == KSPACK_BINARY || typ == KSPACK_SHORT_BINARY):
+		err = bu.UnmarshalBinary(d.binaryBytes())
+	default:
+		return false
+	}
+	if err != nil {
+		d.error(err)
+	}
+	return true
+}
+
+// stringBytes consumes a STRING or SHORT_STRING item and returns its
+// content without the trailing 0x00.
+func (d *decodeState) stringBytes() []byte {
+	b := d.binaryBytes()
+	return b[:len(b)-1]
+}
+
+// text returns the string b of the input, aliasing it under ZeroCopy.
+func (d *decodeState) text(b []byte) string {
+	if d.opts.ZeroCopy {
+		if len(b) == 0 {
+			return ""
+		}
+		return *(*string)(unsafe.Pointer(&b))
+	}
+	d.alloc(len(b))
+	return string(b)
+}
+
+// blob returns the binary b of the input, aliasing it under ZeroCopy.
+// An aliasing slice is capped, so that appending to it never writes
+// into the input.
+func (d *decodeState) blob(b []byte) []byte {
+	if d.opts.ZeroCopy {
+		return b[:len(b):len(b)]
+	}
+	d.alloc(len(b))
+	return append(make([]byte, 0, len(b)), b...)
+}
+
+// binaryBytes consumes a variable length item and returns its content.
+func (d *decodeState) binaryBytes() []byte {
+	h := itemHeaderLen(d.data[d.off])
+	klen := int(Uint8(d.data[d.off+1:]))
+	vlen := 0
+	if h == 3 {
+		vlen = int(Uint8(d.data[d.off+2:]))
+	} else {
+		vlen = int(Uint32(d.data[d.off+2:]))
+	}
+	d.off += h + klen // header, name and 0x00
+
+	val := d.data[d.off : d.off+vlen]
+	d.off += vlen // value
+	return val
+}
+
 func (d *decodeState) next() []byte {
 	start := d.off
 	typ := d.data[d.off]
@@ -238,10 +842,23 @@ func (d *decodeState) next() []byte {
 	case KSPACK_DOUBLE:
 		vlen = 8
 	case KSPACK_DATE:
-		// FIXME
+		vlen = 8
+	case KSPACK_ZONED_DATE:
+		vlen = int(Uint8(d.data[d.off:]))
+		d.off++
 	case KSPACK_NULL:
 		vlen = 1
 	}
+	if vlen == 0 && (typ == KSPACK_OBJECT || typ == KSPACK_ARRAY) {
+		// content length left unknown, walk the members
+		s := scanner{}
+		end, err := s.item(d.data, start, 0)
+		if err != nil {
+			d.error(err)
+		}
+		d.off = end
+		return d.data[start:d.off]
+	}
 	d.off += klen + vlen
 	return d.data[start:d.off]
 }
@@ -259,7 +876,7 @@ func (d *decodeState) string(v reflect.Value) {
 
 	d.off += klen // name and 0x00
 
-	val := string(d.data[d.off : d.off+vlen-1])
+	val := d.text(d.data[d.off : d.off+vlen-1])
 	d.off += vlen // value and 0x00
 
 	v.SetString(val)
@@ -276,7 +893,7 @@ func (d *decodeState) stringInterface() interface{} {
 
 	d.off += klen // name and 0x00
 
-	val := string(d.data[d.off : d.off+vlen-1])
+	val := d.text(d.data[d.off : d.off+vlen-1])
 	d.off += vlen // value and 0x00
 
 	return val
@@ -295,7 +912,7 @@ func (d *decodeState) shortString(v reflect.Value) {
 
 	d.off += klen // name and 0x00
 
-	val := string(d.data[d.off : d.off+vlen-1])
+	val := d.text(d.data[d.off : d.off+vlen-1])
 	d.off += vlen // value and 0x00
 
 	v.SetString(val)
@@ -312,7 +929,7 @@ func (d *decodeState) shortStringInterface() interface{} {
 
 	d.off += klen // name and 0x00
 
-	val := string(d.data[d.off : d.off+vlen-1])
+	val := d.text(d.data[d.off : d.off+vlen-1])
 	d.off += vlen // value and 0x00
 
 	return val
@@ -331,7 +948,7 @@ func (d *decodeState) binary(v reflect.Value) {
 
 	d.off += klen // name and 0x00
 
-	val := d.data[d.off : d.off+vlen]
+	val := d.blob(d.data[d.off : d.off+vlen])
 	d.off += vlen // value
 
 	v.SetBytes(val)
@@ -348,7 +965,7 @@ func (d *decodeState) binaryInterface() interface{} {
 
 	d.off += klen // name and 0x00
 
-	val := d.data[d.off : d.off+vlen]
+	val := d.blob(d.data[d.off : d.off+vlen])
 	d.off += vlen // value
 
 	return val
@@ -367,7 +984,7 @@ func (d *decodeState) shortBinary(v reflect.Value) {
 
 	d.off += klen // name and 0x00
 
-	val := d.data[d.off : d.off+vlen]
+	val := d.blob(d.data[d.off : d.off+vlen])
 	d.off += vlen // value
 
 	v.SetBytes(val)
@@ -384,7 +1001,7 @@ func (d *decodeState) shortBinaryInterface() interface{} {
 
 	d.off += klen // name and 0x00
 
-	val := d.data[d.off : d.off+vlen]
+	val := d.blob(d.data[d.off : d.off+vlen])
 	d.off += vlen // value
 
 	return val
@@ -392,13 +1009,14 @@ func (d *decodeState) shortBinaryInterface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(1)
 func (d *decodeState) int8(v reflect.Value) {
+	start := d.off
 	d.off++ // type
 	klen := int(Uint8(d.data[d.off:]))
 	d.off++ // name length
 	d.off += klen
 	val := Int8(d.data[d.off:])
 	d.off++ // value
-	v.SetInt(int64(val))
+	d.setInt(v, int64(val), start)
 }
 
 func (d *decodeState) int8Interface() interface{} {
@@ -414,13 +1032,14 @@ func (d *decodeState) int8Interface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(1)
 func (d *decodeState) uint8(v reflect.Value) {
+	start := d.off
 	d.off++ // type
 	klen := int(Uint8(d.data[d.off:]))
 	d.off++ // name length
 	d.off += klen
 	val := Uint8(d.data[d.off:])
 	d.off++ // value
-	v.SetUint(uint64(val))
+	d.setUint(v, uint64(val), start)
 }
 
 func (d *decodeState) uint8Interface() interface{} {
@@ -435,6 +1054,7 @@ func (d *decodeState) uint8Interface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(2)
 func (d *decodeState) int16(v reflect.Value) {
+	start := d.off
 	d.off++ // type
 	klen := int(Uint8(d.data[d.off:]))
 	d.off++ // name length
@@ -443,7 +1063,7 @@ func (d *decodeState) int16(v reflect.Value) {
 	d.off += 2 // value
 	// unsupported in libkspack, int32 employed
 	// d.off += 4
-	v.SetInt(int64(val))
+	d.setInt(v, int64(val), start)
 }
 
 func (d *decodeState) int16Interface() interface{} {
@@ -458,6 +1078,7 @@ func (d *decodeState) int16Interface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(2)
 func (d *decodeState) uint16(v reflect.Value) {
+	start := d.off
 	d.off++ // type
 	klen := int(Uint8(d.data[d.off:]))
 	d.off++ // name length
@@ -466,7 +1087,7 @@ func (d *decodeState) uint16(v reflect.Value) {
 	d.off += 2 // value
 	// unsupported in libkspack, int32 employed
 	// d.off += 4
-	v.SetUint(uint64(val))
+	d.setUint(v, uint64(val), start)
 }
 
 func (d *decodeState) uint16Interface() interface{} {
@@ -481,6 +1102,7 @@ func (d *decodeState) uint16Interface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(4)
 func (d *decodeState) int32(v reflect.Value) {
+	start := d.off
 	d.off++ // type
 
 	klen := int(Uint8(d.data[d.off:]))
@@ -491,7 +1113,7 @@ func (d *decodeState) int32(v reflect.Value) {
 	val := Int32(d.data[d.off:])
 	d.off += 4 // value
 
-	v.SetInt(int64(val))
+	d.setInt(v, int64(val), start)
 }
 
 func (d *decodeState) int32Interface() interface{} {
@@ -510,6 +1132,7 @@ func (d *decodeState) int32Interface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(4)
 func (d *decodeState) uint32(v reflect.Value) {
+	start := d.off
 	d.off++ // type
 
 	klen := int(Uint8(d.data[d.off:]))
@@ -520,7 +1143,7 @@ func (d *decodeState) uint32(v reflect.Value) {
 	val := Uint32(d.data[d.off:])
 	d.off += 4 // value
 
-	v.SetUint(uint64(val))
+	d.setUint(v, uint64(val), start)
 }
 
 func (d *decodeState) uint32Interface() interface{} {
@@ -539,6 +1162,7 @@ func (d *decodeState) uint32Interface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(8)
 func (d *decodeState) int64(v reflect.Value) {
+	start := d.off
 	d.off++ // type
 
 	klen := int(Uint8(d.data[d.off:]))
@@ -549,7 +1173,7 @@ func (d *decodeState) int64(v reflect.Value) {
 	val := Int64(d.data[d.off:])
 	d.off += 8 // value
 
-	v.SetInt(val)
+	d.setInt(v, val, start)
 }
 
 func (d *decodeState) int64Interface() interface{} {
@@ -568,6 +1192,7 @@ func (d *decodeState) int64Interface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(8)
 func (d *decodeState) uint64(v reflect.Value) {
+	start := d.off
 	d.off++ // type
 
 	klen := int(Uint8(d.data[d.off:]))
@@ -578,7 +1203,7 @@ func (d *decodeState) uint64(v reflect.Value) {
 	val := Uint64(d.data[d.off:])
 	d.off += 8 // value
 
-	v.SetUint(val)
+	d.setUint(v, val, start)
 }
 
 func (d *decodeState) uint64Interface() interface{} {
@@ -691,6 +1316,7 @@ func (d *decodeState) floatInterface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(8)
 func (d *decodeState) double(v reflect.Value) {
+	start := d.off
 	d.off++ // type
 
 	klen := int(Uint8(d.data[d.off:]))
@@ -701,6 +1327,10 @@ func (d *decodeState) double(v reflect.Value) {
 	val := Float64(d.data[d.off:])
 	d.off += 8
 
+	if v.OverflowFloat(val) {
+		d.typeError("double "+strconv.FormatFloat(val, 'g', -1, 64), v.Type(), start)
+		return
+	}
 	v.SetFloat(val)
 }
 
@@ -718,6 +1348,67 @@ func (d *decodeState) doubleInterface() interface{} {
 	return val
 }
 
+// type(1) | name length(1) | raw name bytes | 0x00 | value bytes(8)
+func (d *decodeState) date(v reflect.Value) {
+	v.Set(reflect.ValueOf(d.dateValue()))
+}
+
+func (d *decodeState) dateInterface() interface{} {
+	return d.dateValue()
+}
+
+func (d *decodeState) dateValue() time.Time {
+	d.off++ // type
+
+	klen := int(Uint8(d.data[d.off:]))
+	d.off++ // name length
+
+	d.off += klen
+
+	val := Int64(d.data[d.off:])
+	d.off += 8
+
+	if val == math.MinInt64 {
+		return time.Time{}
+	}
+	return time.Unix(0, val).UTC()
+}
+
+// type(1) | name length(1) | content length(1) | raw name bytes | 0x00
+// | value bytes(8) | zone offset(4)
+func (d *decodeState) zonedDate(v reflect.Value) {
+	v.Set(reflect.ValueOf(d.zonedDateValue()))
+}
+
+func (d *decodeState) zonedDateInterface() interface{} {
+	return d.zonedDateValue()
+}
+
+func (d *decodeState) zonedDateValue() time.Time {
+	d.off++ // type
+
+	klen := int(Uint8(d.data[d.off:]))
+	d.off++ // name length
+
+	vlen := int(Uint8(d.data[d.off:]))
+	d.off++ // content length
+
+	d.off += klen // name and 0x00
+
+	val := Int64(d.data[d.off:])
+	offset := int(Int32(d.data[d.off+8:]))
+	d.off += vlen
+
+	if val == math.MinInt64 {
+		return time.Time{}
+	}
+	t := time.Unix(0, val)
+	if _, local := t.Zone(); local == offset {
+		return t
+	}
+	return t.In(time.FixedZone("", offset))
+}
+
 func (d *decodeState) valueInterface() interface{} {
 	switch d.data[d.off] {
 	case KSPACK_OBJECT:
@@ -754,25 +1445,24 @@ func (d *decodeState) valueInterface() interface{} {
 		return d.floatInterface()
 	case KSPACK_DOUBLE:
 		return d.doubleInterface()
+	case KSPACK_DATE:
+		return d.dateInterface()
+	case KSPACK_ZONED_DATE:
+		return d.zonedDateInterface()
 	case KSPACK_NULL:
 		return d.nullInterface()
 	}
+	d.error(&SyntaxError{Msg: fmt.Sprintf("unknown item type 0x%02x", d.data[d.off]), Offset: int64(d.off)})
 	return nil
 }
 
+// members moves past the header of the object or array item at d.off
+// and returns its member number.
+//
 // type(1) | name length(1) | item size(4) | raw name bytes | 0x00
 // | members number(4) | member1 | ... | memberN
-func (d *decodeState) object(v reflect.Value) {
-	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
-		v.Set(reflect.ValueOf(d.objectInterface()))
-		return
-	}
-
-	// make map
-	if v.Kind() == reflect.Map && v.IsNil() {
-		v.Set(reflect.MakeMap(v.Type()))
-	}
-
+func (d *decodeState) members() int {
+	start := d.off
 	d.off++ // type
 
 	klen := int(Uint8(d.data[d.off:]))
@@ -786,56 +1476,252 @@ func (d *decodeState) object(v reflect.Value) {
 	n := int(Uint32(d.data[d.off:]))
 	d.off += 4 // member number
 
-	var mapElem reflect.Value
+	if n > (len(d.data)-d.off)/minItemLen {
+		d.error(&SyntaxError{Msg: "member number overruns input", Offset: int64(start)})
+	}
+	return n
+}
+
+type structDecoder struct {
+	fields    structFields
+	fieldDecs []decoderFunc
+	inlineDec decoderFunc
+}
+
+func (sd structDecoder) decode(d *decodeState, v reflect.Value) {
+	if d.data[d.off] != KSPACK_OBJECT {
+		d.scalar(v)
+		return
+	}
+	n := d.members()
+
+	var seen []bool
+	if sd.fields.required {
+		seen = make([]bool, len(sd.fields.list))
+	}
 	for i := 0; i < n; i++ {
+		start := d.off
 		subk := d.key()
-		var subv reflect.Value
-
-		if v.Kind() == reflect.Map {
-			elemType := v.Type().Elem()
-			if !mapElem.IsValid() {
-				mapElem = reflect.New(elemType).Elem()
-			} else {
-				mapElem.Set(reflect.Zero(elemType))
-			}
-			subv = mapElem
-		} else {
-			var f *field
-			fields := cachedTypeFields(v.Type())
-			for i := range fields {
-				ff := &fields[i]
-				if bytes.Equal(ff.nameBytes, subk) {
-					f = ff
+
+		fi, ok := sd.fields.byName[string(subk)]
+		if !ok {
+			fi = -1
+			for i := range sd.fields.list {
+				if f := &sd.fields.list[i]; f.equalFold(f.nameBytes, subk) {
+					fi = i
 					break
 				}
-				if f == nil && ff.equalFold(ff.nameBytes, subk) {
-					f = ff
-				}
 			}
-			if f != nil {
-				subv = v
-				for _, i := range f.index {
-					if subv.Kind() == reflect.Ptr {
-						if subv.IsNil() {
-							subv.Set(reflect.New(subv.Type()).Elem())
-						}
-						subv = subv.Elem()
-					}
-					subv = subv.Field(i)
-				}
+		}
+		if fi < 0 {
+			if sd.fields.inlineMap == nil {
+				d.unknownField(v.Type(), subk, start)
+				d.next()
+				continue
+			}
+			mv := d.fieldValue(v, sd.fields.inlineMap.index)
+			if mv.IsNil() {
+				mv.Set(reflect.MakeMap(mv.Type()))
 			}
+			subv := reflect.New(mv.Type().Elem()).Elem()
+			d.path = append(d.path, pathElem{key: subk})
+			sd.inlineDec(d, subv)
+			d.path = d.path[:len(d.path)-1]
+			if kv, ok := d.mapKey(mv.Type().Key(), subk, start); ok {
+				d.alloc(int(kv.Type().Size() + subv.Type().Size()))
+				mv.SetMapIndex(kv, subv)
+			}
+			continue
 		}
 
-		d.value(subv)
+		f := &sd.fields.list[fi]
+		if seen != nil {
+			seen[fi] = true
+		}
+		subv := d.fieldValue(v, f.index)
+		d.path = append(d.path, pathElem{name: f.name})
+		if f.quoted && (d.data[d.off] == KSPACK_STRING || d.data[d.off] == KSPACK_SHORT_STRING) {
+			d.quoted(subv)
+		} else {
+			sd.fieldDecs[fi](d, subv)
+		}
+		d.path = d.path[:len(d.path)-1]
+	}
 
-		// Write value back to map
-		if v.Kind() == reflect.Map {
-			kv := reflect.ValueOf(subk).Convert(v.Type().Key())
-			v.SetMapIndex(kv, subv)
+	for i, ok := range seen {
+		if !ok && sd.fields.list[i].required {
+			d.saveError(&MissingFieldError{Key: sd.fields.list[i].name, Type: v.Type()})
 		}
 	}
 }
 
+// newStructDecoder binds the decoders of the fields of t. Keys are
+// matched exactly through the field name map first, and then case
+// insensitively in field order.
+func newStructDecoder(t reflect.Type, tagKey string) decoderFunc {
+	sd := structDecoder{fields: cachedTypeFields(t, tagKey)}
+	sd.fieldDecs = make([]decoderFunc, len(sd.fields.list))
+	for i, f := range sd.fields.list {
+		sd.fieldDecs[i] = typeDecoder(typeByIndex(t, f.index), tagKey)
+	}
+	if sd.fields.inlineMap != nil {
+		sd.inlineDec = typeDecoder(sd.fields.inlineMap.typ.Elem(), tagKey)
+	}
+	return sd.decode
+}
+
+type mapDecoder struct {
+	keyOK   bool
+	elemDec decoderFunc
+}
+
+func (md mapDecoder) decode(d *decodeState, v reflect.Value) {
+	if d.data[d.off] != KSPACK_OBJECT {
+		d.scalar(v)
+		return
+	}
+	if !md.keyOK {
+		d.typeError("object", v.Type(), d.off)
+		d.next()
+		return
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+	n := d.members()
+
+	elemType := v.Type().Elem()
+	mapElem := reflect.New(elemType).Elem()
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			mapElem.Set(reflect.Zero(elemType))
+		}
+		start := d.off
+		subk := d.key()
+		d.path = append(d.path, pathElem{key: subk})
+		md.elemDec(d, mapElem)
+		d.path = d.path[:len(d.path)-1]
+		if kv, ok := d.mapKey(v.Type().Key(), subk, start); ok {
+			d.alloc(int(kv.Type().Size() + elemType.Size()))
+			v.SetMapIndex(kv, mapElem)
+		}
+	}
+}
+
+// newMapDecoder binds the element decoder of the map type t. Map keys
+// must either have string or integer kind, be a bool or be an
+// encoding.TextUnmarshaler.
+func newMapDecoder(t reflect.Type, tagKey string) decoderFunc {
+	md := mapDecoder{elemDec: typeDecoder(t.Elem(), tagKey)}
+	switch t.Key().Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Bool:
+		md.keyOK = true
+	default:
+		md.keyOK = reflect.PtrTo(t.Key()).Implements(textUnmarshalerType)
+	}
+	return md.decode
+}
+
+// fieldValue returns the field of the struct v at index, allocating
+// the embedded structs it goes through when they are nil pointers.
+func (d *decodeState) fieldValue(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				d.alloc(int(v.Type().Elem().Size()))
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+// quoted decodes the string item of a field tagged ",string" into the
+// bool or number v.
+func (d *decodeState) quoted(v reflect.Value) {
+	start := d.off
+	_, _, _, pv := d.indirect(v, false)
+	s := string(d.stringBytes())
+
+	ok := false
+	switch pv.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if ok = err == nil; ok {
+			pv.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if ok = err == nil && !pv.OverflowInt(n); ok {
+			pv.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if ok = err == nil && !pv.OverflowUint(n); ok {
+			pv.SetUint(n)
+		}
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, pv.Type().Bits())
+		if ok = err == nil; ok {
+			pv.SetFloat(f)
+		}
+	}
+	if !ok {
+		d.saveError(quotedError(s, start, v.Type()))
+	}
+}
+
+// quotedError reports that the string s of the item at offset off is
+// not the text of a value of the ",string" field type t.
+func quotedError(s string, off int, t reflect.Type) error {
+	return fmt.Errorf("kspack: invalid use of ,string struct tag, trying to unmarshal %s at offset %d into %v", strconv.Quote(s), off, t)
+}
+
+// mapKey converts the object key of the member at offset off into a
+// value of the map key type kt. A key that cannot be converted is
+// recorded as an error and ok is false.
+func (d *decodeState) mapKey(kt reflect.Type, key []byte, off int) (kv reflect.Value, ok bool) {
+	if reflect.PtrTo(kt).Implements(textUnmarshalerType) {
+		kv = reflect.New(kt)
+		if err := kv.Interface().(encoding.TextUnmarshaler).UnmarshalText(key); err != nil {
+			d.saveError(err)
+			return reflect.Value{}, false
+		}
+		return kv.Elem(), true
+	}
+
+	s := string(key)
+	switch kt.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(d.text(key)).Convert(kt), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || reflect.Zero(kt).OverflowInt(n) {
+			break
+		}
+		return reflect.ValueOf(n).Convert(kt), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || reflect.Zero(kt).OverflowUint(n) {
+			break
+		}
+		return reflect.ValueOf(n).Convert(kt), true
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			break
+		}
+		return reflect.ValueOf(b).Convert(kt), true
+	}
+	d.typeError("key "+strconv.Quote(s), kt, off)
+	return reflect.Value{}, false
+}
+
 func (d *decodeState) objectInterface() map[string]interface{} {
 	d.off++ // type
 
@@ -853,32 +1739,29 @@ func (d *decodeState) objectInterface() map[string]interface{} {
 	m := make(map[string]interface{})
 	for i := 0; i < n; i++ {
 		subk := d.key()
-		m[string(subk)] = d.valueInterface()
+		d.alloc(int(stringType.Size() + interfaceType.Size()))
+		m[d.text(subk)] = d.valueInterface()
 	}
 
 	return m
 }
 
-// FIXME: fix when v is invalid
+type arrayDecoder struct {
+	elemDec decoderFunc
+}
+
 // type(1) | name length(1) | item size(4) | raw name bytes | 0x00
 // | element number(4) | element1 | ... | elementN
-func (d *decodeState) array(v reflect.Value) {
-	d.off++ // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off++ // name length
-
-	// vlen := int(Uint32(d.data[d.off:]))
-	d.off += 4 //  content length
-
-	// var key string
-	d.off += klen
-
-	n := int(Uint32(d.data[d.off:]))
-	d.off += 4 // member number
+func (ad arrayDecoder) decode(d *decodeState, v reflect.Value) {
+	if d.data[d.off] != KSPACK_ARRAY {
+		d.scalar(v)
+		return
+	}
+	n := d.members()
 
 	if v.Kind() == reflect.Slice {
 		if n > v.Cap() {
+			d.alloc(n * int(v.Type().Elem().Size()))
 			newv := reflect.MakeSlice(v.Type(), n, n)
 			v.Set(newv)
 		}
@@ -887,9 +1770,11 @@ func (d *decodeState) array(v reflect.Value) {
 
 	for i := 0; i < n; i++ {
 		if i < v.Len() {
-			d.value(v.Index(i))
+			d.path = append(d.path, pathElem{index: i})
+			ad.elemDec(d, v.Index(i))
+			d.path = d.path[:len(d.path)-1]
 		} else {
-			d.value(reflect.Value{})
+			d.next()
 		}
 	}
 
@@ -907,7 +1792,14 @@ func (d *decodeState) array(v reflect.Value) {
 	}
 }
 
+// newArrayDecoder binds the element decoder of the slice or array type t.
+func newArrayDecoder(t reflect.Type, tagKey string) decoderFunc {
+	ad := arrayDecoder{elemDec: typeDecoder(t.Elem(), tagKey)}
+	return ad.decode
+}
+
 func (d *decodeState) arrayInterface() []interface{} {
+	start := d.off
 	d.off++ // type
 
 	klen := int(Uint8(d.data[d.off:]))
@@ -922,6 +1814,11 @@ func (d *decodeState) arrayInterface() []interface{} {
 	n := int(Uint32(d.data[d.off:]))
 	d.off += 4 // member number
 
+	if n > (len(d.data)-d.off)/minItemLen {
+		d.error(&SyntaxError{Msg: "member number overruns input", Offset: int64(start)})
+	}
+
+	d.alloc(n * int(interfaceType.Size()))
 	v := make([]interface{}, n)
 	for i := 0; i < n; i++ {
 		v[i] = d.valueInterface()
@@ -930,17 +1827,7 @@ func (d *decodeState) arrayInterface() []interface{} {
 }
 
 func (d *decodeState) key() []byte {
-	var kstart int
-	switch d.data[d.off] {
-	case KSPACK_INT8, KSPACK_INT16, KSPACK_INT32, KSPACK_INT64,
-		KSPACK_UINT8, KSPACK_UINT16, KSPACK_UINT32, KSPACK_UINT64,
-		KSPACK_BOOL, KSPACK_FLOAT, KSPACK_DOUBLE, KSPACK_NULL:
-		kstart = 2 // type + klen
-	case KSPACK_SHORT_BINARY, KSPACK_SHORT_STRING:
-		kstart = 3 // type + klen + vlen(1)
-	case KSPACK_BINARY, KSPACK_STRING, KSPACK_OBJECT, KSPACK_ARRAY:
-		kstart = 6 // type + klen + vlen(4)
-	}
+	kstart := itemHeaderLen(d.data[d.off])
 	klen := int(Uint8(d.data[d.off+1:]))
 	if klen <= 0 {
 		d.error(errEmptyKey)
@@ -948,6 +1835,57 @@ func (d *decodeState) key() []byte {
 	return d.data[d.off+kstart : d.off+kstart+klen-1]
 }
 
+// An UnknownFieldError describes an object key that matches no field
+// of the struct it is decoded into.
+type UnknownFieldError struct {
+	Key    string       // object key
+	Type   reflect.Type // struct type
+	Offset int64        // offset of the member item in the input
+}
+
+func (e *UnknownFieldError) Error() string {
+	return "kspack: unknown field " + strconv.Quote(e.Key) + " in " + e.Type.String()
+}
+
+// A LimitError is returned when decoding exceeds one of the limits set
+// in DecodeOptions.
+type LimitError struct {
+	Limit  string // name of the DecodeOptions field, e.g. "MaxDepth"
+	Max    int    // value of the limit
+	Offset int64  // offset of the offending item in the input
+}
+
+func (e *LimitError) Error() string {
+	return "kspack: item at offset " + strconv.FormatInt(e.Offset, 10) + " exceeds " + e.Limit + " of " + strconv.Itoa(e.Max)
+}
+
+// An UnmarshalTypeError describes a kspack item that was not
+// appropriate for the Go value it was decoded into.
+type UnmarshalTypeError struct {
+	Value  string       // description of the item, e.g. "string" or "int64 300"
+	Type   reflect.Type // type of Go value it could not be assigned to
+	Offset int64        // offset of the item in the input
+	Field  string       // full path to the Go value, e.g. "Order.Items[3].Price"
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("kspack: cannot unmarshal %s at offset %d into Go struct field %s of type %v", e.Value, e.Offset, e.Field, e.Type)
+	}
+	return fmt.Sprintf("kspack: cannot unmarshal %s at offset %d into Go value of type %v", e.Value, e.Offset, e.Type)
+}
+
+// A MissingFieldError describes a struct field tagged ",required" whose
+// key is absent from the object decoded into the struct.
+type MissingFieldError struct {
+	Key  string       // object key of the field
+	Type reflect.Type // struct type
+}
+
+func (e *MissingFieldError) Error() string {
+	return "kspack: missing required field " + strconv.Quote(e.Key) + " in " + e.Type.String()
+}
+
 type InvalidUnmarshalError struct {
 	Type reflect.Type
 }
@@ -17,7 +17,15 @@ limitations under the License.
 package pack
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -108,3 +116,494 @@ func TestDecodeEncodeNil(t *testing.T) {
 	assert.Equal("dongjiang", p.Name)
 	assert.Equal((*int)(nil), p.P)
 }
+
+type event struct {
+	Name string
+	At   time.Time
+	Seen *time.Time
+	Any  interface{}
+}
+
+func TestDecodeEncodeTime(t *testing.T) {
+	assert := assert.New(t)
+	at := time.Date(2017, 7, 7, 9, 0, 0, 123, time.UTC)
+	seen := time.Date(2023, 2, 12, 18, 30, 0, 0, time.FixedZone("CST", 8*3600))
+	in := event{Name: "release", At: at, Seen: &seen, Any: at}
+
+	data, err := Marshal(in)
+	assert.NoError(err)
+	assert.True(bytes.Contains(data, []byte{KSPACK_DATE, 3, 'A', 't', 0}))
+	assert.True(bytes.Contains(data, []byte{KSPACK_ZONED_DATE, 5, 12, 'S', 'e', 'e', 'n', 0}))
+
+	out := event{}
+	assert.NoError(Unmarshal(data, &out))
+	assert.Equal(in.Name, out.Name)
+	assert.Equal(at, out.At)
+	assert.True(seen.Equal(*out.Seen))
+	_, offset := out.Seen.Zone()
+	assert.Equal(8*3600, offset)
+	assert.Equal(at, out.Any)
+
+	var m map[string]interface{}
+	assert.NoError(Unmarshal(data, &m))
+	assert.Equal(at, m["At"])
+	assert.True(seen.Equal(m["Seen"].(time.Time)))
+
+	local := time.Date(2017, 7, 7, 9, 0, 0, 0, time.Local)
+	data, err = Marshal(local)
+	assert.NoError(err)
+	var lt time.Time
+	assert.NoError(Unmarshal(data, &lt))
+	assert.True(local.Equal(lt))
+	assert.Equal(local.Format(time.RFC3339), lt.Format(time.RFC3339))
+
+	data, err = Marshal(time.Time{})
+	assert.NoError(err)
+	assert.Equal([]byte{KSPACK_DATE, 0, 0, 0, 0, 0, 0, 0, 0, 0x80}, data)
+	lt = time.Now()
+	assert.NoError(Unmarshal(data, &lt))
+	assert.True(lt.IsZero())
+
+	_, err = Marshal(time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Error(err)
+}
+
+type digest [4]byte
+
+func (h digest) MarshalBinary() ([]byte, error) {
+	return h[:], nil
+}
+
+func (h *digest) UnmarshalBinary(b []byte) error {
+	if len(b) != len(h) {
+		return errors.New("bad digest length")
+	}
+	copy(h[:], b)
+	return nil
+}
+
+type badText struct{}
+
+func (badText) MarshalText() ([]byte, error) {
+	return nil, errors.New("no text")
+}
+
+type host struct {
+	IP     net.IP
+	Mask   *net.IP
+	Amount big.Int
+	Limit  *big.Int
+	Sum    digest
+	Sums   []digest
+	When   *time.Time
+}
+
+func TestDecodeEncodeEncodingMarshaler(t *testing.T) {
+	assert := assert.New(t)
+	mask := net.ParseIP("255.255.255.0")
+	in := &host{
+		IP:    net.ParseIP("10.0.0.1"),
+		Mask:  &mask,
+		Limit: big.NewInt(-42),
+		Sum:   digest{1, 2, 3, 4},
+		Sums:  []digest{{5, 6, 7, 8}},
+	}
+	in.Amount.SetString("123456789012345678901234567890", 10)
+
+	data, err := Marshal(in)
+	assert.NoError(err)
+	assert.True(bytes.Contains(data, []byte{KSPACK_SHORT_STRING, 3, 9, 'I', 'P', 0, '1', '0', '.', '0', '.', '0', '.', '1', 0}))
+	assert.True(bytes.Contains(data, []byte{KSPACK_SHORT_BINARY, 4, 4, 'S', 'u', 'm', 0, 1, 2, 3, 4}))
+	assert.True(bytes.Contains(data, []byte{KSPACK_NULL, 5, 'W', 'h', 'e', 'n', 0, 0}))
+
+	out := &host{}
+	assert.NoError(Unmarshal(data, out))
+	assert.Equal(in.IP.String(), out.IP.String())
+	assert.Equal(in.Mask.String(), out.Mask.String())
+	assert.Equal(0, in.Amount.Cmp(&out.Amount))
+	assert.Equal(0, in.Limit.Cmp(out.Limit))
+	assert.Equal(in.Sum, out.Sum)
+	assert.Equal(in.Sums, out.Sums)
+	assert.Nil(out.When)
+
+	// a binary item is still decoded into the underlying []byte
+	var ip net.IP
+	assert.NoError(Unmarshal([]byte{KSPACK_SHORT_BINARY, 0, 4, 10, 0, 0, 2}, &ip))
+	assert.Equal("10.0.0.2", ip.String())
+
+	var sum digest
+	assert.Error(Unmarshal([]byte{KSPACK_SHORT_BINARY, 0, 1, 10}, &sum))
+
+	_, err = Marshal(badText{})
+	assert.EqualError(err, "kspack: error calling MarshalText for type pack.badText: no text")
+}
+
+type coord struct{ X, Y int }
+
+func (c coord) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d:%d", c.X, c.Y)), nil
+}
+
+func (c *coord) UnmarshalText(b []byte) error {
+	_, err := fmt.Sscanf(string(b), "%d:%d", &c.X, &c.Y)
+	return err
+}
+
+type tables struct {
+	Ints   map[int64]string
+	Uints  map[uint8]int
+	Flags  map[bool]string
+	Coords map[coord]bool
+}
+
+func TestDecodeEncodeMapKeys(t *testing.T) {
+	assert := assert.New(t)
+	in := tables{
+		Ints:   map[int64]string{-7: "neg", 0: "zero", 1 << 40: "big"},
+		Uints:  map[uint8]int{255: 1},
+		Flags:  map[bool]string{true: "yes", false: "no"},
+		Coords: map[coord]bool{{1, 2}: true, {-3, 4}: false},
+	}
+	data, err := Marshal(in)
+	assert.NoError(err)
+	assert.True(bytes.Contains(data, []byte{KSPACK_SHORT_STRING, 3, 4, '-', '7', 0, 'n', 'e', 'g', 0}))
+	assert.True(bytes.Contains(data, []byte{KSPACK_BOOL, 4, '1', ':', '2', 0, 1}))
+
+	out := tables{}
+	assert.NoError(Unmarshal(data, &out))
+	assert.Equal(in, out)
+
+	var m map[string]interface{}
+	assert.NoError(Unmarshal(data, &m))
+	assert.Equal("big", m["Ints"].(map[string]interface{})["1099511627776"])
+
+	canonical, err := MarshalCanonical(map[int]int{10: 1, 9: 2})
+	assert.NoError(err)
+	assert.True(bytes.Index(canonical, []byte("10")) < bytes.Index(canonical, []byte("9")))
+
+	var small map[int8]int
+	ints, err := MarshalCanonical(map[int]int{300: 1, 2: 2})
+	assert.NoError(err)
+	err = Unmarshal(ints, &small)
+	assert.EqualError(err, `kspack: cannot unmarshal key "300" at offset 22 into Go value of type int8`)
+	assert.Equal(map[int8]int{2: 2}, small)
+
+	var bad map[[2]int]int
+	assert.Error(Unmarshal(ints, &bad))
+
+	// keys without text are not written under an empty key
+	type keyed struct {
+		Hosts map[*big.Int]int
+		Addrs map[*net.IP]bool
+	}
+	for _, tt := range []struct {
+		in  keyed
+		err string
+	}{
+		{keyed{Hosts: map[*big.Int]int{nil: 1}}, "kspack: unsupported value nil map key of type *big.Int at Hosts"},
+		{keyed{Hosts: map[*big.Int]int{big.NewInt(1): 1, big.NewInt(2): 2, nil: 3}}, "kspack: unsupported value nil map key of type *big.Int at Hosts"},
+		{keyed{Addrs: map[*net.IP]bool{new(net.IP): true}}, "kspack: unsupported value empty map key of type *net.IP at Addrs"},
+	} {
+		_, err := Marshal(tt.in)
+		if assert.IsType(&UnsupportedValueError{}, err) {
+			assert.EqualError(err, tt.err)
+		}
+		_, err = Size(tt.in)
+		assert.EqualError(err, tt.err)
+	}
+}
+
+type account struct {
+	ID       int    `json:"id" kspack:"i"`
+	Owner    string `json:"owner" kspack:"o,omitempty"`
+	Password string `json:"-" kspack:"p"`
+	Internal string `json:"internal" kspack:"-"`
+	Balance  int    `json:"balance" msgpack:"bal"`
+	Plain    string
+}
+
+func TestDecodeEncodeTagKey(t *testing.T) {
+	assert := assert.New(t)
+	in := account{ID: 1, Password: "secret", Internal: "x", Balance: 10, Plain: "p"}
+
+	data, err := Marshal(in)
+	assert.NoError(err)
+	var m map[string]interface{}
+	assert.NoError(Unmarshal(data, &m))
+	assert.Equal(map[string]interface{}{"i": int64(1), "p": "secret", "balance": int64(10), "Plain": "p"}, m)
+
+	var out account
+	assert.NoError(Unmarshal(data, &out))
+	assert.Equal(account{ID: 1, Password: "secret", Balance: 10, Plain: "p"}, out)
+
+	msgpack := EncodeOptions{TagKey: "msgpack"}
+	data, err = msgpack.Marshal(in)
+	assert.NoError(err)
+	m = nil
+	assert.NoError(Unmarshal(data, &m))
+	assert.Equal(map[string]interface{}{"id": int64(1), "owner": "", "internal": "x", "bal": int64(10), "Plain": "p"}, m)
+
+	out = account{}
+	assert.NoError(DecodeOptions{TagKey: "msgpack"}.Unmarshal(data, &out))
+	assert.Equal(account{ID: 1, Internal: "x", Balance: 10, Plain: "p"}, out)
+
+	// the same type is cached separately for each tag key
+	data, err = EncodeOptions{TagKey: "json"}.Marshal(in)
+	assert.NoError(err)
+	m = nil
+	assert.NoError(Unmarshal(data, &m))
+	assert.Equal(map[string]interface{}{"id": int64(1), "owner": "", "internal": "x", "balance": int64(10), "Plain": "p"}, m)
+}
+
+type money struct {
+	Units int64
+	Nanos int32
+}
+
+func (m money) IsZero() bool { return m.Units == 0 && m.Nanos == 0 }
+
+type audit struct {
+	By string
+	At time.Time `kspack:",omitzero"`
+}
+
+type lineOrder struct {
+	ID      int64             `kspack:"id,string,required"`
+	Price   float64           `kspack:"price,string"`
+	Paid    *bool             `kspack:"paid,string"`
+	Total   money             `kspack:"total,omitzero"`
+	Dims    [2]int            `kspack:"dims,omitzero"`
+	Audit   audit             `kspack:",inline"`
+	Extra   map[string]string `kspack:",inline"`
+	Comment string            `kspack:"comment,required"`
+}
+
+func TestDecodeEncodeTagOptions(t *testing.T) {
+	assert := assert.New(t)
+	paid := true
+	in := lineOrder{
+		ID:      1 << 53,
+		Price:   9.99,
+		Paid:    &paid,
+		Audit:   audit{By: "ops"},
+		Extra:   map[string]string{"region": "eu", "aa": "first"},
+		Comment: "",
+	}
+
+	data, err := Marshal(in)
+	assert.NoError(err)
+	assert.NoError(checkValid(data))
+	assert.True(bytes.Contains(data, []byte("id\x009007199254740992\x00")))
+	assert.True(bytes.Contains(data, []byte("price\x009.99\x00")))
+	assert.True(bytes.Contains(data, []byte("paid\x00true\x00")))
+
+	var m map[string]interface{}
+	assert.NoError(Unmarshal(data, &m))
+	assert.Equal(map[string]interface{}{
+		"id": "9007199254740992", "price": "9.99", "paid": "true",
+		"By": "ops", "region": "eu", "aa": "first", "comment": "",
+	}, m)
+
+	out := lineOrder{}
+	assert.NoError(Unmarshal(data, &out))
+	assert.Equal(in, out)
+
+	// inline map members are merged with the fields in canonical order
+	canonical, err := MarshalCanonical(in)
+	assert.NoError(err)
+	assert.True(bytes.Index(canonical, []byte("By\x00")) < bytes.Index(canonical, []byte("aa\x00")))
+	assert.True(bytes.Index(canonical, []byte("aa\x00")) < bytes.Index(canonical, []byte("comment\x00")))
+	assert.True(bytes.Index(canonical, []byte("price\x00")) < bytes.Index(canonical, []byte("region\x00")))
+	out = lineOrder{}
+	assert.NoError(Unmarshal(canonical, &out))
+	assert.Equal(in, out)
+
+	in.Total = money{Units: 1}
+	in.Dims = [2]int{0, 1}
+	in.Audit.At = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	data, err = Marshal(in)
+	assert.NoError(err)
+	out = lineOrder{}
+	assert.NoError(Unmarshal(data, &out))
+	assert.Equal(in, out)
+
+	// native numbers are accepted for ,string fields
+	data, err = Marshal(map[string]interface{}{"id": 7, "price": 1.5, "comment": "c"})
+	assert.NoError(err)
+	out = lineOrder{}
+	assert.NoError(Unmarshal(data, &out))
+	assert.Equal(lineOrder{ID: 7, Price: 1.5, Comment: "c"}, out)
+
+	data, err = MarshalCanonical(map[string]interface{}{"id": "x7", "comment": "c"})
+	assert.NoError(err)
+	assert.EqualError(Unmarshal(data, &out), `kspack: invalid use of ,string struct tag, trying to unmarshal "x7" at offset 23 into int64`)
+
+	data, err = Marshal(map[string]interface{}{"comment": "c"})
+	assert.NoError(err)
+	err = Unmarshal(data, &out)
+	var mfe *MissingFieldError
+	assert.True(errors.As(err, &mfe))
+	assert.EqualError(err, `kspack: missing required field "id" in pack.lineOrder`)
+
+	// inline struct pointers are allocated when decoding
+	var ref struct {
+		Audit *audit `kspack:",inline"`
+	}
+	assert.NoError(Unmarshal(data, &ref))
+	assert.Nil(ref.Audit)
+	data, err = Marshal(map[string]string{"By": "ops"})
+	assert.NoError(err)
+	assert.NoError(Unmarshal(data, &ref))
+	assert.Equal("ops", ref.Audit.By)
+
+	in.Extra = map[string]string{"price": "dup"}
+	_, err = Marshal(in)
+	assert.EqualError(err, `kspack: unsupported value inline map key "price" duplicates a field at Extra`)
+}
+
+type orderItem struct {
+	SKU   string
+	Qty   int8
+	Price float32
+}
+
+type itemOrder struct {
+	Items []orderItem
+	Notes map[string]uint16
+}
+
+func TestDecodeEncodeTypeError(t *testing.T) {
+	assert := assert.New(t)
+
+	in := map[string]interface{}{
+		"Items": []interface{}{
+			map[string]interface{}{"SKU": "a", "Qty": 1},
+			map[string]interface{}{"SKU": "b", "Qty": 300},
+		},
+	}
+	data, err := MarshalCanonical(in)
+	assert.NoError(err)
+	var out itemOrder
+	err = Unmarshal(data, &out)
+	var te *UnmarshalTypeError
+	assert.True(errors.As(err, &te))
+	assert.Equal("int64 300", te.Value)
+	assert.Equal("itemOrder.Items[1].Qty", te.Field)
+	assert.Equal(reflect.TypeOf(int8(0)), te.Type)
+	assert.Equal(byte(KSPACK_INT64), data[te.Offset])
+	assert.EqualError(err, fmt.Sprintf("kspack: cannot unmarshal int64 300 at offset %d into Go struct field itemOrder.Items[1].Qty of type int8", te.Offset))
+	assert.Equal("a", out.Items[0].SKU)
+	assert.Equal("b", out.Items[1].SKU)
+
+	in = map[string]interface{}{"Items": []interface{}{map[string]interface{}{"Price": "cheap"}}}
+	data, err = Marshal(in)
+	assert.NoError(err)
+	err = Unmarshal(data, &out)
+	assert.True(errors.As(err, &te))
+	assert.Equal("itemOrder.Items[0].Price", te.Field)
+	assert.Equal("string", te.Value)
+	assert.EqualError(err, fmt.Sprintf("kspack: cannot unmarshal string at offset %d into Go struct field itemOrder.Items[0].Price of type float32", te.Offset))
+
+	// the root is named through pointers, and only if it is a struct
+	pout := &out
+	err = Unmarshal(data, &pout)
+	assert.True(errors.As(err, &te))
+	assert.Equal("itemOrder.Items[0].Price", te.Field)
+	var m map[string]itemOrder
+	data, err = Marshal(map[string]interface{}{"o": in})
+	assert.NoError(err)
+	err = Unmarshal(data, &m)
+	assert.True(errors.As(err, &te))
+	assert.Equal("o.Items[0].Price", te.Field)
+	assert.EqualError(err, fmt.Sprintf("kspack: cannot unmarshal string at offset %d into Go struct field o.Items[0].Price of type float32", te.Offset))
+
+	data, err = Marshal(map[string]interface{}{"Notes": map[string]interface{}{"x": -1}})
+	assert.NoError(err)
+	err = Unmarshal(data, &out)
+	assert.True(errors.As(err, &te))
+	assert.Equal("itemOrder.Notes.x", te.Field)
+
+	data, err = Marshal(1e300)
+	assert.NoError(err)
+	var f float32
+	err = Unmarshal(data, &f)
+	assert.True(errors.As(err, &te))
+	assert.Equal("double 1e+300", te.Value)
+	assert.Equal("", te.Field)
+}
+
+func TestDecodeEncodeLenient(t *testing.T) {
+	assert := assert.New(t)
+	lenient := DecodeOptions{Lenient: true}
+
+	in := map[string]interface{}{
+		"Items": []interface{}{
+			map[string]interface{}{"SKU": 42, "Qty": "7", "Price": 3},
+			map[string]interface{}{"SKU": 1.5, "Qty": 2.0, "Price": "0.25"},
+		},
+		"Notes": map[string]interface{}{"a": int64(9), "b": "10"},
+	}
+	data, err := Marshal(in)
+	assert.NoError(err)
+
+	var out itemOrder
+	assert.Error(Unmarshal(data, &out))
+	out = itemOrder{}
+	assert.NoError(lenient.Unmarshal(data, &out))
+	assert.Equal(itemOrder{
+		Items: []orderItem{{SKU: "42", Qty: 7, Price: 3}, {SKU: "1.5", Qty: 2, Price: 0.25}},
+		Notes: map[string]uint16{"a": 9, "b": 10},
+	}, out)
+
+	// values that do not survive the conversion are still rejected
+	for _, v := range []interface{}{300, "x", 2.5, uint64(1 << 63)} {
+		data, err = Marshal(v)
+		assert.NoError(err)
+		var i int8
+		var te *UnmarshalTypeError
+		assert.True(errors.As(lenient.Unmarshal(data, &i), &te), "%v", v)
+	}
+	data, err = Marshal(-1)
+	assert.NoError(err)
+	var u uint
+	assert.Error(lenient.Unmarshal(data, &u))
+
+	// integers convert to floats only if exactly representable
+	var f32 float32
+	var f64 float64
+	for _, tt := range []struct {
+		v   interface{}
+		ok  bool
+		dst interface{}
+	}{
+		{int64(1 << 24), true, &f32},
+		{int64(1<<24 + 1), false, &f32},
+		{int32(-1<<24 - 1), false, &f32},
+		{uint64(1<<24 + 1), false, &f32},
+		{int64(1 << 53), true, &f64},
+		{int64(1<<53 + 1), false, &f64},
+		{int64(math.MaxInt64), false, &f64},
+		{int64(math.MinInt64), true, &f64},
+		{uint64(math.MaxUint64), false, &f64},
+		{uint64(1 << 63), true, &f64},
+	} {
+		data, err = Marshal(tt.v)
+		assert.NoError(err)
+		err = lenient.Unmarshal(data, tt.dst)
+		if tt.ok {
+			assert.NoError(err, "%v", tt.v)
+			continue
+		}
+		if assert.IsType(&UnmarshalTypeError{}, err, "%v", tt.v) {
+			assert.Equal(fmt.Sprintf("%s %v", typeName(data[0]), tt.v), err.(*UnmarshalTypeError).Value)
+		}
+	}
+	assert.Equal(float32(1<<24), f32)
+	assert.Equal(float64(1<<63), f64)
+
+	// items of other kinds are not converted
+	data, err = Marshal(true)
+	assert.NoError(err)
+	var s string
+	assert.Error(lenient.Unmarshal(data, &s))
+}
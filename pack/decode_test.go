@@ -19,6 +19,7 @@ package pack
 import (
 	"bytes"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -389,3 +390,231 @@ func TestDecodeState(t *testing.T) {
 	hh := h.unmarshal([]byte{})
 	assert.Error(hh)
 }
+
+func TestDecodeSyntaxError(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		in     []byte
+		msg    string
+		offset int64
+	}{
+		{in: []byte{}, msg: "unexpected end of input", offset: 0},
+		{in: []byte{KSPACK_INT32, 0, 1, 2}, msg: "item length overruns its parent", offset: 0},
+		{in: []byte{KSPACK_STRING, 0, 9}, msg: "unexpected end of input", offset: 0},
+		{in: []byte{KSPACK_SHORT_STRING, 0, 3, 'a', 'b'}, msg: "item length overruns its parent", offset: 0},
+		{in: []byte{KSPACK_SHORT_STRING, 0, 2, 'a', 'b'}, msg: "unterminated string", offset: 0},
+		{in: []byte{0x99, 0, 0}, msg: "unknown item type 0x99", offset: 0},
+		{in: []byte{KSPACK_INT8, 0, 1, 2}, msg: "1 trailing bytes after item", offset: 3},
+		{in: []byte{
+			KSPACK_ARRAY, 0, 9, 0, 0, 0, 1, 0, 0, 0,
+			KSPACK_INT32, 0, 1, 2, 3, 4,
+		}, msg: "item length overruns its parent", offset: 10},
+		{in: []byte{
+			KSPACK_OBJECT, 0, 0, 0, 0, 0, 2, 0, 0, 0,
+			KSPACK_INT8, 2, 'a', 0, 1,
+			0x77, 2, 'b', 0, 1,
+		}, msg: "unknown item type 0x77", offset: 15},
+		{in: []byte{
+			KSPACK_ARRAY, 0, 9, 0, 0, 0, 1, 0, 0, 0,
+			KSPACK_INT8, 0, 1, 0, 0,
+		}, msg: "content length mismatch", offset: 0},
+	}
+	for _, tt := range tests {
+		var v interface{}
+		err := Unmarshal(tt.in, &v)
+		se, ok := err.(*SyntaxError)
+		if assert.True(ok, "%v: %v", tt.in, err) {
+			assert.Equal(tt.msg, se.Msg)
+			assert.Equal(tt.offset, se.Offset)
+		}
+	}
+
+	deep := make([]byte, 0, (maxNestingDepth+1)*10)
+	for i := 0; i <= maxNestingDepth; i++ {
+		deep = append(deep, KSPACK_ARRAY, 0, 0, 0, 0, 0, 1, 0, 0, 0)
+	}
+	var v interface{}
+	assert.EqualError(Unmarshal(deep, &v), "kspack: exceeded max depth at offset 100000")
+
+	var n int
+	err := Unmarshal([]byte{KSPACK_SHORT_STRING, 0, 2, 'a', 0}, &n)
+	assert.EqualError(err, "kspack: cannot unmarshal string at offset 0 into Go value of type int")
+}
+
+func TestDecodeOptionsLimits(t *testing.T) {
+	assert := assert.New(t)
+	type node struct {
+		Name  string
+		Data  []byte
+		Items []int64
+		Next  *node
+	}
+	in := node{Name: "root", Data: make([]byte, 64), Items: []int64{1, 2, 3, 4}, Next: &node{Name: "leaf", Data: []byte{}, Items: []int64{}}}
+	data, err := Marshal(in)
+	assert.NoError(err)
+
+	limits := DecodeOptions{MaxDepth: 3, MaxElements: 4, MaxStringLen: 4, MaxBinaryLen: 64, MaxTotalAllocation: 1 << 10}
+	var out node
+	assert.NoError(limits.Unmarshal(data, &out))
+	assert.Equal(in, out)
+
+	tests := []struct {
+		opts  DecodeOptions
+		limit string
+	}{
+		{DecodeOptions{MaxDepth: 2}, "MaxDepth"},
+		{DecodeOptions{MaxElements: 3}, "MaxElements"},
+		{DecodeOptions{MaxStringLen: 3}, "MaxStringLen"},
+		{DecodeOptions{MaxBinaryLen: 63}, "MaxBinaryLen"},
+		{DecodeOptions{MaxTotalAllocation: 100}, "MaxTotalAllocation"},
+	}
+	for _, tt := range tests {
+		var out node
+		err := tt.opts.Unmarshal(data, &out)
+		le, ok := err.(*LimitError)
+		if assert.True(ok, "%s: %v", tt.limit, err) {
+			assert.Equal(tt.limit, le.Limit)
+		}
+	}
+
+	// an array claiming more members than the input can hold fails
+	// before anything is allocated
+	huge := []byte{KSPACK_ARRAY, 0, 7, 0, 0, 0, 0xff, 0xff, 0xff, 0x7f, KSPACK_INT8, 0, 1}
+	var ints []int
+	err = Unmarshal(huge, &ints)
+	assert.EqualError(err, "kspack: member number overruns its parent at offset 0")
+
+	d := decodeState{opts: DecodeOptions{MaxTotalAllocation: 8}}
+	d.init([]byte{KSPACK_ARRAY, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0x7f, KSPACK_INT8, 0, 1})
+	assert.PanicsWithError("kspack: member number overruns input at offset 0", func() { d.value(reflect.ValueOf(&ints).Elem()) })
+
+	dec := NewDecoder(bytes.NewReader(data))
+	dec.SetOptions(DecodeOptions{MaxStringLen: 2})
+	err = dec.Decode(&out)
+	assert.EqualError(err, "kspack: item at offset 10 exceeds MaxStringLen of 2")
+}
+
+func TestDecodeOptionsUnknownFields(t *testing.T) {
+	assert := assert.New(t)
+	data, err := Marshal(&customerV2{Name: "n", Email: "e", Tier: 2, Inner: obj{Foo: "f"}})
+	assert.NoError(err)
+
+	var unknown []UnknownFieldError
+	var v customerV1
+	assert.NoError(DecodeOptions{UnknownFields: &unknown}.Unmarshal(data, &v))
+	assert.Equal(customerV1{Name: "n", Inner: obj{Foo: "f"}}, v)
+	if assert.Len(unknown, 2) {
+		assert.Equal(UnknownFieldError{Key: "Email", Type: reflect.TypeOf(v), Offset: 20}, unknown[0])
+		assert.Equal("Tier", unknown[1].Key)
+	}
+
+	// further calls append
+	assert.NoError(DecodeOptions{UnknownFields: &unknown}.Unmarshal(data, &v))
+	assert.Len(unknown, 4)
+
+	// DisallowUnknownFields takes precedence
+	unknown = nil
+	err = DecodeOptions{DisallowUnknownFields: true, UnknownFields: &unknown}.Unmarshal(data, &v)
+	assert.EqualError(err, `kspack: unknown field "Email" in pack.customerV1`)
+	assert.Empty(unknown)
+
+	var m map[string]interface{}
+	assert.NoError(DecodeOptions{UnknownFields: &unknown}.Unmarshal(data, &m))
+	assert.Empty(unknown)
+}
+
+type category struct {
+	Name  string
+	Sub   []category
+	Attrs map[string]*category
+	Meta  map[string]interface{} `kspack:",inline"`
+}
+
+func TestDecodeCompiledTypes(t *testing.T) {
+	assert := assert.New(t)
+
+	in := category{
+		Name: "root",
+		Sub:  []category{{Name: "a", Sub: []category{{Name: "aa"}}}},
+		Attrs: map[string]*category{
+			"b": {Name: "b", Meta: map[string]interface{}{"x": int64(1)}},
+		},
+	}
+	data, err := Marshal(in)
+	assert.NoError(err)
+	for i := 0; i < 2; i++ {
+		var out category
+		assert.NoError(Unmarshal(data, &out))
+		assert.Equal("aa", out.Sub[0].Sub[0].Name)
+		assert.Equal(int64(1), out.Attrs["b"].Meta["x"])
+	}
+
+	// keys fall back to case-insensitive matching after the field map
+	data, err = MarshalCanonical(map[string]interface{}{"NAME": "n", "name": "m"})
+	assert.NoError(err)
+	var out category
+	assert.NoError(Unmarshal(data, &out))
+	assert.Equal("m", out.Name)
+
+	data, err = Marshal(map[string]interface{}{"nAmE": "n", "other": true})
+	assert.NoError(err)
+	out = category{}
+	assert.NoError(Unmarshal(data, &out))
+	assert.Equal("n", out.Name)
+	assert.Equal(map[string]interface{}{"other": true}, out.Meta)
+}
+
+type blobDoc struct {
+	Name string
+	Data []byte
+	Tags map[string]string
+	Raw  RawMessage
+	Any  interface{}
+}
+
+func TestDecodeZeroCopy(t *testing.T) {
+	assert := assert.New(t)
+
+	in := blobDoc{
+		Name: "n",
+		Data: bytes.Repeat([]byte{7}, 300),
+		Tags: map[string]string{"k": strings.Repeat("v", 300)},
+		Raw:  RawMessage{KSPACK_INT8, 0, 5},
+		Any:  "s",
+	}
+	data, err := Marshal(in)
+	assert.NoError(err)
+
+	var copied, aliased blobDoc
+	assert.NoError(Unmarshal(data, &copied))
+	assert.NoError(DecodeOptions{ZeroCopy: true}.Unmarshal(data, &aliased))
+	assert.Equal(in.Data, aliased.Data)
+	assert.Equal(in.Tags, aliased.Tags)
+	assert.Equal(in.Any, aliased.Any)
+	assert.Equal([]byte{KSPACK_INT8, 4, 'R', 'a', 'w', 0, 5}, []byte(aliased.Raw))
+
+	within := func(b []byte) bool {
+		return len(b) > 0 && &b[0] == &data[bytes.Index(data, b)]
+	}
+	assert.True(within(aliased.Data))
+	assert.True(within(aliased.Raw))
+	assert.False(within(copied.Data))
+	assert.Equal(len(aliased.Data), cap(aliased.Data), "appends must not write into the input")
+
+	// aliased values see changes to the input, copies do not
+	for i := range data {
+		if data[i] == 'v' {
+			data[i] = 'w'
+		}
+	}
+	assert.Equal(strings.Repeat("w", 300), aliased.Tags["k"])
+	assert.Equal(strings.Repeat("v", 300), copied.Tags["k"])
+
+	// aliased bytes are not charged as allocations
+	opts := DecodeOptions{MaxTotalAllocation: 512}
+	var out blobDoc
+	assert.Error(opts.Unmarshal(data, &out))
+	opts.ZeroCopy = true
+	out = blobDoc{}
+	assert.NoError(opts.Unmarshal(data, &out))
+}
@@ -17,27 +17,283 @@ limitations under the License.
 package pack
 
 import (
+	"encoding"
 	"fmt"
+	"math"
 	"reflect"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 )
 
+// Marshaler is the interface implemented by types that can marshal
+// themselves into a single valid kspack item. The key the item carries
+// is ignored; it is written under the name of the field, map entry or
+// array element it belongs to.
+type Marshaler interface {
+	MarshalKSPACK() ([]byte, error)
+}
+
+// MarshalerError represents an error from calling a MarshalKSPACK,
+// MarshalText or MarshalBinary method, or an invalid item returned by
+// MarshalKSPACK.
+type MarshalerError struct {
+	Type       reflect.Type
+	Err        error
+	sourceFunc string
+}
+
+func (e *MarshalerError) Error() string {
+	srcFunc := e.sourceFunc
+	if srcFunc == "" {
+		srcFunc = "MarshalKSPACK"
+	}
+	return "kspack: error calling " + srcFunc + " for type " + e.Type.String() + ": " + e.Err.Error()
+}
+
+func (e *MarshalerError) Unwrap() error { return e.Err }
+
+// An UnsupportedTypeError is returned by Marshal when attempting to
+// encode a value of a type that has no kspack representation, such as
+// a channel, a function or a complex number.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+	Path string // location of the value, e.g. "Items[3].Price"; empty at the top level
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "kspack: unsupported type " + e.Type.String() + atPath(e.Path)
+}
+
+// An UnsupportedValueError is returned by Marshal when attempting to
+// encode a value that its type supports but kspack cannot represent.
+type UnsupportedValueError struct {
+	Value reflect.Value
+	Str   string
+	Path  string // location of the value, e.g. "Items[3].Price"; empty at the top level
+
+	cycle interface{} // identity of the value met twice, if a cycle
+	loop  string      // path from that value back to itself, once known
+}
+
+func (e *UnsupportedValueError) Error() string {
+	return "kspack: unsupported value " + e.Str + atPath(e.Path)
+}
+
+func atPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	return " at " + path
+}
+
+// addPath prefixes the path of an unsupported type or value error
+// raised while encoding the element elem of a container, and panics
+// with r again. Other panics are propagated as is.
+func addPath(r interface{}, elem string) {
+	switch err := r.(type) {
+	case *UnsupportedTypeError:
+		err.Path = joinPath(elem, err.Path)
+	case *UnsupportedValueError:
+		err.at(elem)
+	}
+	panic(r)
+}
+
+// at prefixes the path of e with elem.
+func (e *UnsupportedValueError) at(elem string) {
+	e.Path = joinPath(elem, e.Path)
+	if e.loop != "" && e.Path == e.loop {
+		// back at an earlier turn of the cycle
+		e.Path = ""
+	}
+}
+
+// closeCycle records the path of the cycle e reports once unwinding
+// from ptr, the value it starts from.
+func (e *UnsupportedValueError) closeCycle(ptr interface{}) {
+	if e.cycle == ptr && e.loop == "" {
+		e.loop = e.Path
+		e.Str += " through " + e.loop
+		e.Path = ""
+	}
+}
+
+func joinPath(elem, path string) string {
+	if path == "" || path[0] == '[' {
+		return elem + path
+	}
+	return elem + "." + path
+}
+
 func Marshal(v interface{}) ([]byte, error) {
-	e := &encodeState{}
+	return EncodeOptions{}.Marshal(v)
+}
+
+// EncodeOptions configures how Go values are encoded. The zero value
+// gives the behavior of Marshal.
+type EncodeOptions struct {
+	// Canonical produces the canonical encoding of a value: object
+	// members sorted bytewise by key, the shortest form for strings
+	// and binaries, and normalized floats, so that equal values always
+	// encode to identical bytes.
+	Canonical bool
+
+	// TagKey is the struct tag key read for field names and options,
+	// such as "msgpack" or "bson". Fields without it fall back to their
+	// json tag. It defaults to DefaultTagKey.
+	TagKey string
+
+	// SkipUnsupported leaves out struct fields, map entries and array
+	// elements whose type cannot be encoded, instead of failing with an
+	// *UnsupportedTypeError. The member count of the enclosing object
+	// or array only covers the items actually written. A top-level
+	// value of an unsupported type is still an error.
+	SkipUnsupported bool
+}
+
+// Marshal returns the kspack encoding of v according to o.
+func (o EncodeOptions) Marshal(v interface{}) ([]byte, error) {
+	e := newEncodeState()
+	defer putEncodeState(e)
+
+	e.setOptions(o)
+	if err := e.marshal(v); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), e.data[:e.off]...), nil
+}
+
+// MarshalAppend appends the kspack encoding of v to dst and returns the
+// extended buffer. On error dst is returned unchanged. A dst with enough
+// spare capacity is written to without allocating.
+func MarshalAppend(dst []byte, v interface{}) ([]byte, error) {
+	return EncodeOptions{}.MarshalAppend(dst, v)
+}
+
+// MarshalAppend is like the MarshalAppend function according to o.
+func (o EncodeOptions) MarshalAppend(dst []byte, v interface{}) ([]byte, error) {
+	e := newEncodeState()
+	// encode in place, leaving the pooled buffer aside, to be put back
+	// even if v panics
+	buf := e.data
+	defer func() {
+		e.data = buf
+		putEncodeState(e)
+	}()
+
+	e.data, e.off = dst[:cap(dst)], len(dst)
+	e.setOptions(o)
 	err := e.marshal(v)
+	out := e.data[:e.off]
 	if err != nil {
-		return nil, err
+		return dst, err
 	}
-	return e.data[:e.off], nil
+	return out, nil
 }
 
 type encodeState struct {
 	data []byte
 	off  int
+
+	canonical       bool
+	skipUnsupported bool
+	tagKey          string
+
+	// Keep track of what pointers we've seen in the current recursive
+	// call path, in order to reject cycles. Tracking only starts past
+	// a nesting level that acyclic values seldom reach.
+	ptrLevel uint
+	ptrSeen  map[interface{}]struct{}
+}
+
+var encodeStatePool sync.Pool
+
+// newEncodeState returns an empty encodeState from the pool, keeping the
+// buffer of its previous use.
+func newEncodeState() *encodeState {
+	if v := encodeStatePool.Get(); v != nil {
+		e := v.(*encodeState)
+		e.reset()
+		return e
+	}
+	return new(encodeState)
+}
+
+// maxPooledBufferSize bounds the buffers kept along with the states put
+// back into encodeStatePool, so that a single large value does not pin
+// its buffer for good.
+const maxPooledBufferSize = 64 << 10
+
+// putEncodeState puts e back into the pool, dropping its buffer if it
+// exceeds maxPooledBufferSize.
+func putEncodeState(e *encodeState) {
+	if cap(e.data) > maxPooledBufferSize {
+		e.data = nil
+	}
+	encodeStatePool.Put(e)
+}
+
+// reset empties e for a new value, keeping its buffer.
+func (e *encodeState) reset() {
+	e.off = 0
+	e.ptrLevel = 0
+	if len(e.ptrSeen) > 0 {
+		e.ptrSeen = nil
+	}
+}
+
+// startDetectingCyclesAfter is the nesting level of pointers, maps and
+// slices past which encoders start tracking the values they visit.
+const startDetectingCyclesAfter = 1000
+
+// enter records that the encoder is within the value v identified by
+// ptr, and panics with an *UnsupportedValueError if it already was.
+// Callers only track the values nested past startDetectingCyclesAfter,
+// so that ptr is boxed only then, and must defer e.leave(ptr).
+func (e *encodeState) enter(ptr interface{}, v reflect.Value) {
+	if _, ok := e.ptrSeen[ptr]; ok {
+		panic(cycleError(ptr, v))
+	}
+	e.track(ptr)
+}
+
+func (e *encodeState) track(ptr interface{}) {
+	if e.ptrSeen == nil {
+		e.ptrSeen = make(map[interface{}]struct{})
+	}
+	e.ptrSeen[ptr] = struct{}{}
+}
+
+// cycleError returns the error of the value v identified by ptr met
+// again within itself.
+func cycleError(ptr interface{}, v reflect.Value) *UnsupportedValueError {
+	return &UnsupportedValueError{Value: v, Str: "encountered a cycle via " + v.Type().String(), cycle: ptr}
+}
+
+// leave undoes enter for a tracked ptr. When unwinding from the cycle
+// that ptr starts, it records the path of the cycle in the error.
+func (e *encodeState) leave(ptr interface{}) {
+	delete(e.ptrSeen, ptr)
+	if r := recover(); r != nil {
+		if err, ok := r.(*UnsupportedValueError); ok {
+			err.closeCycle(ptr)
+		}
+		panic(r)
+	}
+}
+
+func (e *encodeState) setOptions(o EncodeOptions) {
+	e.canonical = o.Canonical
+	e.skipUnsupported = o.SkipUnsupported
+	e.tagKey = o.TagKey
+	if e.tagKey == "" {
+		e.tagKey = DefaultTagKey
+	}
 }
 
 func max(l, r int) int {
@@ -79,7 +335,7 @@ func (e *encodeState) resizeIfNeeded(n int) {
 	if e.off+n >= cap(e.data) {
 		newcap := max(cap(e.data)*2, e.off+n)
 		newdata := make([]byte, newcap)
-		copy(newdata, e.data)
+		copy(newdata, e.data[:e.off])
 		e.data = newdata
 	}
 }
@@ -96,57 +352,115 @@ func (e *encodeState) marshal(v interface{}) (err error) {
 			err = r.(error)
 		}
 	}()
+	start := e.off
 	e.reflectValue("", reflect.ValueOf(v))
+	if e.off == start {
+		// only a skipped unsupported value writes nothing
+		return &UnsupportedTypeError{Type: reflect.TypeOf(v)}
+	}
 	return nil
 }
 
 func (e *encodeState) reflectValue(k string, v reflect.Value) {
-	valueEncoder(v)(e, k, v)
+	valueEncoder(v, e.tagKey)(e, k, v)
 }
 
 type encoderFunc func(e *encodeState, k string, v reflect.Value)
 
-var encoderCache struct {
-	sync.RWMutex
-	m map[reflect.Type]encoderFunc
+// DefaultTagKey is the struct tag key read for field names and options
+// when none is set in EncodeOptions or DecodeOptions. Fields without
+// such a tag fall back to their json tag.
+const DefaultTagKey = "kspack"
+
+// A cacheKey identifies the struct fields, encoder or decoder built for
+// a type when reading field tags under tagKey.
+type cacheKey struct {
+	t      reflect.Type
+	tagKey string
 }
 
-func valueEncoder(v reflect.Value) encoderFunc {
+var encoderCache sync.Map // map[cacheKey]encoderFunc
+
+func valueEncoder(v reflect.Value, tagKey string) encoderFunc {
 	if !v.IsValid() {
 		return invalidValueEncoder
 	}
-	return typeEncoder(v.Type())
+	return typeEncoder(v.Type(), tagKey)
 }
 
-func typeEncoder(t reflect.Type) encoderFunc {
-	encoderCache.RLock()
-	f := encoderCache.m[t]
-	encoderCache.RUnlock()
-	if f != nil {
-		return f
+func typeEncoder(t reflect.Type, tagKey string) encoderFunc {
+	key := cacheKey{t, tagKey}
+	if fi, ok := encoderCache.Load(key); ok {
+		return fi.(encoderFunc)
 	}
 
-	encoderCache.Lock()
-	if encoderCache.m == nil {
-		encoderCache.m = make(map[reflect.Type]encoderFunc)
-	}
-	var wg sync.WaitGroup
+	// To deal with recursive types, populate the map with an
+	// indirect func before we build it. This type waits on the
+	// real func (f) to be ready and then calls it. This indirect
+	// func is only used for recursive types.
+	var (
+		wg sync.WaitGroup
+		f  encoderFunc
+	)
 	wg.Add(1)
-	encoderCache.m[t] = func(e *encodeState, k string, v reflect.Value) {
+	fi, loaded := encoderCache.LoadOrStore(key, encoderFunc(func(e *encodeState, k string, v reflect.Value) {
 		wg.Wait()
 		f(e, k, v)
+	}))
+	if loaded {
+		return fi.(encoderFunc)
 	}
-	encoderCache.Unlock()
 
-	f = newTypeEncoder(t, true)
+	// Compute the real encoder and replace the indirect func with it.
+	f = newTypeEncoder(t, tagKey, true)
 	wg.Done()
-	encoderCache.Lock()
-	encoderCache.m[t] = f
-	encoderCache.Unlock()
+	encoderCache.Store(key, f)
 	return f
 }
 
-func newTypeEncoder(t reflect.Type, allowAddr bool) encoderFunc {
+var (
+	marshalerType       = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+func newTypeEncoder(t reflect.Type, tagKey string, allowAddr bool) encoderFunc {
+	// generated types before their MarshalKSPACK method, which calls
+	// Marshal
+	if t.Kind() == reflect.Ptr && t.Implements(generatedEncoderType) {
+		return generatedPtrEncoder
+	}
+	if t.Kind() == reflect.Struct && reflect.PtrTo(t).Implements(generatedEncoderType) {
+		return generatedValueEncoder
+	}
+	if t.Implements(marshalerType) {
+		return marshalerEncoder
+	}
+	if t.Kind() != reflect.Ptr && allowAddr && reflect.PtrTo(t).Implements(marshalerType) {
+		return newCondAddrEncoder(addrMarshalerEncoder, newTypeEncoder(t, tagKey, false))
+	}
+	if t == timeType {
+		return timeEncoder
+	}
+	if t.Kind() == reflect.Ptr && t.Elem() == timeType {
+		// *time.Time would otherwise be caught by the promoted
+		// MarshalText/MarshalBinary methods below.
+		return newPtrEncoder(t, tagKey)
+	}
+	if t.Implements(textMarshalerType) {
+		return textMarshalerEncoder
+	}
+	if t.Kind() != reflect.Ptr && allowAddr && reflect.PtrTo(t).Implements(textMarshalerType) {
+		return newCondAddrEncoder(addrTextMarshalerEncoder, newTypeEncoder(t, tagKey, false))
+	}
+	if t.Implements(binaryMarshalerType) {
+		return binaryMarshalerEncoder
+	}
+	if t.Kind() != reflect.Ptr && allowAddr && reflect.PtrTo(t).Implements(binaryMarshalerType) {
+		return newCondAddrEncoder(addrBinaryMarshalerEncoder, newTypeEncoder(t, tagKey, false))
+	}
+
 	switch t.Kind() {
 	case reflect.Bool:
 		return boolEncoder
@@ -181,24 +495,31 @@ func newTypeEncoder(t reflect.Type, allowAddr bool) encoderFunc {
 	case reflect.Interface:
 		return interfaceEncoder
 	case reflect.Struct:
-		return newStructEncoder(t)
+		return newStructEncoder(t, tagKey)
 	case reflect.Map:
-		return newMapEncoder(t)
+		return newMapEncoder(t, tagKey)
 	case reflect.Slice:
-		return newSliceEncoder(t)
+		return newSliceEncoder(t, tagKey)
 	case reflect.Array:
-		return newArrayEncoder(t)
+		return newArrayEncoder(t, tagKey)
 	case reflect.Ptr:
-		return newPtrEncoder(t)
+		return newPtrEncoder(t, tagKey)
 	default:
 		return unsupportedTypeEncoder
 	}
 }
 
 func unsupportedTypeEncoder(e *encodeState, k string, v reflect.Value) {
+	if e.skipUnsupported {
+		return
+	}
+	panic(&UnsupportedTypeError{Type: v.Type()})
 }
 
+// invalidValueEncoder encodes the zero reflect.Value, as passed for a
+// nil interface{}, as NULL.
 func invalidValueEncoder(e *encodeState, k string, v reflect.Value) {
+	nilEncoder(e, k, v)
 }
 
 func nilEncoder(e *encodeState, k string, v reflect.Value) {
@@ -211,13 +532,131 @@ func nilEncoder(e *encodeState, k string, v reflect.Value) {
 	e.off++
 }
 
+func marshalerEncoder(e *encodeState, k string, v reflect.Value) {
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		nilEncoder(e, k, v)
+		return
+	}
+	m := v.Interface().(Marshaler)
+	e.marshaler(k, v.Type(), m)
+}
+
+func addrMarshalerEncoder(e *encodeState, k string, v reflect.Value) {
+	va := v.Addr()
+	if va.IsNil() {
+		nilEncoder(e, k, v)
+		return
+	}
+	m := va.Interface().(Marshaler)
+	e.marshaler(k, va.Type(), m)
+}
+
+func (e *encodeState) marshaler(k string, t reflect.Type, m Marshaler) {
+	b, err := m.MarshalKSPACK()
+	if err == nil {
+		err = checkValid(b)
+	}
+	if err != nil {
+		panic(&MarshalerError{Type: t, Err: err, sourceFunc: "MarshalKSPACK"})
+	}
+	e.rawItem(k, b)
+}
+
+func textMarshalerEncoder(e *encodeState, k string, v reflect.Value) {
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		nilEncoder(e, k, v)
+		return
+	}
+	m := v.Interface().(encoding.TextMarshaler)
+	e.textMarshaler(k, v.Type(), m)
+}
+
+func addrTextMarshalerEncoder(e *encodeState, k string, v reflect.Value) {
+	va := v.Addr()
+	if va.IsNil() {
+		nilEncoder(e, k, v)
+		return
+	}
+	m := va.Interface().(encoding.TextMarshaler)
+	e.textMarshaler(k, va.Type(), m)
+}
+
+func (e *encodeState) textMarshaler(k string, t reflect.Type, m encoding.TextMarshaler) {
+	b, err := m.MarshalText()
+	if err != nil {
+		panic(&MarshalerError{Type: t, Err: err, sourceFunc: "MarshalText"})
+	}
+	e.string(k, string(b))
+}
+
+func binaryMarshalerEncoder(e *encodeState, k string, v reflect.Value) {
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		nilEncoder(e, k, v)
+		return
+	}
+	m := v.Interface().(encoding.BinaryMarshaler)
+	e.binaryMarshaler(k, v.Type(), m)
+}
+
+func addrBinaryMarshalerEncoder(e *encodeState, k string, v reflect.Value) {
+	va := v.Addr()
+	if va.IsNil() {
+		nilEncoder(e, k, v)
+		return
+	}
+	m := va.Interface().(encoding.BinaryMarshaler)
+	e.binaryMarshaler(k, va.Type(), m)
+}
+
+func (e *encodeState) binaryMarshaler(k string, t reflect.Type, m encoding.BinaryMarshaler) {
+	b, err := m.MarshalBinary()
+	if err != nil {
+		panic(&MarshalerError{Type: t, Err: err, sourceFunc: "MarshalBinary"})
+	}
+	e.binary(k, b)
+}
+
+// item writes the well-formed item b under the key k, whatever key b
+// was encoded with.
+func (e *encodeState) item(k string, b []byte) {
+	h := itemHeaderLen(b[0])
+	klen := int(b[1])
+
+	e.resizeIfNeeded(len(b) + len(k) + 1)
+	// type(1)
+	e.setType(b[0])
+	// klen(1)
+	l := e.setKeyLen(k)
+	// vlen(0/1/4)
+	e.off += copy(e.data[e.off:], b[2:h])
+	// key(k[:l]) | 0x00
+	e.setKey(k, l)
+	// value
+	e.off += copy(e.data[e.off:], b[h+klen:])
+}
+
+// rawItem writes the well-formed item b under the key k, rewriting it
+// into its canonical form if required.
+func (e *encodeState) rawItem(k string, b []byte) {
+	if e.canonical {
+		e.canonicalItem(k, b)
+		return
+	}
+	e.item(k, b)
+}
+
 func boolEncoder(e *encodeState, k string, v reflect.Value) {
+	e.bool(k, v.Bool())
+}
+
+// type(1) | name length(1) | raw name bytes | 0x00 | 0x00/0x01
+func (e *encodeState) bool(k string, b bool) {
 	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 1)
 
 	e.setType(KSPACK_BOOL)
 	e.setKey(k, e.setKeyLen(k))
 
-	if v.Bool() {
+	if b {
 		e.data[e.off] = 1
 	} else {
 		e.data[e.off] = 0
@@ -225,113 +664,235 @@ func boolEncoder(e *encodeState, k string, v reflect.Value) {
 	e.off++
 }
 
-// type(1) | name length(1) | raw name bytes | 0x00 | value bytes
 func int8Encoder(e *encodeState, k string, v reflect.Value) {
+	e.int8(k, int8(v.Int()))
+}
+
+// type(1) | name length(1) | raw name bytes | 0x00 | value bytes
+func (e *encodeState) int8(k string, n int8) {
 	// unsupported in libkspack, uint32 employed
 	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
 	e.setType(KSPACK_INT8)
 	e.setKey(k, e.setKeyLen(k))
-	PutInt8(e.data[e.off:], int8(v.Int()))
+	PutInt8(e.data[e.off:], n)
 	e.off++
 }
 
-// type(1) | name length(1) | raw name bytes | 0x00 | value bytes
 func int16Encoder(e *encodeState, k string, v reflect.Value) {
+	e.int16(k, int16(v.Int()))
+}
+
+// type(1) | name length(1) | raw name bytes | 0x00 | value bytes
+func (e *encodeState) int16(k string, n int16) {
 	// unsupported in libkspack, int32 employed
 	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
 	e.setType(KSPACK_INT16)
 	e.setKey(k, e.setKeyLen(k))
-	PutInt16(e.data[e.off:], int16(v.Int()))
+	PutInt16(e.data[e.off:], n)
 	e.off += 2
 }
 
-// type(1) | name length(1) | raw name bytes | 0x00 | value bytes
 func int32Encoder(e *encodeState, k string, v reflect.Value) {
+	e.int32(k, int32(v.Int()))
+}
+
+// type(1) | name length(1) | raw name bytes | 0x00 | value bytes
+func (e *encodeState) int32(k string, n int32) {
 	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
 
 	e.setType(KSPACK_INT32)
 	e.setKey(k, e.setKeyLen(k))
 
-	PutInt32(e.data[e.off:], int32(v.Int()))
+	PutInt32(e.data[e.off:], n)
 	e.off += 4
 }
 
-// type(1) | name length(1) | raw name bytes | 0x00 | value bytes
 func int64Encoder(e *encodeState, k string, v reflect.Value) {
+	e.int64(k, v.Int())
+}
+
+// type(1) | name length(1) | raw name bytes | 0x00 | value bytes
+func (e *encodeState) int64(k string, n int64) {
 	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
 
 	e.setType(KSPACK_INT64)
 	e.setKey(k, e.setKeyLen(k))
 
-	PutInt64(e.data[e.off:], v.Int())
+	PutInt64(e.data[e.off:], n)
 	e.off += 8
 }
 
 func uint8Encoder(e *encodeState, k string, v reflect.Value) {
+	e.uint8(k, uint8(v.Uint()))
+}
+
+func (e *encodeState) uint8(k string, n uint8) {
 	// unsupported in libkspack, uint32 employed
 	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
 	e.setType(KSPACK_UINT8)
 	e.setKey(k, e.setKeyLen(k))
-	PutUint8(e.data[e.off:], uint8(v.Uint()))
+	PutUint8(e.data[e.off:], n)
 	e.off++
 }
 
 func uint16Encoder(e *encodeState, k string, v reflect.Value) {
+	e.uint16(k, uint16(v.Uint()))
+}
+
+func (e *encodeState) uint16(k string, n uint16) {
 	// unsupported in libkspack, uint32 employed
 	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
 	e.setType(KSPACK_UINT16)
 	e.setKey(k, e.setKeyLen(k))
-	PutUint16(e.data[e.off:], uint16(v.Uint()))
+	PutUint16(e.data[e.off:], n)
 	e.off += 2
 }
 
 func uint32Encoder(e *encodeState, k string, v reflect.Value) {
+	e.uint32(k, uint32(v.Uint()))
+}
+
+func (e *encodeState) uint32(k string, n uint32) {
 	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
 
 	e.setType(KSPACK_UINT32)
 	e.setKey(k, e.setKeyLen(k))
 
-	PutUint32(e.data[e.off:], uint32(v.Uint()))
+	PutUint32(e.data[e.off:], n)
 	e.off += 4
 }
 
 func uint64Encoder(e *encodeState, k string, v reflect.Value) {
+	e.uint64(k, v.Uint())
+}
+
+func (e *encodeState) uint64(k string, n uint64) {
 	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
 
 	e.setType(KSPACK_UINT64)
 	e.setKey(k, e.setKeyLen(k))
 
-	PutUint64(e.data[e.off:], v.Uint())
+	PutUint64(e.data[e.off:], n)
 	e.off += 8
 }
 
 func float32Encoder(e *encodeState, k string, v reflect.Value) {
+	e.float32(k, float32(v.Float()))
+}
+
+func (e *encodeState) float32(k string, f float32) {
 	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 4)
 
 	e.setType(KSPACK_FLOAT)
 	e.setKey(k, e.setKeyLen(k))
 
-	PutFloat32(e.data[e.off:], float32(v.Float()))
+	if e.canonical {
+		f = float32(canonicalFloat(float64(f)))
+	}
+	PutFloat32(e.data[e.off:], f)
 	e.off += 4
 }
 
 func float64Encoder(e *encodeState, k string, v reflect.Value) {
+	e.float64(k, v.Float())
+}
+
+func (e *encodeState) float64(k string, f float64) {
 	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
 
 	e.setType(KSPACK_DOUBLE)
 	e.setKey(k, e.setKeyLen(k))
 
-	PutFloat64(e.data[e.off:], v.Float())
+	if e.canonical {
+		f = canonicalFloat(f)
+	}
+	PutFloat64(e.data[e.off:], f)
+	e.off += 8
+}
+
+var (
+	minDate = time.Unix(0, math.MinInt64+1)
+	maxDate = time.Unix(0, math.MaxInt64)
+)
+
+func timeEncoder(e *encodeState, k string, v reflect.Value) {
+	var t time.Time
+	if v.CanAddr() {
+		// going through the pointer does not box a copy
+		t = *v.Addr().Interface().(*time.Time)
+	} else {
+		t = v.Interface().(time.Time)
+	}
+	if !e.time(k, t) {
+		panic(dateRangeError(v, t))
+	}
+}
+
+// dateRangeError reports the time t of the value v, out of DATE range.
+func dateRangeError(v reflect.Value, t time.Time) error {
+	return &UnsupportedValueError{Value: v, Str: "time " + t.String() + " out of DATE range"}
+}
+
+// time writes t as a DATE item if it is in UTC, else as a ZONED_DATE
+// item. It writes nothing and reports false if t is out of DATE range.
+func (e *encodeState) time(k string, t time.Time) bool {
+	nsec, ok := dateNanos(t)
+	if !ok {
+		return false
+	}
+	if t.Location() == time.UTC {
+		e.date(k, nsec)
+	} else {
+		_, offset := t.Zone()
+		e.zonedDate(k, nsec, int32(offset))
+	}
+	return true
+}
+
+// dateNanos returns the DATE value of t, or false if t is out of range.
+func dateNanos(t time.Time) (int64, bool) {
+	if t.IsZero() {
+		return math.MinInt64, true
+	}
+	if t.Before(minDate) || t.After(maxDate) {
+		return 0, false
+	}
+	return t.UnixNano(), true
+}
+
+// type(1) | klen(1) | key(len(k)) | 0x00 | value(8)
+func (e *encodeState) date(k string, nsec int64) {
+	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
+	e.setType(KSPACK_DATE)
+	e.setKey(k, e.setKeyLen(k))
+	PutInt64(e.data[e.off:], nsec)
 	e.off += 8
 }
 
+// type(1) | klen(1) | vlen(1) | key(len(k)) | 0x00 | value(8) | offset(4)
+func (e *encodeState) zonedDate(k string, nsec int64, offset int32) {
+	e.resizeIfNeeded(1 + 1 + 1 + len(k) + 1 + 8 + 4)
+	e.setType(KSPACK_ZONED_DATE)
+	l := e.setKeyLen(k)
+	PutUint8(e.data[e.off:], 8+4)
+	e.off++
+	e.setKey(k, l)
+	PutInt64(e.data[e.off:], nsec)
+	PutInt32(e.data[e.off+8:], offset)
+	e.off += 8 + 4
+}
+
 func stringEncoder(e *encodeState, k string, v reflect.Value) {
+	e.string(k, v.String())
+}
+
+func (e *encodeState) string(k string, s string) {
 	// type(1) | klen(1) | vlen(4) | key(len(k)) | 0x00 | value | 0x00
 	// max(short_vitem, long_vitem)
-	e.resizeIfNeeded(1 + 1 + 4 + len(k) + 1 + v.Len() + 1)
+	e.resizeIfNeeded(1 + 1 + 4 + len(k) + 1 + len(s) + 1)
 
-	vlen := len(v.String()) + 1
-	if vlen < MAX_SHORT_VITEM_LEN {
+	vlen := len(s) + 1
+	if vlen < MAX_SHORT_VITEM_LEN || e.canonical && vlen == MAX_SHORT_VITEM_LEN {
 		// type(1) | klen(1) | vlen(1) | key(len(k)) | 0x00 | value | 0x00
 		// type(1)
 		e.setType(KSPACK_SHORT_STRING)
@@ -356,17 +917,21 @@ func stringEncoder(e *encodeState, k string, v reflect.Value) {
 	}
 
 	// value | 0x00
-	e.off += copy(e.data[e.off:], v.String())
+	e.off += copy(e.data[e.off:], s)
 	e.data[e.off] = 0
 	e.off++
 }
 
 func binaryEncoder(e *encodeState, k string, v reflect.Value) {
+	e.binary(k, v.Bytes())
+}
+
+func (e *encodeState) binary(k string, b []byte) {
 	// type(1) | klen(1) | vlen(4) | key(len(k)) | 0x00 | value
 	// max(short_vitem, long_vitem)
-	e.resizeIfNeeded(1 + 1 + 4 + len(k) + 1 + v.Len())
+	e.resizeIfNeeded(1 + 1 + 4 + len(k) + 1 + len(b))
 
-	vlen := len(v.Bytes())
+	vlen := len(b)
 	if vlen <= MAX_SHORT_VITEM_LEN {
 		// type(1) | klen(1) | vlen(1) | key(len(k)) | 0x00 | value
 		// type(1)
@@ -391,7 +956,7 @@ func binaryEncoder(e *encodeState, k string, v reflect.Value) {
 		e.setKey(k, l)
 	}
 	// value
-	e.off += copy(e.data[e.off:], v.Bytes())
+	e.off += copy(e.data[e.off:], b)
 }
 
 func interfaceEncoder(e *encodeState, k string, v reflect.Value) {
@@ -403,8 +968,12 @@ func interfaceEncoder(e *encodeState, k string, v reflect.Value) {
 }
 
 type structEncoder struct {
-	fields    []field
+	fields    structFields
 	fieldEncs []encoderFunc
+	// byName lists field indexes in canonical member order.
+	byName []int
+	// inlineEnc encodes the values of the inline map, if any.
+	inlineEnc encoderFunc
 }
 
 func (se *structEncoder) encode(e *encodeState, k string, v reflect.Value) {
@@ -421,38 +990,133 @@ func (se *structEncoder) encode(e *encodeState, k string, v reflect.Value) {
 	e.setKey(k, l)
 	// vpos defer
 	vpos := e.off
-	// count(4)
-	PutInt32(e.data[e.off:], int32(len(se.fields)))
+	// count defer
 	e.off += 4
 	// elem
-	for i, f := range se.fields {
+	n := 0
+	var name string
+	defer func() {
+		if r := recover(); r != nil {
+			addPath(r, name)
+		}
+	}()
+
+	// Members of the inline map are written after the fields, or
+	// merged with them in canonical order.
+	var mv reflect.Value
+	var keys []string
+	if im := se.fields.inlineMap; im != nil {
+		name = im.name
+		if mv = fieldByIndex(v, im.index); mv.IsValid() && mv.Len() > 0 {
+			keys = make([]string, 0, mv.Len())
+			for _, mk := range mv.MapKeys() {
+				if _, ok := se.fields.byName[mk.String()]; ok {
+					panic(&UnsupportedValueError{Value: mv, Str: "inline map key " + strconv.Quote(mk.String()) + " duplicates a field"})
+				}
+				keys = append(keys, mk.String())
+			}
+			if e.canonical {
+				sort.Strings(keys)
+			}
+		}
+	}
+	inline := func(key string) {
+		name = key
+		off := e.off
+		se.inlineEnc(e, key, mv.MapIndex(reflect.ValueOf(key).Convert(mv.Type().Key())))
+		if e.off != off {
+			n++
+		}
+	}
+
+	for j := range se.fields.list {
+		i := j
+		if e.canonical {
+			i = se.byName[j]
+		}
+		f := &se.fields.list[i]
+		for e.canonical && len(keys) > 0 && keys[0] < f.name {
+			inline(keys[0])
+			keys = keys[1:]
+		}
+		name = f.name
 		fv := fieldByIndex(v, f.index)
-		if !fv.IsValid() || f.omitEmpty && isEmptyValue(fv) {
+		if !fv.IsValid() || f.omitEmpty && isEmptyValue(fv) || f.omitZero && f.isZero(fv) {
 			continue
 		}
+		off := e.off
 		se.fieldEncs[i](e, f.name, fv)
+		if e.off != off {
+			n++
+		}
+	}
+	for _, key := range keys {
+		inline(key)
 	}
+	// count
+	PutInt32(e.data[vpos:], int32(n))
 	// vlen
 	PutInt32(e.data[vlenpos:], int32(e.off-vpos))
 }
 
-func newStructEncoder(t reflect.Type) encoderFunc {
-	fields := cachedTypeFields(t)
+func newStructEncoder(t reflect.Type, tagKey string) encoderFunc {
+	fields := cachedTypeFields(t, tagKey)
 	se := &structEncoder{
 		fields:    fields,
-		fieldEncs: make([]encoderFunc, len(fields)),
+		fieldEncs: make([]encoderFunc, len(fields.list)),
+		byName:    make([]int, len(fields.list)),
 	}
-	for i, f := range fields {
-		se.fieldEncs[i] = typeEncoder(typeByIndex(t, f.index))
+	for i, f := range fields.list {
+		if f.quoted {
+			se.fieldEncs[i] = quotedEncoder
+		} else {
+			se.fieldEncs[i] = typeEncoder(typeByIndex(t, f.index), tagKey)
+		}
+		se.byName[i] = i
+	}
+	sort.SliceStable(se.byName, func(i, j int) bool {
+		return fields.list[se.byName[i]].name < fields.list[se.byName[j]].name
+	})
+	if fields.inlineMap != nil {
+		se.inlineEnc = typeEncoder(fields.inlineMap.typ.Elem(), tagKey)
 	}
 	return se.encode
 }
 
+// quotedEncoder encodes a bool or number field tagged ",string" as a
+// string item holding its text form.
+func quotedEncoder(e *encodeState, k string, v reflect.Value) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			nilEncoder(e, k, v)
+			return
+		}
+		v = v.Elem()
+	}
+	var s string
+	switch v.Kind() {
+	case reflect.Bool:
+		s = strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		s = strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		s = strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		s = strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits())
+	}
+	e.string(k, s)
+}
+
 type mapEncoder struct {
 	elemEnc encoderFunc
 }
 
 func (me *mapEncoder) encode(e *encodeState, k string, v reflect.Value) {
+	if e.ptrLevel++; e.ptrLevel > startDetectingCyclesAfter {
+		ptr := v.Pointer()
+		e.enter(ptr, v)
+		defer e.leave(ptr)
+	}
 	e.resizeIfNeeded(1 + 1 + 4 + len(k) + 1 + 4)
 	// type(1)
 	e.setType(KSPACK_OBJECT)
@@ -465,37 +1129,120 @@ func (me *mapEncoder) encode(e *encodeState, k string, v reflect.Value) {
 	e.setKey(k, l)
 	// vpos defer
 	vpos := e.off
-	PutInt32(e.data[e.off:], int32(len(v.MapKeys())))
+	// count defer
 	e.off += 4
 
-	for _, k := range v.MapKeys() {
-		me.elemEnc(e, k.String(), v.MapIndex(k))
+	keys := v.MapKeys()
+	sv := make([]reflectWithString, len(keys))
+	for i, mk := range keys {
+		sv[i].v = mk
+		sv[i].resolve()
+	}
+	if e.canonical {
+		sort.Slice(sv, func(i, j int) bool { return sv[i].s < sv[j].s })
+	}
+	n := 0
+	var name string
+	defer func() {
+		if r := recover(); r != nil {
+			addPath(r, name)
+		}
+	}()
+	for _, kv := range sv {
+		name = kv.s
+		off := e.off
+		me.elemEnc(e, kv.s, v.MapIndex(kv.v))
+		if e.off != off {
+			n++
+		}
 	}
+	// count
+	PutInt32(e.data[vpos:], int32(n))
 	// vlen
 	PutInt32(e.data[vlenpos:], int32(e.off-vpos))
+	e.ptrLevel--
 }
 
-func newMapEncoder(t reflect.Type) encoderFunc {
-	if t.Key().Kind() != reflect.String {
-		return unsupportedTypeEncoder
+func newMapEncoder(t reflect.Type, tagKey string) encoderFunc {
+	switch t.Key().Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Bool:
+	default:
+		if !t.Key().Implements(textMarshalerType) {
+			return unsupportedTypeEncoder
+		}
 	}
-	me := &mapEncoder{typeEncoder(t.Elem())}
+	me := &mapEncoder{typeEncoder(t.Elem(), tagKey)}
 	return me.encode
 }
 
+// reflectWithString pairs a map key with the object key it is written
+// under.
+type reflectWithString struct {
+	v reflect.Value
+	s string
+}
+
+// resolve sets w.s from w.v. String keys are used as is, other keys go
+// through MarshalText or are formatted in base 10. A nil pointer key, or
+// one whose text is empty, has no object key to be written under and
+// panics with an *UnsupportedValueError.
+func (w *reflectWithString) resolve() {
+	if w.v.Kind() == reflect.String {
+		w.s = w.v.String()
+		return
+	}
+	if tm, ok := w.v.Interface().(encoding.TextMarshaler); ok {
+		if w.v.Kind() == reflect.Ptr && w.v.IsNil() {
+			panic(&UnsupportedValueError{Value: w.v, Str: "nil map key of type " + w.v.Type().String()})
+		}
+		buf, err := tm.MarshalText()
+		if err != nil {
+			panic(&MarshalerError{Type: w.v.Type(), Err: err, sourceFunc: "MarshalText"})
+		}
+		if len(buf) == 0 {
+			panic(&UnsupportedValueError{Value: w.v, Str: "empty map key of type " + w.v.Type().String()})
+		}
+		w.s = string(buf)
+		return
+	}
+	switch w.v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		w.s = strconv.FormatInt(w.v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		w.s = strconv.FormatUint(w.v.Uint(), 10)
+	case reflect.Bool:
+		w.s = strconv.FormatBool(w.v.Bool())
+	default:
+		panic("unexpected map key type")
+	}
+}
+
 type sliceEncoder struct {
 	arrayEnc encoderFunc
 }
 
 func (se *sliceEncoder) encode(e *encodeState, k string, v reflect.Value) {
+	// Slices sharing an array differ by their length.
+	if e.ptrLevel++; e.ptrLevel > startDetectingCyclesAfter {
+		ptr := struct {
+			ptr uintptr
+			len int
+		}{v.Pointer(), v.Len()}
+		e.enter(ptr, v)
+		defer e.leave(ptr)
+	}
 	se.arrayEnc(e, k, v)
+	e.ptrLevel--
 }
 
-func newSliceEncoder(t reflect.Type) encoderFunc {
+func newSliceEncoder(t reflect.Type, tagKey string) encoderFunc {
 	if t.Elem().Kind() == reflect.Uint8 {
 		return binaryEncoder
 	}
-	enc := &sliceEncoder{newArrayEncoder(t)}
+	enc := &sliceEncoder{newArrayEncoder(t, tagKey)}
 	return enc.encode
 }
 
@@ -518,19 +1265,50 @@ func (ae *arrayEncoder) encode(e *encodeState, k string, v reflect.Value) {
 	e.setKey(k, l)
 	// vpos defer
 	vpos := e.off
-	// count(4)
-	PutInt32(e.data[e.off:], int32(v.Len()))
+	// count defer
 	e.off += 4
 
-	for i := 0; i < v.Len(); i++ {
+	n := 0
+	i := 0
+	defer func() {
+		if r := recover(); r != nil {
+			addPath(r, "["+strconv.Itoa(i)+"]")
+		}
+	}()
+	for ; i < v.Len(); i++ {
+		off := e.off
 		ae.elemEnc(e, "", v.Index(i))
+		if e.off != off {
+			n++
+		}
 	}
+	// count
+	PutInt32(e.data[vpos:], int32(n))
 	// vlen
 	PutInt32(e.data[vlenpos:], int32(e.off-vpos))
 }
 
-func newArrayEncoder(t reflect.Type) encoderFunc {
-	enc := &arrayEncoder{typeEncoder(t.Elem())}
+func newArrayEncoder(t reflect.Type, tagKey string) encoderFunc {
+	enc := &arrayEncoder{typeEncoder(t.Elem(), tagKey)}
+	return enc.encode
+}
+
+type condAddrEncoder struct {
+	canAddrEnc, elseEnc encoderFunc
+}
+
+func (ce *condAddrEncoder) encode(e *encodeState, k string, v reflect.Value) {
+	if v.CanAddr() {
+		ce.canAddrEnc(e, k, v)
+	} else {
+		ce.elseEnc(e, k, v)
+	}
+}
+
+// newCondAddrEncoder returns an encoder that checks whether its value
+// CanAddr and delegates to canAddrEnc if so, else to elseEnc.
+func newCondAddrEncoder(canAddrEnc, elseEnc encoderFunc) encoderFunc {
+	enc := &condAddrEncoder{canAddrEnc: canAddrEnc, elseEnc: elseEnc}
 	return enc.encode
 }
 
@@ -543,11 +1321,17 @@ func (pe *ptrEncoder) encode(e *encodeState, k string, v reflect.Value) {
 		nilEncoder(e, k, v)
 		return
 	}
+	if e.ptrLevel++; e.ptrLevel > startDetectingCyclesAfter {
+		ptr := v.Interface()
+		e.enter(ptr, v)
+		defer e.leave(ptr)
+	}
 	pe.elemEnc(e, k, v.Elem())
+	e.ptrLevel--
 }
 
-func newPtrEncoder(t reflect.Type) encoderFunc {
-	enc := &ptrEncoder{typeEncoder(t.Elem())}
+func newPtrEncoder(t reflect.Type, tagKey string) encoderFunc {
+	enc := &ptrEncoder{typeEncoder(t.Elem(), tagKey)}
 	return enc.encode
 }
 
@@ -560,6 +1344,22 @@ type field struct {
 	index     []int
 	typ       reflect.Type
 	omitEmpty bool
+	omitZero  bool
+	isZero    func(reflect.Value) bool
+	quoted    bool
+	required  bool
+}
+
+// structFields lists the fields of a struct type recognized by kspack.
+type structFields struct {
+	list   []field
+	byName map[string]int // index in list by exact name
+
+	// inlineMap is the map[string]T field tagged ",inline", if any,
+	// that holds the object members matching no field.
+	inlineMap *field
+	// required reports whether a field of list is tagged ",required".
+	required bool
 }
 
 func fillField(f field) field {
@@ -568,34 +1368,30 @@ func fillField(f field) field {
 	return f
 }
 
-var fieldCache struct {
-	sync.RWMutex
-	m map[reflect.Type][]field
-}
+var fieldCache sync.Map // map[cacheKey]structFields
 
-func cachedTypeFields(t reflect.Type) []field {
-	fieldCache.RLock()
-	f := fieldCache.m[t]
-	fieldCache.RUnlock()
-	if f != nil {
-		return f
-	}
-
-	f = typeFields(t)
-	if f == nil {
-		f = []field{}
+// cachedTypeFields is like typeFields but uses a cache to avoid repeated work.
+func cachedTypeFields(t reflect.Type, tagKey string) structFields {
+	key := cacheKey{t, tagKey}
+	if f, ok := fieldCache.Load(key); ok {
+		return f.(structFields)
 	}
+	f, _ := fieldCache.LoadOrStore(key, typeFields(t, tagKey))
+	return f.(structFields)
+}
 
-	fieldCache.Lock()
-	if fieldCache.m == nil {
-		fieldCache.m = map[reflect.Type][]field{}
+// fieldTag returns the tag of sf under tagKey, or its json tag if it
+// has none.
+func fieldTag(sf reflect.StructField, tagKey string) string {
+	if tag, ok := sf.Tag.Lookup(tagKey); ok {
+		return tag
 	}
-	fieldCache.m[t] = f
-	fieldCache.Unlock()
-	return f
+	return sf.Tag.Get("json")
 }
 
-func typeFields(t reflect.Type) []field {
+// typeFields returns the fields that kspack should recognize for the
+// given type, reading their tags under tagKey.
+func typeFields(t reflect.Type, tagKey string) structFields {
 	current := []field{}
 	next := []field{{typ: t}}
 
@@ -605,6 +1401,7 @@ func typeFields(t reflect.Type) []field {
 	visited := map[reflect.Type]bool{}
 
 	var fields []field
+	var inlineMap *field
 
 	for len(next) > 0 {
 		current, next = next, current[:0]
@@ -622,7 +1419,7 @@ func typeFields(t reflect.Type) []field {
 				if sf.PkgPath != "" {
 					continue
 				}
-				tag := sf.Tag.Get("json")
+				tag := fieldTag(sf, tagKey)
 				if tag == "-" {
 					continue
 				}
@@ -638,17 +1435,41 @@ func typeFields(t reflect.Type) []field {
 					ft = ft.Elem() // FIXME: why???
 				}
 
-				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+				inline := opts.Contains("inline")
+				if inline && sf.Type.Kind() == reflect.Map && sf.Type.Key().Kind() == reflect.String {
+					// the shallowest inline map wins
+					if inlineMap == nil {
+						inlineMap = &field{name: sf.Name, index: index, typ: sf.Type}
+					}
+					continue
+				}
+				if inline && ft.Kind() == reflect.Struct {
+					name = ""
+				} else if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
 					tagged := name != ""
 					if name == "" {
 						name = sf.Name
 					}
+					quoted := false
+					if opts.Contains("string") {
+						switch ft.Kind() {
+						case reflect.Bool,
+							reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+							reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+							reflect.Float32, reflect.Float64:
+							quoted = true
+						}
+					}
 					fields = append(fields, fillField(field{
 						name:      name,
 						tag:       tagged,
 						index:     index,
 						typ:       ft,
 						omitEmpty: opts.Contains("omitempty"),
+						omitZero:  opts.Contains("omitzero"),
+						isZero:    zeroFunc(sf.Type),
+						quoted:    quoted,
+						required:  opts.Contains("required"),
 					}))
 					//?? why append twice ?
 					if count[f.typ] > 1 {
@@ -688,7 +1509,12 @@ func typeFields(t reflect.Type) []field {
 	fields = out
 	sort.Sort(byIndex(fields))
 
-	return fields
+	sf := structFields{list: fields, byName: make(map[string]int, len(fields)), inlineMap: inlineMap}
+	for i, f := range fields {
+		sf.byName[f.name] = i
+		sf.required = sf.required || f.required
+	}
+	return sf
 }
 
 func dominantField(fields []field) (field, bool) {
@@ -723,6 +1549,43 @@ func dominantField(fields []field) (field, bool) {
 	return fields[0], true
 }
 
+type isZeroer interface {
+	IsZero() bool
+}
+
+var isZeroerType = reflect.TypeOf((*isZeroer)(nil)).Elem()
+
+// zeroFunc returns the function telling whether a value of type t is
+// zero for ",omitzero": its IsZero method if it has one, else whether
+// it is the zero value of t.
+func zeroFunc(t reflect.Type) func(reflect.Value) bool {
+	switch {
+	case t.Kind() == reflect.Interface && t.Implements(isZeroerType):
+		return func(v reflect.Value) bool {
+			return v.IsNil() || v.Elem().Kind() == reflect.Ptr && v.Elem().IsNil() || v.Interface().(isZeroer).IsZero()
+		}
+	case t.Kind() == reflect.Ptr && t.Implements(isZeroerType):
+		return func(v reflect.Value) bool {
+			return v.IsNil() || v.Interface().(isZeroer).IsZero()
+		}
+	case t.Implements(isZeroerType):
+		return func(v reflect.Value) bool {
+			return v.Interface().(isZeroer).IsZero()
+		}
+	case reflect.PtrTo(t).Implements(isZeroerType):
+		return func(v reflect.Value) bool {
+			if !v.CanAddr() {
+				// a temporary copy is needed to call the method
+				p := reflect.New(t)
+				p.Elem().Set(v)
+				v = p.Elem()
+			}
+			return v.Addr().Interface().(isZeroer).IsZero()
+		}
+	}
+	return reflect.Value.IsZero
+}
+
 func isEmptyValue(v reflect.Value) bool {
 	switch v.Kind() {
 	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
@@ -18,10 +18,13 @@ package pack
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -328,11 +331,17 @@ func TestUnsupportedTypeEncoder(t *testing.T) {
 		off:  1,
 	}
 	s := []byte("adfasdf")
-	unsupportedTypeEncoder(&e, "dfasdvsdghhd", reflect.ValueOf(s))
+	assert.PanicsWithError("kspack: unsupported type []uint8", func() {
+		unsupportedTypeEncoder(&e, "dfasdvsdghhd", reflect.ValueOf(s))
+	})
 	assert.Equal(e.off, 1)
 
-	invalidValueEncoder(&e, "dfasdvsdghhd", reflect.ValueOf(s))
+	e.skipUnsupported = true
+	unsupportedTypeEncoder(&e, "dfasdvsdghhd", reflect.ValueOf(s))
 	assert.Equal(e.off, 1)
+
+	invalidValueEncoder(&e, "k", reflect.Value{})
+	assert.Equal([]byte{KSPACK_NULL, 2, 'k', 0, 0}, e.data[1:e.off])
 }
 
 func TestIsValidTag(t *testing.T) {
@@ -367,3 +376,312 @@ func TestByNameAndByIndex(t *testing.T) {
 	assert.Equal(aa, field{name: "", nameBytes: []uint8(nil), equalFold: (func([]uint8, []uint8) bool)(nil), tag: false, index: []int(nil), typ: reflect.Type(nil), omitEmpty: false})
 	assert.False(ok)
 }
+
+type point struct {
+	X, Y int8
+}
+
+// MarshalKSPACK writes a point as a two byte binary item.
+func (p point) MarshalKSPACK() ([]byte, error) {
+	return []byte{KSPACK_SHORT_BINARY, 0, 2, byte(p.X), byte(p.Y)}, nil
+}
+
+func (p *point) UnmarshalKSPACK(b []byte) error {
+	var raw []byte
+	if err := Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	p.X, p.Y = int8(raw[0]), int8(raw[1])
+	return nil
+}
+
+type label string
+
+// MarshalKSPACK has a pointer receiver and is only used for addressable values.
+func (l *label) MarshalKSPACK() ([]byte, error) {
+	return Marshal(strings.ToUpper(string(*l)))
+}
+
+type badItem struct{}
+
+func (badItem) MarshalKSPACK() ([]byte, error) {
+	return []byte{KSPACK_STRING, 0, 9, 0, 0, 0, 'a', 0}, nil
+}
+
+type failItem struct{}
+
+// nilDerefItem panics with a runtime error when marshaled.
+type nilDerefItem struct{ p *int }
+
+func (n nilDerefItem) MarshalKSPACK() ([]byte, error) {
+	return []byte{KSPACK_INT8, 0, byte(*n.p)}, nil
+}
+
+func (failItem) MarshalKSPACK() ([]byte, error) {
+	return nil, errors.New("boom")
+}
+
+type shape struct {
+	Origin point
+	Path   []point
+	Names  map[string]point
+	Tag    label
+	Ref    *point
+}
+
+func TestMarshaler(t *testing.T) {
+	assert := assert.New(t)
+
+	b, err := Marshal(point{1, 2})
+	assert.Nil(err)
+	assert.Equal([]byte{KSPACK_SHORT_BINARY, 0, 2, 1, 2}, b)
+
+	in := &shape{
+		Origin: point{1, 2},
+		Path:   []point{{3, 4}},
+		Names:  map[string]point{"p": {5, 6}},
+		Tag:    "abc",
+	}
+	b, err = Marshal(in)
+	assert.Nil(err)
+	assert.Nil(checkValid(b))
+	assert.Equal([]byte{
+		KSPACK_OBJECT, 0, 77, 0, 0, 0,
+		5, 0, 0, 0,
+		KSPACK_SHORT_BINARY, 7, 2, 'O', 'r', 'i', 'g', 'i', 'n', 0, 1, 2,
+		KSPACK_ARRAY, 5, 9, 0, 0, 0, 'P', 'a', 't', 'h', 0, 1, 0, 0, 0, KSPACK_SHORT_BINARY, 0, 2, 3, 4,
+		KSPACK_OBJECT, 6, 11, 0, 0, 0, 'N', 'a', 'm', 'e', 's', 0, 1, 0, 0, 0, KSPACK_SHORT_BINARY, 2, 2, 'p', 0, 5, 6,
+		KSPACK_SHORT_STRING, 4, 4, 'T', 'a', 'g', 0, 'A', 'B', 'C', 0,
+		KSPACK_NULL, 4, 'R', 'e', 'f', 0, 0,
+	}, b)
+
+	out := &shape{}
+	assert.Nil(Unmarshal(b, out))
+	assert.Equal(in.Origin, out.Origin)
+	assert.Equal(in.Path, out.Path)
+	assert.Equal(in.Names, out.Names)
+	assert.Equal(label("ABC"), out.Tag)
+	assert.Nil(out.Ref)
+
+	// not addressable: the pointer method is not used
+	b, err = Marshal(shape{Tag: "abc"})
+	assert.Nil(err)
+	assert.True(bytes.Contains(b, []byte{'T', 'a', 'g', 0, 'a', 'b', 'c', 0}))
+
+	_, err = Marshal(badItem{})
+	var me *MarshalerError
+	assert.True(errors.As(err, &me))
+	assert.Equal(reflect.TypeOf(badItem{}), me.Type)
+
+	_, err = Marshal([]interface{}{failItem{}})
+	assert.EqualError(err, "kspack: error calling MarshalKSPACK for type pack.failItem: boom")
+}
+
+func TestEncodeStateItem(t *testing.T) {
+	assert := assert.New(t)
+	e := &encodeState{}
+	e.item("key", []byte{KSPACK_INT16, 4, 'o', 'l', 'd', 0, 1, 0})
+	e.item("", []byte{KSPACK_STRING, 0, 2, 0, 0, 0, 'a', 0})
+	assert.Equal([]byte{
+		KSPACK_INT16, 4, 'k', 'e', 'y', 0, 1, 0,
+		KSPACK_STRING, 0, 2, 0, 0, 0, 'a', 0,
+	}, e.data[:e.off])
+}
+
+type lineItem struct {
+	Sku    string
+	Notify chan int
+}
+
+type purchase struct {
+	ID     int
+	Items  []lineItem
+	Meta   map[string]interface{}
+	Total  complex128
+	Note   string `json:",omitempty"`
+	Closed time.Time
+}
+
+func TestUnsupportedErrors(t *testing.T) {
+	assert := assert.New(t)
+	in := purchase{ID: 7, Items: []lineItem{{Sku: "a"}, {Sku: "b"}}}
+
+	_, err := Marshal(in)
+	var ute *UnsupportedTypeError
+	assert.True(errors.As(err, &ute))
+	assert.Equal(reflect.TypeOf(make(chan int)), ute.Type)
+	assert.Equal("Items[0].Notify", ute.Path)
+	assert.EqualError(err, "kspack: unsupported type chan int at Items[0].Notify")
+
+	in.Items = nil
+	in.Meta = map[string]interface{}{"ok": 1, "fn": func() {}}
+	_, err = Marshal(in)
+	assert.EqualError(err, "kspack: unsupported type func() at Meta.fn")
+
+	_, err = Marshal(make(chan int))
+	assert.EqualError(err, "kspack: unsupported type chan int")
+
+	in.Meta = nil
+	_, err = Marshal(in)
+	assert.EqualError(err, "kspack: unsupported type complex128 at Total")
+
+	_, err = Marshal([]interface{}{map[string]time.Time{"Closed": time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)}})
+	var uve *UnsupportedValueError
+	assert.True(errors.As(err, &uve))
+	assert.Equal("[0].Closed", uve.Path)
+
+	data, err := Marshal(nil)
+	assert.NoError(err)
+	assert.Equal([]byte{KSPACK_NULL, 0, 0}, data)
+}
+
+func TestSkipUnsupported(t *testing.T) {
+	assert := assert.New(t)
+	skip := EncodeOptions{SkipUnsupported: true}
+	in := purchase{
+		ID:    7,
+		Items: []lineItem{{Sku: "a"}, {Sku: "b"}},
+		Meta:  map[string]interface{}{"ok": "yes", "fn": func() {}, "ch": make(chan int)},
+	}
+
+	data, err := skip.Marshal(in)
+	assert.NoError(err)
+	assert.NoError(checkValid(data))
+
+	var out map[string]interface{}
+	assert.NoError(Unmarshal(data, &out))
+	assert.Len(out, 4) // ID, Items, Meta, Closed
+	assert.Equal(map[string]interface{}{"Sku": "a"}, out["Items"].([]interface{})[0])
+	assert.Equal(map[string]interface{}{"ok": "yes"}, out["Meta"])
+
+	data, err = skip.Marshal([]interface{}{1, make(chan int), "x"})
+	assert.NoError(err)
+	var arr []interface{}
+	assert.NoError(Unmarshal(data, &arr))
+	assert.Equal([]interface{}{int64(1), "x"}, arr)
+
+	_, err = skip.Marshal(func() {})
+	assert.EqualError(err, "kspack: unsupported type func()")
+
+	// omitted fields are not counted either
+	data, err = Marshal(struct {
+		A string `json:",omitempty"`
+		B int
+	}{B: 1})
+	assert.NoError(err)
+	assert.NoError(checkValid(data))
+}
+
+type treeNode struct {
+	Name     string
+	Parent   *treeNode
+	Children []*treeNode
+}
+
+func TestEncodeCycle(t *testing.T) {
+	assert := assert.New(t)
+	root := &treeNode{Name: "root"}
+	child := &treeNode{Name: "child", Parent: root}
+	root.Children = []*treeNode{child}
+
+	_, err := Marshal(root)
+	var uve *UnsupportedValueError
+	if assert.True(errors.As(err, &uve)) {
+		assert.Contains(uve.Str, "encountered a cycle via ")
+		// the cycle is reported from whichever of its values is met
+		// twice first, without its earlier turns
+		assert.Contains([]string{"", "Children", "Children[0]"}, uve.Path)
+		assert.Contains([]string{"Children[0].Parent", "[0].Parent.Children", "Parent.Children[0]"}, uve.loop)
+	}
+
+	self := &treeNode{Name: "self"}
+	self.Parent = self
+	_, err = Marshal(map[string]interface{}{"node": self})
+	assert.EqualError(err, "kspack: unsupported value encountered a cycle via *pack.treeNode through Parent at node")
+
+	m := map[string]interface{}{}
+	m["m"] = m
+	_, err = Marshal(m)
+	assert.EqualError(err, "kspack: unsupported value encountered a cycle via map[string]interface {} through m")
+
+	s := []interface{}{nil}
+	s[0] = s
+	_, err = Marshal(s)
+	assert.EqualError(err, "kspack: unsupported value encountered a cycle via []interface {} through [0]")
+
+	// deep but acyclic values are fine
+	deep := &treeNode{}
+	for i := 0; i < 2*startDetectingCyclesAfter; i++ {
+		deep = &treeNode{Parent: deep}
+	}
+	_, err = Marshal(deep)
+	assert.NoError(err)
+	child.Parent = nil
+	_, err = Marshal([]*treeNode{root, root, child})
+	assert.NoError(err)
+
+	// values nested less deeply are not tracked, nor boxed for it
+	ints := []int64{1, 2}
+	out := make([]byte, 0, 64)
+	allocs := testing.AllocsPerRun(100, func() {
+		out, _ = MarshalAppend(out[:0], &ints)
+		_, _ = Size(&ints)
+	})
+	assert.Zero(allocs)
+}
+
+func TestMarshalAppend(t *testing.T) {
+	assert := assert.New(t)
+
+	in := map[string]interface{}{"b": []int{1, 2}, "a": "x", "c": map[string]bool{"z": true, "y": false}}
+	want, err := MarshalCanonical(in)
+	assert.NoError(err)
+
+	dst := append(make([]byte, 0, 256), "head"...)
+	out, err := EncodeOptions{Canonical: true}.MarshalAppend(dst, in)
+	assert.NoError(err)
+	assert.Equal("head", string(out[:4]))
+	assert.Equal(want, out[4:])
+	assert.Equal(&dst[0], &out[0], "written in place")
+
+	// the pooled state of an earlier, longer value leaks nothing
+	short, err := Marshal(int8(1))
+	assert.NoError(err)
+	assert.Equal([]byte{KSPACK_INT8, 0, 1}, short)
+
+	out, err = MarshalAppend(dst, make(chan int))
+	assert.Error(err)
+	assert.Equal(dst, out)
+
+	// dst grows as append would once its capacity runs out
+	small := append(make([]byte, 0, 8), "head"...)
+	out, err = EncodeOptions{Canonical: true}.MarshalAppend(small, in)
+	assert.NoError(err)
+	assert.Equal("head", string(out[:4]))
+	assert.Equal(want, out[4:])
+	assert.Equal("head", string(small))
+
+	allocs := testing.AllocsPerRun(100, func() {
+		out, _ = MarshalAppend(out[:0], int64(7))
+	})
+	assert.Zero(allocs)
+
+	// a panicking value leaves no pooled state writing into dst
+	dst = make([]byte, 0, 64)
+	assert.Panics(func() { _, _ = MarshalAppend(dst, nilDerefItem{}) })
+	for i := 0; i < 10; i++ {
+		_, err = Marshal(strings.Repeat("x", 32))
+		assert.NoError(err)
+	}
+	assert.Equal(make([]byte, 64), dst[:64])
+
+	// large buffers are not kept in the pool
+	e := newEncodeState()
+	e.data = make([]byte, maxPooledBufferSize+1)
+	putEncodeState(e)
+	assert.Nil(e.data)
+	e = newEncodeState()
+	e.data = make([]byte, maxPooledBufferSize)
+	putEncodeState(e)
+	assert.NotNil(e.data)
+}
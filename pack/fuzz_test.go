@@ -0,0 +1,134 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func fuzzSeeds(f *testing.F) {
+	seeds := []interface{}{
+		nil,
+		true,
+		int8(-1), int16(300), int32(-70000), int64(1) << 40,
+		uint8(255), uint16(65535), uint32(1) << 31, uint64(1) << 63,
+		float32(1.5), 3.25,
+		"", "short", string(make([]byte, 300)),
+		[]byte{1, 2, 3}, make([]byte, 300),
+		time.Date(2017, 7, 7, 9, 0, 0, 0, time.UTC),
+		time.Date(2017, 7, 7, 9, 0, 0, 0, time.FixedZone("", 3600)),
+		[]interface{}{1, "a", nil, []int{1, 2}},
+		map[string]interface{}{"a": 1, "b": map[string]string{"c": "d"}},
+		Person{Name: "dongjiang", Age: 18, Info: info{AAA: []int8{1, -1}}},
+	}
+	for _, v := range seeds {
+		data, err := Marshal(v)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, newValue := range []func() interface{}{
+			func() interface{} { return new(interface{}) },
+			func() interface{} { return new(map[string]interface{}) },
+			func() interface{} { return new([]interface{}) },
+			func() interface{} { return new(Person) },
+		} {
+			v := newValue()
+			if err := Unmarshal(data, v); err != nil {
+				continue
+			}
+			if _, err := Marshal(v); err != nil {
+				t.Fatalf("failed to marshal decoded %T: %v", v, err)
+			}
+		}
+		var raw RawMessage
+		_ = Unmarshal(data, &raw)
+	})
+}
+
+func FuzzCanonicalize(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c, err := Canonicalize(data)
+		if err != nil {
+			return
+		}
+		cc, err := Canonicalize(c)
+		if err != nil {
+			t.Fatalf("failed to canonicalize canonical item: %v", err)
+		}
+		if !bytes.Equal(c, cc) {
+			t.Fatalf("canonical form is not stable:\n%x\n%x", c, cc)
+		}
+	})
+}
+
+func FuzzGet(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, path := range [][]interface{}{
+			{}, {0}, {1}, {3, 1}, {"a"}, {"b", "c"}, {"Info", "AAA", 1},
+		} {
+			v, err := Get(data, path...)
+			if err != nil {
+				continue
+			}
+			v.Int64()
+			v.Uint64()
+			v.Float64()
+			v.Bool()
+			v.String()
+			v.Bytes()
+			if err := checkItem(v.Raw()); err != nil && checkItem(data) == nil {
+				t.Fatalf("Get(%v) of a valid item returned an invalid one: %v", path, err)
+			}
+		}
+	})
+}
+
+func FuzzQuery(f *testing.F) {
+	for _, expr := range []string{
+		`$`, `$.orders[*].items[?(@.qty > 2)].sku`, `$..sku`, `$.orders[::-1].id`,
+		`$["odd key"][?(@ != @ || !(@ == null) && @[0] <= 'a')]`, `$[1:-1:2, 0]`,
+	} {
+		f.Add(expr)
+	}
+	data := querySample()
+	f.Fuzz(func(t *testing.T, expr string) {
+		q, err := CompileQuery(expr)
+		if err != nil {
+			return
+		}
+		vs, err := q.Find(data)
+		if err != nil {
+			t.Fatalf("query %q failed on a valid item: %v", expr, err)
+		}
+		for _, v := range vs {
+			if err := checkItem(v.Raw()); err != nil {
+				t.Fatalf("query %q returned an invalid item: %v", expr, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,682 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kubeservice-stack/kspack-go/pack/internal"
+)
+
+// The functions of this file are the runtime of the EncodeKSPACK and
+// DecodeKSPACK methods generated by cmd/kspackgen, which package
+// genrt exports through pack/internal. They write and read the items of
+// the reflective encoder and decoder without going through reflect.
+//
+// The append functions write an item under the key k at the end of b
+// and return the extended buffer. k must be at most KSPACK_KEY_MAX_LEN
+// bytes long, see checkKey.
+
+func init() {
+	internal.AppendNull = appendNull
+	internal.AppendBool = appendBool
+	internal.AppendInt8 = appendInt8
+	internal.AppendInt16 = appendInt16
+	internal.AppendInt32 = appendInt32
+	internal.AppendInt64 = appendInt64
+	internal.AppendUint8 = appendUint8
+	internal.AppendUint16 = appendUint16
+	internal.AppendUint32 = appendUint32
+	internal.AppendUint64 = appendUint64
+	internal.AppendFloat32 = appendFloat32
+	internal.AppendFloat64 = appendFloat64
+	internal.AppendString = appendString
+	internal.AppendBinary = appendBinary
+	internal.AppendTime = appendTime
+	internal.BeginObject = beginObject
+	internal.BeginArray = beginArray
+	internal.EndContainer = endContainer
+	internal.CheckKey = checkKey
+	internal.ErrorAt = errorAt
+
+	internal.ItemCount = itemCount
+	internal.Members = eachMember
+	internal.Elements = eachElement
+	internal.DecodeBool = decodeBool
+	internal.DecodeInt = decodeInt
+	internal.DecodeUint = decodeUint
+	internal.DecodeFloat = decodeFloat
+	internal.DecodeTime = decodeTime
+	internal.DecodeQuoted = decodeQuoted
+	internal.QuotedError = quotedError
+	internal.TypeError = itemTypeError
+}
+
+// appendState returns an encodeState writing at the end of b.
+func appendState(b []byte) encodeState {
+	return encodeState{data: b[:cap(b)], off: len(b)}
+}
+
+// checkKey returns the error Marshal reports for an object key k that
+// is too long.
+func checkKey(k string) error {
+	if len(k) > KSPACK_KEY_MAX_LEN {
+		return fmt.Errorf("len(key) exceeds %d", KSPACK_KEY_MAX_LEN)
+	}
+	return nil
+}
+
+// appendNull appends a NULL item.
+func appendNull(b []byte, k string) []byte {
+	e := appendState(b)
+	nilEncoder(&e, k, reflect.Value{})
+	return e.data[:e.off]
+}
+
+// appendBool appends a BOOL item.
+func appendBool(b []byte, k string, v bool) []byte {
+	e := appendState(b)
+	e.bool(k, v)
+	return e.data[:e.off]
+}
+
+// appendInt8 appends an INT8 item.
+func appendInt8(b []byte, k string, v int8) []byte {
+	e := appendState(b)
+	e.int8(k, v)
+	return e.data[:e.off]
+}
+
+// appendInt16 appends an INT16 item.
+func appendInt16(b []byte, k string, v int16) []byte {
+	e := appendState(b)
+	e.int16(k, v)
+	return e.data[:e.off]
+}
+
+// appendInt32 appends an INT32 item.
+func appendInt32(b []byte, k string, v int32) []byte {
+	e := appendState(b)
+	e.int32(k, v)
+	return e.data[:e.off]
+}
+
+// appendInt64 appends an INT64 item, which int values are written as.
+func appendInt64(b []byte, k string, v int64) []byte {
+	e := appendState(b)
+	e.int64(k, v)
+	return e.data[:e.off]
+}
+
+// appendUint8 appends a UINT8 item.
+func appendUint8(b []byte, k string, v uint8) []byte {
+	e := appendState(b)
+	e.uint8(k, v)
+	return e.data[:e.off]
+}
+
+// appendUint16 appends a UINT16 item.
+func appendUint16(b []byte, k string, v uint16) []byte {
+	e := appendState(b)
+	e.uint16(k, v)
+	return e.data[:e.off]
+}
+
+// appendUint32 appends a UINT32 item.
+func appendUint32(b []byte, k string, v uint32) []byte {
+	e := appendState(b)
+	e.uint32(k, v)
+	return e.data[:e.off]
+}
+
+// appendUint64 appends a UINT64 item, which uint and uintptr values
+// are written as.
+func appendUint64(b []byte, k string, v uint64) []byte {
+	e := appendState(b)
+	e.uint64(k, v)
+	return e.data[:e.off]
+}
+
+// appendFloat32 appends a FLOAT item.
+func appendFloat32(b []byte, k string, v float32) []byte {
+	e := appendState(b)
+	e.float32(k, v)
+	return e.data[:e.off]
+}
+
+// appendFloat64 appends a DOUBLE item.
+func appendFloat64(b []byte, k string, v float64) []byte {
+	e := appendState(b)
+	e.float64(k, v)
+	return e.data[:e.off]
+}
+
+// appendString appends a STRING or SHORT_STRING item.
+func appendString(b []byte, k string, v string) []byte {
+	e := appendState(b)
+	e.string(k, v)
+	return e.data[:e.off]
+}
+
+// appendBinary appends a BINARY or SHORT_BINARY item.
+func appendBinary(b []byte, k string, v []byte) []byte {
+	e := appendState(b)
+	e.binary(k, v)
+	return e.data[:e.off]
+}
+
+// appendTime appends a DATE item if t is in UTC, else a ZONED_DATE item.
+// It fails if t is out of DATE range.
+func appendTime(b []byte, k string, t time.Time) ([]byte, error) {
+	e := appendState(b)
+	if !e.time(k, t) {
+		return b, dateRangeError(reflect.ValueOf(t), t)
+	}
+	return e.data[:e.off], nil
+}
+
+// beginObject appends the header of an OBJECT item and returns the
+// offset of the item, to be passed to endContainer once its members are
+// appended.
+func beginObject(b []byte, k string) ([]byte, int) {
+	return beginContainer(b, KSPACK_OBJECT, k)
+}
+
+// beginArray appends the header of an ARRAY item and returns the offset
+// of the item, to be passed to endContainer once its elements are
+// appended.
+func beginArray(b []byte, k string) ([]byte, int) {
+	return beginContainer(b, KSPACK_ARRAY, k)
+}
+
+// type(1) | klen(1) | vlen(4) | key(len(k)) | 0x00 | member number(4)
+func beginContainer(b []byte, typ byte, k string) ([]byte, int) {
+	e := appendState(b)
+	e.resizeIfNeeded(1 + 1 + 4 + len(k) + 1 + 4)
+	start := e.off
+	e.setType(typ)
+	l := e.setKeyLen(k)
+	e.off += 4 // vlen
+	e.setKey(k, l)
+	e.off += 4 // member number
+	return e.data[:e.off], start
+}
+
+// endContainer completes the header of the OBJECT or ARRAY item at
+// offset start of b, holding the n items appended since beginObject or
+// beginArray.
+func endContainer(b []byte, start, n int) []byte {
+	vpos := start + 1 + 1 + 4 + int(b[start+1])
+	PutInt32(b[vpos:], int32(n))
+	PutInt32(b[start+2:], int32(len(b)-vpos))
+	return b
+}
+
+// The decode functions read the item at offset off of data, which must
+// have been checked by checkItem. A NULL item decodes to the zero value.
+// The ones reporting a bool return false if the item does not fit the
+// requested Go type, which itemTypeError then describes.
+
+// checkItem verifies that data holds exactly one item that can be
+// decoded, as Unmarshal does first.
+func checkItem(data []byte) error {
+	s := scanner{}
+	return s.scan(data)
+}
+
+// itemValue returns the value bytes of the item at off. A string keeps
+// its trailing 0x00.
+func itemValue(data []byte, off int) []byte {
+	h := itemHeaderLen(data[off])
+	start := off + h + int(data[off+1])
+	switch h {
+	case 2:
+		return data[start : start+fixedItemLen(data[off])]
+	case 3:
+		return data[start : start+int(data[off+2])]
+	}
+	return data[start : start+int(Uint32(data[off+2:]))]
+}
+
+// itemCount returns the member number of the OBJECT or ARRAY item at off.
+func itemCount(data []byte, off int) int {
+	return int(Uint32(itemValue(data, off)))
+}
+
+// eachMember calls fn with the key and offset of each member of the OBJECT
+// item at off, in order, and stops at the first error fn returns.
+func eachMember(data []byte, off int, fn func(key []byte, off int) error) error {
+	d := decodeState{data: data, off: off}
+	n := itemCount(data, off)
+	d.off += itemHeaderLen(data[off]) + int(data[off+1]) + 4
+	for i := 0; i < n; i++ {
+		start := d.off
+		if data[start+1] == 0 {
+			return errEmptyKey
+		}
+		key := d.key()
+		d.next()
+		if err := fn(key, start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachElement calls fn with the index and offset of each element of the
+// ARRAY item at off, in order, and stops at the first error fn returns.
+func eachElement(data []byte, off int, fn func(i, off int) error) error {
+	d := decodeState{data: data, off: off}
+	n := itemCount(data, off)
+	d.off += itemHeaderLen(data[off]) + int(data[off+1]) + 4
+	for i := 0; i < n; i++ {
+		start := d.off
+		d.next()
+		if err := fn(i, start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeBool decodes a BOOL item.
+func decodeBool(data []byte, off int) (bool, bool) {
+	switch data[off] {
+	case KSPACK_NULL:
+		return false, true
+	case KSPACK_BOOL:
+		return itemValue(data, off)[0] != 0, true
+	}
+	return false, false
+}
+
+// decodeInt decodes a signed integer item into an integer of the given
+// bit size.
+func decodeInt(data []byte, off int, bits int) (int64, bool) {
+	var n int64
+	switch v := itemValue(data, off); data[off] {
+	case KSPACK_NULL:
+		return 0, true
+	case KSPACK_INT8:
+		n = int64(Int8(v))
+	case KSPACK_INT16:
+		n = int64(Int16(v))
+	case KSPACK_INT32:
+		n = int64(Int32(v))
+	case KSPACK_INT64:
+		n = Int64(v)
+	default:
+		return 0, false
+	}
+	if shift := 64 - uint(bits); n<<shift>>shift != n {
+		return 0, false
+	}
+	return n, true
+}
+
+// decodeUint decodes an unsigned integer item into an integer of the
+// given bit size.
+func decodeUint(data []byte, off int, bits int) (uint64, bool) {
+	var n uint64
+	switch v := itemValue(data, off); data[off] {
+	case KSPACK_NULL:
+		return 0, true
+	case KSPACK_UINT8:
+		n = uint64(Uint8(v))
+	case KSPACK_UINT16:
+		n = uint64(Uint16(v))
+	case KSPACK_UINT32:
+		n = uint64(Uint32(v))
+	case KSPACK_UINT64:
+		n = Uint64(v)
+	default:
+		return 0, false
+	}
+	if shift := 64 - uint(bits); n<<shift>>shift != n {
+		return 0, false
+	}
+	return n, true
+}
+
+// decodeFloat decodes a FLOAT or DOUBLE item into a float of the given
+// bit size.
+func decodeFloat(data []byte, off int, bits int) (float64, bool) {
+	switch v := itemValue(data, off); data[off] {
+	case KSPACK_NULL:
+		return 0, true
+	case KSPACK_FLOAT:
+		return float64(Float32(v)), true
+	case KSPACK_DOUBLE:
+		f := Float64(v)
+		if bits == 32 && math.MaxFloat32 < math.Abs(f) && !math.IsInf(f, 0) {
+			return 0, false
+		}
+		return f, true
+	}
+	return 0, false
+}
+
+// decodeString decodes a STRING or SHORT_STRING item.
+func decodeString(data []byte, off int) (string, bool) {
+	switch data[off] {
+	case KSPACK_NULL:
+		return "", true
+	case KSPACK_STRING, KSPACK_SHORT_STRING:
+		v := itemValue(data, off)
+		return string(v[:len(v)-1]), true
+	}
+	return "", false
+}
+
+// decodeTime decodes a DATE or ZONED_DATE item into t, or a string or
+// binary item through its UnmarshalText or UnmarshalBinary method.
+func decodeTime(data []byte, off int, t *time.Time) error {
+	d := decodeState{data: data, off: off}
+	switch data[off] {
+	case KSPACK_NULL:
+		*t = time.Time{}
+	case KSPACK_DATE:
+		*t = d.dateValue()
+	case KSPACK_ZONED_DATE:
+		*t = d.zonedDateValue()
+	case KSPACK_STRING, KSPACK_SHORT_STRING:
+		return t.UnmarshalText(d.stringBytes())
+	case KSPACK_BINARY, KSPACK_SHORT_BINARY:
+		return t.UnmarshalBinary(d.binaryBytes())
+	case KSPACK_OBJECT:
+		// a struct without exported fields
+	default:
+		return itemTypeError(data, off, timeType)
+	}
+	return nil
+}
+
+// decodeQuoted returns the text of the STRING or SHORT_STRING item at
+// off, holding the value of a field tagged ",string". It returns false
+// for other items, to be decoded as the field type.
+func decodeQuoted(data []byte, off int) (string, bool) {
+	switch data[off] {
+	case KSPACK_STRING, KSPACK_SHORT_STRING:
+		v := itemValue(data, off)
+		return string(v[:len(v)-1]), true
+	}
+	return "", false
+}
+
+// generatedEncoder is implemented by the pointers to the struct types
+// cmd/kspackgen generates methods for. EncodeKSPACK appends the item of
+// the struct under the key k at the end of b within the state of the
+// running encoder e, so that cycles through it are detected.
+type generatedEncoder interface {
+	EncodeKSPACK(e internal.Encoder, b []byte, k string) ([]byte, error)
+}
+
+var generatedEncoderType = reflect.TypeOf((*generatedEncoder)(nil)).Elem()
+
+// generatedPtrEncoder encodes the pointers to the generated types.
+func generatedPtrEncoder(e *encodeState, k string, v reflect.Value) {
+	if v.IsNil() {
+		nilEncoder(e, k, v)
+		return
+	}
+	e.generated(k, v)
+}
+
+// generatedValueEncoder encodes the generated types, whose methods have
+// pointer receivers: a value that is not addressable is copied.
+func generatedValueEncoder(e *encodeState, k string, v reflect.Value) {
+	if !v.CanAddr() {
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		v = p.Elem()
+	}
+	e.generated(k, v.Addr())
+}
+
+var generatedEncoderFallbackCache sync.Map // map[cacheKey]encoderFunc
+
+// generated encodes the struct p points to through its EncodeKSPACK
+// method. The generated methods write the fields as Marshal does with
+// the default EncodeOptions: under other options, such as another tag
+// key, the struct is encoded reflectively instead, as through a pointer.
+func (e *encodeState) generated(k string, p reflect.Value) {
+	if e.canonical || e.skipUnsupported || e.tagKey != DefaultTagKey {
+		key := cacheKey{p.Type(), e.tagKey}
+		enc, ok := generatedEncoderFallbackCache.Load(key)
+		if !ok {
+			pe := &ptrEncoder{newStructEncoder(p.Type().Elem(), e.tagKey)}
+			enc, _ = generatedEncoderFallbackCache.LoadOrStore(key, encoderFunc(pe.encode))
+		}
+		enc.(encoderFunc)(e, k, p)
+		return
+	}
+
+	b, err := p.Interface().(generatedEncoder).EncodeKSPACK((*genEncoder)(e), e.data[:e.off], k)
+	if err != nil {
+		panic(err)
+	}
+	e.data, e.off = b[:cap(b)], len(b)
+}
+
+// genEncoder is the encodeState seen by generated code as an
+// internal.Encoder. The generated methods append to the buffer they are
+// passed, which the encodeState takes back once they return.
+type genEncoder encodeState
+
+// Enter tracks x as the pointer encoders do past
+// startDetectingCyclesAfter, but returns the cycle error.
+func (g *genEncoder) Enter(x interface{}) error {
+	e := (*encodeState)(g)
+	if e.ptrLevel++; e.ptrLevel > startDetectingCyclesAfter {
+		if _, ok := e.ptrSeen[x]; ok {
+			e.ptrLevel--
+			return cycleError(x, reflect.ValueOf(x))
+		}
+		e.track(x)
+	}
+	return nil
+}
+
+func (g *genEncoder) Leave(x interface{}, err error) error {
+	e := (*encodeState)(g)
+	if e.ptrLevel > startDetectingCyclesAfter {
+		delete(e.ptrSeen, x)
+	}
+	e.ptrLevel--
+	if err, ok := err.(*UnsupportedValueError); ok {
+		err.closeCycle(x)
+	}
+	return err
+}
+
+// AppendValue appends the item of v reflectively, within the options
+// and cycle tracking of the encodeState.
+func (g *genEncoder) AppendValue(b []byte, k string, v interface{}) (out []byte, err error) {
+	e := (*encodeState)(g)
+	e.data, e.off = b[:cap(b)], len(b)
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			out, err = b, r.(error)
+		}
+	}()
+	e.reflectValue(k, reflect.ValueOf(v))
+	return e.data[:e.off], nil
+}
+
+// generatedDecoder is implemented by the pointers to the struct types
+// cmd/kspackgen generates methods for. DecodeKSPACK decodes the item at
+// off within the state of the running decoder d, so that it honours its
+// DecodeOptions and allocation budget.
+type generatedDecoder interface {
+	DecodeKSPACK(d internal.Decoder, off int) error
+}
+
+var generatedFallbackCache sync.Map // map[cacheKey]decoderFunc
+
+// generated decodes the item at d.off into g through its DecodeKSPACK
+// method. The generated methods only know the field names of
+// DefaultTagKey: under another tag key, the struct g points to is
+// decoded reflectively instead.
+func (d *decodeState) generated(g generatedDecoder) {
+	if tagKey := d.tagKey(); tagKey != DefaultTagKey {
+		v := reflect.ValueOf(g).Elem()
+		key := cacheKey{v.Type(), tagKey}
+		dec, ok := generatedFallbackCache.Load(key)
+		if !ok {
+			dec, _ = generatedFallbackCache.LoadOrStore(key, newStructDecoder(v.Type(), tagKey))
+		}
+		dec.(decoderFunc)(d, v)
+		return
+	}
+
+	start := d.off
+	end := start + len(d.next())
+	err := g.DecodeKSPACK((*genDecoder)(d), start)
+	d.off = end
+	switch err := err.(type) {
+	case nil:
+	case *UnmarshalTypeError:
+		if f := d.field(); f != "" {
+			err.Field = joinPath(f, err.Field)
+		}
+		err.Field = d.rootedField(err.Field)
+		d.saveError(err)
+	case *MissingFieldError:
+		d.saveError(err)
+	default:
+		d.error(err)
+	}
+}
+
+// genDecoder is the decodeState seen by generated code as an
+// internal.Decoder. Its methods set d.off to the offset of the item they
+// work on, which errors of the decodeState report; the decodeState
+// moves past the whole item once the generated method returns.
+type genDecoder decodeState
+
+func (g *genDecoder) Data() []byte {
+	return g.data
+}
+
+func (g *genDecoder) DecodeText(off int) (string, bool) {
+	d := (*decodeState)(g)
+	switch d.data[off] {
+	case KSPACK_NULL:
+		return "", true
+	case KSPACK_STRING, KSPACK_SHORT_STRING:
+		v := itemValue(d.data, off)
+		d.off = off
+		return d.text(v[:len(v)-1]), true
+	}
+	return "", false
+}
+
+func (g *genDecoder) DecodeBlob(off int) ([]byte, bool) {
+	d := (*decodeState)(g)
+	switch d.data[off] {
+	case KSPACK_NULL:
+		return nil, true
+	case KSPACK_BINARY, KSPACK_SHORT_BINARY:
+		d.off = off
+		return d.blob(itemValue(d.data, off)), true
+	}
+	return nil, false
+}
+
+func (g *genDecoder) Key(key []byte, off int) string {
+	d := (*decodeState)(g)
+	d.off = off
+	return d.text(key)
+}
+
+func (g *genDecoder) Alloc(off, n int) {
+	d := (*decodeState)(g)
+	d.off = off
+	d.alloc(n)
+}
+
+func (g *genDecoder) UnknownField(t reflect.Type, key []byte, off int) {
+	(*decodeState)(g).unknownField(t, key, off)
+}
+
+// UnmarshalItem decodes the item at off into v reflectively. The first
+// type error is returned, with a field path relative to v.
+func (g *genDecoder) UnmarshalItem(off int, v interface{}) error {
+	d := (*decodeState)(g)
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(v)}
+	}
+	path, root, saved := d.path, d.root, d.savedError
+	d.path, d.root, d.savedError = d.path[len(d.path):], "", nil
+	d.off = off
+	d.value(rv)
+	err := d.savedError
+	d.path, d.root, d.savedError = path, root, saved
+	return err
+}
+
+// itemTypeError returns the *UnmarshalTypeError of the item at off that
+// does not fit a Go value of type t.
+func itemTypeError(data []byte, off int, t reflect.Type) error {
+	what := typeName(data[off])
+	v := itemValue(data, off)
+	switch data[off] {
+	case KSPACK_INT8, KSPACK_INT16, KSPACK_INT32, KSPACK_INT64:
+		if n, _ := decodeInt(data, off, 64); kindIn(t, reflect.Int, reflect.Int64) {
+			what += " " + strconv.FormatInt(n, 10)
+		}
+	case KSPACK_UINT8, KSPACK_UINT16, KSPACK_UINT32, KSPACK_UINT64:
+		if n, _ := decodeUint(data, off, 64); kindIn(t, reflect.Uint, reflect.Uintptr) {
+			what += " " + strconv.FormatUint(n, 10)
+		}
+	case KSPACK_DOUBLE:
+		if t.Kind() == reflect.Float32 {
+			what += " " + strconv.FormatFloat(Float64(v), 'g', -1, 64)
+		}
+	}
+	return &UnmarshalTypeError{Value: what, Type: t, Offset: int64(off)}
+}
+
+// kindIn reports whether the kind of t lies in [lo, hi].
+func kindIn(t reflect.Type, lo, hi reflect.Kind) bool {
+	return lo <= t.Kind() && t.Kind() <= hi
+}
+
+// errorAt prefixes the path of the type error or unsupported type or
+// value error err, raised for the element elem of a value, with elem.
+// It returns err.
+func errorAt(err error, elem string) error {
+	switch err := err.(type) {
+	case *UnmarshalTypeError:
+		err.Field = joinPath(elem, err.Field)
+	case *UnsupportedTypeError:
+		err.Path = joinPath(elem, err.Path)
+	case *UnsupportedValueError:
+		err.at(elem)
+	}
+	return err
+}
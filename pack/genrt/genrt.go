@@ -0,0 +1,298 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package genrt is the runtime of the EncodeKSPACK and DecodeKSPACK
+// methods generated by cmd/kspackgen. Its functions write and read the
+// items of pack.Marshal and pack.Unmarshal without going through
+// reflect. They are not meant to be called by hand, and may change with
+// the generator.
+//
+// The append functions write an item under the key k at the end of b
+// and return the extended buffer. k must be at most
+// pack.KSPACK_KEY_MAX_LEN bytes long, see CheckKey. The generated
+// EncodeKSPACK methods run within the Encoder of a pack.Marshal call,
+// which tracks the structs they enter to detect cycles.
+//
+// The decode functions read the item at offset off of data, which
+// pack.Unmarshal has checked first. A NULL item decodes to the zero value.
+// The ones reporting a bool return false if the item does not fit the
+// requested Go type. The generated DecodeKSPACK methods run within the
+// Decoder of a pack.Unmarshal call, whose DecodeOptions and allocation
+// budget the functions taking it honour.
+package genrt
+
+import (
+	"reflect"
+	"time"
+
+	// sets the functions of internal
+	_ "github.com/kubeservice-stack/kspack-go/pack"
+	"github.com/kubeservice-stack/kspack-go/pack/internal"
+)
+
+// CheckKey returns the error pack.Marshal reports for an object key k
+// that is too long.
+func CheckKey(k string) error {
+	return internal.CheckKey(k)
+}
+
+// AppendNull appends a NULL item.
+func AppendNull(b []byte, k string) []byte {
+	return internal.AppendNull(b, k)
+}
+
+// AppendBool appends a BOOL item.
+func AppendBool(b []byte, k string, v bool) []byte {
+	return internal.AppendBool(b, k, v)
+}
+
+// AppendInt8 appends an INT8 item.
+func AppendInt8(b []byte, k string, v int8) []byte {
+	return internal.AppendInt8(b, k, v)
+}
+
+// AppendInt16 appends an INT16 item.
+func AppendInt16(b []byte, k string, v int16) []byte {
+	return internal.AppendInt16(b, k, v)
+}
+
+// AppendInt32 appends an INT32 item.
+func AppendInt32(b []byte, k string, v int32) []byte {
+	return internal.AppendInt32(b, k, v)
+}
+
+// AppendInt64 appends an INT64 item, which int values are written as.
+func AppendInt64(b []byte, k string, v int64) []byte {
+	return internal.AppendInt64(b, k, v)
+}
+
+// AppendUint8 appends a UINT8 item.
+func AppendUint8(b []byte, k string, v uint8) []byte {
+	return internal.AppendUint8(b, k, v)
+}
+
+// AppendUint16 appends a UINT16 item.
+func AppendUint16(b []byte, k string, v uint16) []byte {
+	return internal.AppendUint16(b, k, v)
+}
+
+// AppendUint32 appends a UINT32 item.
+func AppendUint32(b []byte, k string, v uint32) []byte {
+	return internal.AppendUint32(b, k, v)
+}
+
+// AppendUint64 appends a UINT64 item, which uint and uintptr values
+// are written as.
+func AppendUint64(b []byte, k string, v uint64) []byte {
+	return internal.AppendUint64(b, k, v)
+}
+
+// AppendFloat32 appends a FLOAT item.
+func AppendFloat32(b []byte, k string, v float32) []byte {
+	return internal.AppendFloat32(b, k, v)
+}
+
+// AppendFloat64 appends a DOUBLE item.
+func AppendFloat64(b []byte, k string, v float64) []byte {
+	return internal.AppendFloat64(b, k, v)
+}
+
+// AppendString appends a STRING or SHORT_STRING item.
+func AppendString(b []byte, k string, v string) []byte {
+	return internal.AppendString(b, k, v)
+}
+
+// AppendBinary appends a BINARY or SHORT_BINARY item.
+func AppendBinary(b []byte, k string, v []byte) []byte {
+	return internal.AppendBinary(b, k, v)
+}
+
+// AppendTime appends a DATE item if t is in UTC, else a ZONED_DATE item.
+// It fails if t is out of DATE range.
+func AppendTime(b []byte, k string, t time.Time) ([]byte, error) {
+	return internal.AppendTime(b, k, t)
+}
+
+// An Encoder is the state of the pack.Marshal call a generated
+// EncodeKSPACK method runs within.
+type Encoder = internal.Encoder
+
+// Enter records that e is within the struct x points to, as the
+// pointers of pack.Marshal are tracked past its nesting threshold. It
+// returns the *pack.UnsupportedValueError of a cycle if e already was.
+// Each successful Enter is paired with a Leave.
+func Enter(e Encoder, x interface{}) error {
+	return e.Enter(x)
+}
+
+// Leave undoes Enter once x is encoded with the error err, which it
+// returns. The error of a cycle starting from x gets the path of the
+// cycle.
+func Leave(e Encoder, x interface{}, err error) error {
+	return e.Leave(x, err)
+}
+
+// AppendValue appends the item pack.Marshal writes for v within e. It
+// is the fallback for the values that generated code does not encode
+// itself, such as interfaces and the types with marshaling methods.
+func AppendValue(e Encoder, b []byte, k string, v interface{}) ([]byte, error) {
+	return e.AppendValue(b, k, v)
+}
+
+// BeginObject appends the header of an OBJECT item and returns the
+// offset of the item, to be passed to EndContainer once its members are
+// appended.
+func BeginObject(b []byte, k string) ([]byte, int) {
+	return internal.BeginObject(b, k)
+}
+
+// BeginArray appends the header of an ARRAY item and returns the offset
+// of the item, to be passed to EndContainer once its elements are
+// appended.
+func BeginArray(b []byte, k string) ([]byte, int) {
+	return internal.BeginArray(b, k)
+}
+
+// EndContainer completes the header of the OBJECT or ARRAY item at
+// offset start of b, holding the n items appended since BeginObject or
+// BeginArray.
+func EndContainer(b []byte, start, n int) []byte {
+	return internal.EndContainer(b, start, n)
+}
+
+// ErrorAt prefixes the path of the type error or unsupported type or
+// value error err, raised for the element elem of a value, with elem.
+// It returns err.
+func ErrorAt(err error, elem string) error {
+	return internal.ErrorAt(err, elem)
+}
+
+// ItemType returns the type code of the item at off, such as
+// pack.KSPACK_OBJECT.
+func ItemType(data []byte, off int) byte {
+	return data[off]
+}
+
+// ItemCount returns the member number of the OBJECT or ARRAY item at off.
+func ItemCount(data []byte, off int) int {
+	return internal.ItemCount(data, off)
+}
+
+// Members calls fn with the key and offset of each member of the OBJECT
+// item at off, in order, and stops at the first error fn returns.
+func Members(data []byte, off int, fn func(key []byte, off int) error) error {
+	return internal.Members(data, off, fn)
+}
+
+// Elements calls fn with the index and offset of each element of the
+// ARRAY item at off, in order, and stops at the first error fn returns.
+func Elements(data []byte, off int, fn func(i, off int) error) error {
+	return internal.Elements(data, off, fn)
+}
+
+// DecodeBool decodes a BOOL item.
+func DecodeBool(data []byte, off int) (bool, bool) {
+	return internal.DecodeBool(data, off)
+}
+
+// DecodeInt decodes a signed integer item into an integer of the given
+// bit size.
+func DecodeInt(data []byte, off int, bits int) (int64, bool) {
+	return internal.DecodeInt(data, off, bits)
+}
+
+// DecodeUint decodes an unsigned integer item into an integer of the
+// given bit size.
+func DecodeUint(data []byte, off int, bits int) (uint64, bool) {
+	return internal.DecodeUint(data, off, bits)
+}
+
+// DecodeFloat decodes a FLOAT or DOUBLE item into a float of the given
+// bit size.
+func DecodeFloat(data []byte, off int, bits int) (float64, bool) {
+	return internal.DecodeFloat(data, off, bits)
+}
+
+// DecodeTime decodes a DATE or ZONED_DATE item into t, or a string or
+// binary item through its UnmarshalText or UnmarshalBinary method.
+func DecodeTime(data []byte, off int, t *time.Time) error {
+	return internal.DecodeTime(data, off, t)
+}
+
+// DecodeQuoted returns the text of the STRING or SHORT_STRING item at
+// off, holding the value of a field tagged ",string". It returns false
+// for other items, to be decoded as the field type.
+func DecodeQuoted(data []byte, off int) (string, bool) {
+	return internal.DecodeQuoted(data, off)
+}
+
+// QuotedError returns the error of a string s at off that does not
+// parse as a value of the ",string" field type t.
+func QuotedError(s string, off int, t reflect.Type) error {
+	return internal.QuotedError(s, off, t)
+}
+
+// TypeError returns the *pack.UnmarshalTypeError of the item at off that
+// does not fit a Go value of type t.
+func TypeError(data []byte, off int, t reflect.Type) error {
+	return internal.TypeError(data, off, t)
+}
+
+// A Decoder is the state of the pack.Unmarshal call a generated
+// DecodeKSPACK method runs within. Exceeded limits and, under
+// DisallowUnknownFields, unknown fields abort the call as a whole by
+// panicking to its enclosing decoder, as within pack.
+type Decoder = internal.Decoder
+
+// DecodeText decodes a STRING or SHORT_STRING item as d stores strings:
+// aliasing the input under ZeroCopy, else copied within
+// MaxTotalAllocation.
+func DecodeText(d Decoder, off int) (string, bool) {
+	return d.DecodeText(off)
+}
+
+// DecodeBlob decodes a BINARY or SHORT_BINARY item as d stores []byte
+// values: aliasing the input under ZeroCopy, else copied within
+// MaxTotalAllocation.
+func DecodeBlob(d Decoder, off int) ([]byte, bool) {
+	return d.DecodeBlob(off)
+}
+
+// Key returns the key of the object member at off as d stores string
+// map keys.
+func Key(d Decoder, key []byte, off int) string {
+	return d.Key(key, off)
+}
+
+// Alloc accounts for n bytes about to be allocated for the item at off
+// against the MaxTotalAllocation of d.
+func Alloc(d Decoder, off, n int) {
+	d.Alloc(off, n)
+}
+
+// UnknownField reports to d the object member at off whose key matches
+// no field of the struct type t.
+func UnknownField(d Decoder, t reflect.Type, key []byte, off int) {
+	d.UnknownField(t, key, off)
+}
+
+// UnmarshalItem decodes the item at off into v as pack.Unmarshal does
+// within d, including its Lenient conversions. It is the fallback for
+// the values and items that generated code does not decode itself. The
+// field path of the error returned is relative to v.
+func UnmarshalItem(d Decoder, off int, v interface{}) error {
+	return d.UnmarshalItem(off, v)
+}
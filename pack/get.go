@@ -0,0 +1,337 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	int64Type   = reflect.TypeOf(int64(0))
+	uint64Type  = reflect.TypeOf(uint64(0))
+	float64Type = reflect.TypeOf(float64(0))
+	boolType    = reflect.TypeOf(false)
+	bytesType   = reflect.TypeOf([]byte(nil))
+)
+
+// Get returns the item of data found by following path from the root
+// item. A string element selects the member of an OBJECT with that key,
+// an int element the element of an ARRAY at that index:
+//
+//	v, err := pack.Get(data, "items", 0, "sku")
+//
+// Get does not decode data: it skips the items off the path by their
+// content length, checking only the items it visits, and allocates
+// nothing unless it fails. Objects and arrays written without their
+// content length are walked through. A path that leads to no item fails
+// with a *PathError.
+func Get(data []byte, path ...interface{}) (Value, error) {
+	end, err := skip(data, 0, len(data))
+	if err != nil {
+		return Value{}, err
+	}
+	v := Value{data: data, off: 0, end: end}
+	for i, elem := range path {
+		var (
+			next Value
+			ok   bool
+			msg  string
+		)
+		switch elem := elem.(type) {
+		case string:
+			if v.data[v.off] != KSPACK_OBJECT {
+				msg = typeName(v.data[v.off]) + " is not an object"
+			} else if next, ok, err = v.member(elem); !ok {
+				msg = "no member with key " + strconv.Quote(elem)
+			}
+		case int:
+			if v.data[v.off] != KSPACK_ARRAY {
+				msg = typeName(v.data[v.off]) + " is not an array"
+			} else if next, ok, err = v.element(elem); !ok {
+				msg = "index out of range [" + strconv.Itoa(elem) + "] with length " + strconv.Itoa(v.count())
+			}
+		default:
+			msg = fmt.Sprintf("invalid path element of type %T", elem)
+		}
+		if err != nil {
+			return Value{}, err
+		}
+		if !ok {
+			return Value{}, &PathError{Path: formatPath(path[:i+1]), Msg: msg, Offset: int64(v.off)}
+		}
+		v = next
+	}
+	return v, nil
+}
+
+// skip checks the item at off, which must end by end, and returns the
+// offset just past it. The members of an OBJECT or ARRAY carrying its
+// content length are left to be checked once visited.
+func skip(data []byte, off, end int) (int, error) {
+	if end-off >= 6 && (data[off] == KSPACK_OBJECT || data[off] == KSPACK_ARRAY) && Uint32(data[off+2:]) != 0 {
+		klen := int(data[off+1])
+		n := itemLen(data[off:])
+		if n > end-off {
+			return 0, &SyntaxError{Msg: "item length overruns its parent", Offset: int64(off)}
+		}
+		if klen > 0 && data[off+6+klen-1] != 0 {
+			return 0, &SyntaxError{Msg: "unterminated key", Offset: int64(off)}
+		}
+		if n-6-klen < 4 {
+			return 0, &SyntaxError{Msg: "truncated member number", Offset: int64(off)}
+		}
+		return off + n, nil
+	}
+	s := scanner{}
+	return s.item(data[:end], off, 0)
+}
+
+// A Value is an item found by Get, still encoded in its input.
+type Value struct {
+	data     []byte
+	off, end int
+}
+
+// Type returns the type code of the item, such as KSPACK_INT64.
+func (v Value) Type() byte {
+	return v.data[v.off]
+}
+
+// Key returns the key the item is stored under, or "" if it has none,
+// as the elements of arrays usually do.
+func (v Value) Key() string {
+	return itemKey(v.data[v.off:])
+}
+
+// Offset returns the offset of the first byte of the item in the input
+// it was found in.
+func (v Value) Offset() int {
+	return v.off
+}
+
+// Len returns the member number of an OBJECT or ARRAY item, and 0 for
+// other items.
+func (v Value) Len() int {
+	if t := v.data[v.off]; t != KSPACK_OBJECT && t != KSPACK_ARRAY {
+		return 0
+	}
+	return v.count()
+}
+
+// Range calls fn with the index and the item of each member of an
+// OBJECT or ARRAY item, in order, until fn returns false. Members are
+// checked as Get checks the items of its path, the first malformed one
+// ending Range with its error. Range does nothing for other items.
+func (v Value) Range(fn func(i int, m Value) bool) error {
+	if t := v.data[v.off]; t != KSPACK_OBJECT && t != KSPACK_ARRAY {
+		return nil
+	}
+	i := 0
+	return v.walk(func(off, end int) bool {
+		ok := fn(i, Value{data: v.data, off: off, end: end})
+		i++
+		return ok
+	})
+}
+
+// Raw returns the encoded item, its key included, as stored in the
+// input. It can be passed to Unmarshal.
+func (v Value) Raw() []byte {
+	return v.data[v.off:v.end:v.end]
+}
+
+// Int64 returns the value of an INT8, INT16, INT32 or INT64 item, or 0
+// for a NULL item.
+func (v Value) Int64() (int64, error) {
+	n, ok := decodeInt(v.data, v.off, 64)
+	if !ok {
+		return 0, itemTypeError(v.data, v.off, int64Type)
+	}
+	return n, nil
+}
+
+// Uint64 returns the value of a UINT8, UINT16, UINT32 or UINT64 item,
+// or 0 for a NULL item.
+func (v Value) Uint64() (uint64, error) {
+	n, ok := decodeUint(v.data, v.off, 64)
+	if !ok {
+		return 0, itemTypeError(v.data, v.off, uint64Type)
+	}
+	return n, nil
+}
+
+// Float64 returns the value of a FLOAT or DOUBLE item, or 0 for a NULL
+// item.
+func (v Value) Float64() (float64, error) {
+	f, ok := decodeFloat(v.data, v.off, 64)
+	if !ok {
+		return 0, itemTypeError(v.data, v.off, float64Type)
+	}
+	return f, nil
+}
+
+// Bool returns the value of a BOOL item, or false for a NULL item.
+func (v Value) Bool() (bool, error) {
+	b, ok := decodeBool(v.data, v.off)
+	if !ok {
+		return false, itemTypeError(v.data, v.off, boolType)
+	}
+	return b, nil
+}
+
+// String returns the value of a STRING or SHORT_STRING item, or "" for
+// a NULL item.
+func (v Value) String() (string, error) {
+	s, ok := decodeString(v.data, v.off)
+	if !ok {
+		return "", itemTypeError(v.data, v.off, stringType)
+	}
+	return s, nil
+}
+
+// Time returns the value of a DATE or ZONED_DATE item, or the zero time
+// for a NULL item.
+func (v Value) Time() (time.Time, error) {
+	switch v.data[v.off] {
+	case KSPACK_NULL, KSPACK_DATE, KSPACK_ZONED_DATE:
+		var t time.Time
+		err := decodeTime(v.data, v.off, &t)
+		return t, err
+	}
+	return time.Time{}, itemTypeError(v.data, v.off, timeType)
+}
+
+// Bytes returns the content of a BINARY or SHORT_BINARY item, or nil
+// for a NULL item. The content is not copied: it aliases the input.
+func (v Value) Bytes() ([]byte, error) {
+	switch v.data[v.off] {
+	case KSPACK_NULL:
+		return nil, nil
+	case KSPACK_BINARY, KSPACK_SHORT_BINARY:
+		b := itemValue(v.data, v.off)
+		return b[:len(b):len(b)], nil
+	}
+	return nil, itemTypeError(v.data, v.off, bytesType)
+}
+
+// Interface decodes the item as Unmarshal does into an interface{}
+// value. Errors report offsets in the input the item was found in.
+func (v Value) Interface() (interface{}, error) {
+	var x interface{}
+	d := newDecodeState()
+	defer d.release()
+
+	d.init(v.data[:v.end])
+	d.off = v.off
+	err := d.unmarshal(&x)
+	return x, err
+}
+
+// member returns the member of v with key k. It reports false if v is
+// not an OBJECT or has no such member.
+func (v Value) member(k string) (Value, bool, error) {
+	if v.data[v.off] != KSPACK_OBJECT {
+		return Value{}, false, nil
+	}
+	var found Value
+	err := v.walk(func(off, end int) bool {
+		if klen := int(v.data[off+1]); klen > 0 {
+			start := off + itemHeaderLen(v.data[off])
+			if string(v.data[start:start+klen-1]) == k {
+				found = Value{data: v.data, off: off, end: end}
+				return false
+			}
+		}
+		return true
+	})
+	return found, found.data != nil, err
+}
+
+// element returns the element of v at index i. It reports false if v
+// is not an ARRAY or i is out of range.
+func (v Value) element(i int) (Value, bool, error) {
+	if v.data[v.off] != KSPACK_ARRAY || i < 0 || i >= v.count() {
+		return Value{}, false, nil
+	}
+	var found Value
+	j := 0
+	err := v.walk(func(off, end int) bool {
+		if j == i {
+			found = Value{data: v.data, off: off, end: end}
+			return false
+		}
+		j++
+		return true
+	})
+	return found, found.data != nil, err
+}
+
+// walk calls fn with the bounds of each member of the OBJECT or ARRAY
+// v, in order, until fn returns false.
+func (v Value) walk(fn func(off, end int) bool) error {
+	p := v.off + itemHeaderLen(v.data[v.off]) + int(v.data[v.off+1]) + 4
+	for i, n := 0, v.count(); i < n; i++ {
+		end, err := skip(v.data, p, v.end)
+		if err != nil {
+			return err
+		}
+		if !fn(p, end) {
+			return nil
+		}
+		p = end
+	}
+	return nil
+}
+
+// count returns the member number of the OBJECT or ARRAY v, which may
+// have been written without its content length.
+func (v Value) count() int {
+	return int(Uint32(v.data[v.off+itemHeaderLen(v.data[v.off])+int(v.data[v.off+1]):]))
+}
+
+// A PathError is returned by Get when an element of the path selects no
+// item.
+type PathError struct {
+	Path   string // path up to the failing element, e.g. "items[3]"
+	Msg    string // description of error
+	Offset int64  // offset of the item the element was applied to
+}
+
+func (e *PathError) Error() string {
+	return "kspack: " + e.Path + ": " + e.Msg
+}
+
+// formatPath writes path as in field paths of errors, e.g. "items[3].sku".
+func formatPath(path []interface{}) string {
+	var b strings.Builder
+	for _, elem := range path {
+		switch elem := elem.(type) {
+		case string:
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(elem)
+		default:
+			fmt.Fprintf(&b, "[%v]", elem)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type getHeader struct {
+	TraceID string `json:"trace_id"`
+	Hops    uint8  `json:"hops"`
+}
+
+type getItem struct {
+	Sku   string  `json:"sku"`
+	Qty   int32   `json:"qty"`
+	Price float64 `json:"price"`
+}
+
+type getMessage struct {
+	Header getHeader `json:"header"`
+	Items  []getItem `json:"items"`
+	Body   []byte    `json:"body"`
+	Note   *string   `json:"note"`
+	Paid   bool      `json:"paid"`
+}
+
+func getSample() []byte {
+	b, err := Marshal(getMessage{
+		Header: getHeader{TraceID: "t-1", Hops: 3},
+		Items:  []getItem{{"a", 1, 0.5}, {"b", 5, 2}},
+		Body:   []byte{0xca, 0xfe},
+		Paid:   true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestGet(t *testing.T) {
+	assert := assert.New(t)
+
+	data := getSample()
+
+	v, err := Get(data, "header", "trace_id")
+	assert.NoError(err)
+	assert.Equal(byte(KSPACK_SHORT_STRING), v.Type())
+	s, err := v.String()
+	assert.NoError(err)
+	assert.Equal("t-1", s)
+
+	v, err = Get(data, "header", "hops")
+	assert.NoError(err)
+	u, err := v.Uint64()
+	assert.NoError(err)
+	assert.Equal(uint64(3), u)
+
+	v, err = Get(data, "items", 1, "qty")
+	assert.NoError(err)
+	n, err := v.Int64()
+	assert.NoError(err)
+	assert.Equal(int64(5), n)
+
+	v, err = Get(data, "items", 1, "price")
+	assert.NoError(err)
+	f, err := v.Float64()
+	assert.NoError(err)
+	assert.Equal(2.0, f)
+
+	v, err = Get(data, "paid")
+	assert.NoError(err)
+	ok, err := v.Bool()
+	assert.NoError(err)
+	assert.True(ok)
+
+	v, err = Get(data, "body")
+	assert.NoError(err)
+	b, err := v.Bytes()
+	assert.NoError(err)
+	assert.Equal([]byte{0xca, 0xfe}, b)
+	assert.Equal(len(b), cap(b), "capped")
+
+	// NULL reads as the zero value
+	v, err = Get(data, "note")
+	assert.NoError(err)
+	s, err = v.String()
+	assert.NoError(err)
+	assert.Equal("", s)
+
+	// the whole item, key included, decodes on its own
+	v, err = Get(data, "items", 0)
+	assert.NoError(err)
+	var item getItem
+	assert.NoError(Unmarshal(v.Raw(), &item))
+	assert.Equal(getItem{"a", 1, 0.5}, item)
+
+	v, err = Get(data)
+	assert.NoError(err)
+	assert.Equal(data, v.Raw())
+
+	allocs := testing.AllocsPerRun(100, func() {
+		v, _ = Get(data, "items", 1, "qty")
+		n, _ = v.Int64()
+		v, _ = Get(data, "body")
+		b, _ = v.Bytes()
+	})
+	assert.Zero(allocs)
+}
+
+func TestValueRange(t *testing.T) {
+	assert := assert.New(t)
+
+	data := getSample()
+	assert.NoError(Valid(data))
+
+	v, err := Get(data)
+	assert.NoError(err)
+	assert.Equal(5, v.Len())
+	assert.Equal(0, v.Offset())
+	var keys []string
+	assert.NoError(v.Range(func(i int, m Value) bool {
+		assert.Equal(len(keys), i)
+		keys = append(keys, m.Key())
+		return true
+	}))
+	assert.Equal([]string{"header", "items", "body", "note", "paid"}, keys)
+
+	// the members are the items Get finds, and Range stops on false
+	items, err := Get(data, "items")
+	assert.NoError(err)
+	assert.Equal("items", items.Key())
+	n := 0
+	assert.NoError(items.Range(func(i int, m Value) bool {
+		want, err := Get(data, "items", i)
+		assert.NoError(err)
+		assert.Equal(want, m)
+		assert.Equal(want.Offset(), m.Offset())
+		assert.Equal("", m.Key())
+		n++
+		return false
+	}))
+	assert.Equal(1, n)
+
+	paid, err := Get(data, "paid")
+	assert.NoError(err)
+	assert.Equal(0, paid.Len())
+	assert.NoError(paid.Range(func(int, Value) bool {
+		t.Fatal("not a container")
+		return false
+	}))
+
+	when := time.Date(2023, 5, 6, 7, 8, 9, 0, time.UTC)
+	date, err := Marshal(map[string]interface{}{"at": when, "none": nil, "n": 1})
+	assert.NoError(err)
+	v, err = Get(date, "at")
+	assert.NoError(err)
+	at, err := v.Time()
+	assert.NoError(err)
+	assert.True(when.Equal(at))
+	v, err = Get(date, "none")
+	assert.NoError(err)
+	at, err = v.Time()
+	assert.NoError(err)
+	assert.True(at.IsZero())
+	v, err = Get(date, "n")
+	assert.NoError(err)
+	_, err = v.Time()
+	assert.IsType(&UnmarshalTypeError{}, err)
+
+	// malformed members end Range with their error
+	body, err := Get(data, "body")
+	assert.NoError(err)
+	broken := append([]byte(nil), data...)
+	broken[body.off+2] = 0xff // body length
+	assert.IsType(&SyntaxError{}, Valid(broken))
+	v, err = Get(broken)
+	assert.NoError(err)
+	err = v.Range(func(int, Value) bool { return true })
+	assert.EqualError(err, fmt.Sprintf("kspack: item length overruns its parent at offset %d", body.off))
+}
+
+func TestGetUnsized(t *testing.T) {
+	assert := assert.New(t)
+
+	// objects and arrays without their content length
+	data, _ := beginObject(nil, "")
+	data, arr := beginArray(data, "a")
+	data = appendInt8(data, "", 1)
+	data = appendString(data, "", "two")
+	data = endContainer(data, arr, 2)
+	data = appendInt8(data, "b", 3)
+	data = endContainer(data, 0, 2)
+	for _, off := range []int{0, arr} {
+		copy(data[off+2:], []byte{0, 0, 0, 0})
+	}
+
+	v, err := Get(data, "a", 1)
+	assert.NoError(err)
+	s, err := v.String()
+	assert.NoError(err)
+	assert.Equal("two", s)
+
+	v, err = Get(data, "b")
+	assert.NoError(err)
+	n, err := v.Int64()
+	assert.NoError(err)
+	assert.Equal(int64(3), n)
+}
+
+func TestGetErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	data := getSample()
+
+	for _, tt := range []struct {
+		path []interface{}
+		err  string
+	}{
+		{[]interface{}{"header", "span_id"}, `kspack: header.span_id: no member with key "span_id"`},
+		{[]interface{}{"items", 2}, `kspack: items[2]: index out of range [2] with length 2`},
+		{[]interface{}{"items", -1}, `kspack: items[-1]: index out of range [-1] with length 2`},
+		{[]interface{}{"items", "sku"}, `kspack: items.sku: array is not an object`},
+		{[]interface{}{"header", 0}, `kspack: header[0]: object is not an array`},
+		{[]interface{}{"paid", "x"}, `kspack: paid.x: bool is not an object`},
+		{[]interface{}{"items", 1.5}, `kspack: items[1.5]: invalid path element of type float64`},
+	} {
+		_, err := Get(data, tt.path...)
+		if assert.IsType(&PathError{}, err, "%v", tt.path) {
+			assert.EqualError(err, tt.err)
+		}
+	}
+
+	v, err := Get(data, "header", "trace_id")
+	assert.NoError(err)
+	_, err = v.Int64()
+	assert.EqualError(err, fmt.Sprintf("kspack: cannot unmarshal string at offset %d into Go value of type int64", v.off))
+	_, err = v.Bytes()
+	assert.IsType(&UnmarshalTypeError{}, err)
+
+	// only the items on the path are checked, and never overrun
+	_, err = Get(data[:len(data)-1])
+	assert.IsType(&SyntaxError{}, err)
+	_, err = Get(nil)
+	assert.IsType(&SyntaxError{}, err)
+	body, err := Get(data, "body")
+	assert.NoError(err)
+	broken := append([]byte(nil), data...)
+	broken[body.off+2] = 0xff // body length
+	_, err = Get(broken, "header", "hops")
+	assert.NoError(err)
+	_, err = Get(broken, "note")
+	assert.EqualError(err, fmt.Sprintf("kspack: item length overruns its parent at offset %d", body.off))
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package internal bridges package pack and package genrt, the runtime
+// of the code generated by cmd/kspackgen, which needs the unexported
+// encoder and decoder of pack. Its functions are set by pack when it is
+// initialized.
+package internal
+
+import (
+	"reflect"
+	"time"
+)
+
+// The encoding functions.
+var (
+	AppendNull    func(b []byte, k string) []byte
+	AppendBool    func(b []byte, k string, v bool) []byte
+	AppendInt8    func(b []byte, k string, v int8) []byte
+	AppendInt16   func(b []byte, k string, v int16) []byte
+	AppendInt32   func(b []byte, k string, v int32) []byte
+	AppendInt64   func(b []byte, k string, v int64) []byte
+	AppendUint8   func(b []byte, k string, v uint8) []byte
+	AppendUint16  func(b []byte, k string, v uint16) []byte
+	AppendUint32  func(b []byte, k string, v uint32) []byte
+	AppendUint64  func(b []byte, k string, v uint64) []byte
+	AppendFloat32 func(b []byte, k string, v float32) []byte
+	AppendFloat64 func(b []byte, k string, v float64) []byte
+	AppendString  func(b []byte, k string, v string) []byte
+	AppendBinary  func(b []byte, k string, v []byte) []byte
+	AppendTime    func(b []byte, k string, t time.Time) ([]byte, error)
+	BeginObject   func(b []byte, k string) ([]byte, int)
+	BeginArray    func(b []byte, k string) ([]byte, int)
+	EndContainer  func(b []byte, start, n int) []byte
+	CheckKey      func(k string) error
+	ErrorAt       func(err error, elem string) error
+)
+
+// The decoding functions.
+var (
+	ItemCount    func(data []byte, off int) int
+	Members      func(data []byte, off int, fn func(key []byte, off int) error) error
+	Elements     func(data []byte, off int, fn func(i, off int) error) error
+	DecodeBool   func(data []byte, off int) (bool, bool)
+	DecodeInt    func(data []byte, off int, bits int) (int64, bool)
+	DecodeUint   func(data []byte, off int, bits int) (uint64, bool)
+	DecodeFloat  func(data []byte, off int, bits int) (float64, bool)
+	DecodeTime   func(data []byte, off int, t *time.Time) error
+	DecodeQuoted func(data []byte, off int) (string, bool)
+	QuotedError  func(s string, off int, t reflect.Type) error
+	TypeError    func(data []byte, off int, t reflect.Type) error
+)
+
+// An Encoder is the state of the pack.Marshal call the generated
+// EncodeKSPACK methods run within.
+type Encoder interface {
+	// Enter records that the encoder is within the struct x points to,
+	// and fails with an *UnsupportedValueError if it already was.
+	Enter(x interface{}) error
+	// Leave undoes Enter once x is encoded with the error err, and
+	// returns err.
+	Leave(x interface{}, err error) error
+	// AppendValue appends the item of v reflectively.
+	AppendValue(b []byte, k string, v interface{}) ([]byte, error)
+}
+
+// A Decoder is the state of the pack.Unmarshal call the generated
+// DecodeKSPACK methods run within. The methods honour its DecodeOptions
+// and allocation budget; the errors of the call as a whole, such as an
+// exceeded limit, panic to the enclosing decoder as within pack.
+type Decoder interface {
+	// Data returns the input.
+	Data() []byte
+	// DecodeText decodes a STRING or SHORT_STRING item, aliasing the
+	// input under ZeroCopy.
+	DecodeText(off int) (string, bool)
+	// DecodeBlob decodes a BINARY or SHORT_BINARY item, aliasing the
+	// input under ZeroCopy.
+	DecodeBlob(off int) ([]byte, bool)
+	// Key returns the object key of the member at off as a string map
+	// key, aliasing the input under ZeroCopy.
+	Key(key []byte, off int) string
+	// Alloc accounts for n bytes about to be allocated for the item at
+	// off.
+	Alloc(off, n int)
+	// UnknownField reports the member at off whose key matches no
+	// field of the struct type t.
+	UnknownField(t reflect.Type, key []byte, off int)
+	// UnmarshalItem decodes the item at off into v reflectively.
+	UnmarshalItem(off int, v interface{}) error
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// itemHeaderLen returns the number of bytes preceding the raw name of an
+// item of type typ, or 0 if typ is not a known type code.
+//
+//	fixed item:    type(1) | name length(1)
+//	short item:    type(1) | name length(1) | content length(1)
+//	variable item: type(1) | name length(1) | content length(4)
+func itemHeaderLen(typ byte) int {
+	switch typ {
+	case KSPACK_INT8, KSPACK_INT16, KSPACK_INT32, KSPACK_INT64,
+		KSPACK_UINT8, KSPACK_UINT16, KSPACK_UINT32, KSPACK_UINT64,
+		KSPACK_BOOL, KSPACK_FLOAT, KSPACK_DOUBLE, KSPACK_DATE, KSPACK_NULL:
+		return 2 // type + klen
+	case KSPACK_SHORT_BINARY, KSPACK_SHORT_STRING, KSPACK_ZONED_DATE:
+		return 3 // type + klen + vlen(1)
+	case KSPACK_BINARY, KSPACK_STRING, KSPACK_OBJECT, KSPACK_ARRAY:
+		return 6 // type + klen + vlen(4)
+	}
+	return 0
+}
+
+// typeName returns a readable name for the item type code typ.
+func typeName(typ byte) string {
+	switch typ {
+	case KSPACK_OBJECT:
+		return "object"
+	case KSPACK_ARRAY:
+		return "array"
+	case KSPACK_STRING, KSPACK_SHORT_STRING:
+		return "string"
+	case KSPACK_BINARY, KSPACK_SHORT_BINARY:
+		return "binary"
+	case KSPACK_INT8:
+		return "int8"
+	case KSPACK_INT16:
+		return "int16"
+	case KSPACK_INT32:
+		return "int32"
+	case KSPACK_INT64:
+		return "int64"
+	case KSPACK_UINT8:
+		return "uint8"
+	case KSPACK_UINT16:
+		return "uint16"
+	case KSPACK_UINT32:
+		return "uint32"
+	case KSPACK_UINT64:
+		return "uint64"
+	case KSPACK_BOOL:
+		return "bool"
+	case KSPACK_FLOAT:
+		return "float"
+	case KSPACK_DOUBLE:
+		return "double"
+	case KSPACK_DATE, KSPACK_ZONED_DATE:
+		return "date"
+	case KSPACK_NULL:
+		return "null"
+	}
+	return fmt.Sprintf("type 0x%02x", typ)
+}
+
+// fixedItemLen returns the content length of a fixed size item of type typ.
+func fixedItemLen(typ byte) int {
+	switch typ {
+	case KSPACK_INT8, KSPACK_UINT8, KSPACK_BOOL, KSPACK_NULL:
+		return 1
+	case KSPACK_INT16, KSPACK_UINT16:
+		return 2
+	case KSPACK_INT32, KSPACK_UINT32, KSPACK_FLOAT:
+		return 4
+	case KSPACK_INT64, KSPACK_UINT64, KSPACK_DOUBLE, KSPACK_DATE:
+		return 8
+	}
+	return 0
+}
+
+// itemLen returns the total length of the item starting at data[0],
+// as declared by its header, or -1 if the type code is unknown. data
+// must hold at least itemHeaderLen(data[0]) bytes.
+func itemLen(data []byte) int {
+	h := itemHeaderLen(data[0])
+	switch h {
+	case 2:
+		return h + int(data[1]) + fixedItemLen(data[0])
+	case 3:
+		return h + int(data[1]) + int(data[2])
+	case 6:
+		return h + int(data[1]) + int(Uint32(data[2:]))
+	}
+	return -1
+}
+
+// maxNestingDepth bounds the nesting of objects and arrays, so that a
+// hostile input cannot exhaust the stack of the scanner or the decoder.
+const maxNestingDepth = 10000
+
+// minItemLen is the length of the smallest item, an unnamed INT8, UINT8,
+// BOOL or NULL.
+const minItemLen = 3
+
+// A SyntaxError is a description of a malformed kspack item.
+type SyntaxError struct {
+	Msg    string // description of error
+	Offset int64  // error occurred at this offset in the input
+}
+
+func (e *SyntaxError) Error() string {
+	return "kspack: " + e.Msg + " at offset " + strconv.FormatInt(e.Offset, 10)
+}
+
+// Valid verifies that data holds exactly one item that Unmarshal and
+// Get can read, and returns the *SyntaxError of the first malformed
+// item otherwise. The items of a valid input are not checked again by
+// the methods of the Values Get returns.
+func Valid(data []byte) error {
+	return checkItem(data)
+}
+
+// checkValid verifies that data holds exactly one well-formed item.
+// Unlike the decoder it requires the content length of objects and
+// arrays to match their members, so that the item can be copied into
+// another document as is.
+func checkValid(data []byte) error {
+	s := scanner{strict: true}
+	return s.scan(data)
+}
+
+// A scanner verifies that an input holds exactly one item that can be
+// walked without reading out of bounds and that stays within limits.
+// Unless strict, an object or array declaring a content length of 0 is
+// accepted, its members being then bounded by the enclosing item, and
+// object members may be unnamed.
+type scanner struct {
+	strict bool
+	limits DecodeOptions
+}
+
+func (s *scanner) scan(data []byte) error {
+	end, err := s.item(data, 0, 0)
+	if err != nil {
+		return err
+	}
+	if end != len(data) {
+		return &SyntaxError{Msg: strconv.Itoa(len(data)-end) + " trailing bytes after item", Offset: int64(end)}
+	}
+	return nil
+}
+
+// item walks the item starting at data[off:], nested depth levels deep,
+// and returns the offset just past it.
+func (s *scanner) item(data []byte, off int, depth int) (int, error) {
+	if len(data)-off < 2 {
+		return 0, &SyntaxError{Msg: "unexpected end of input", Offset: int64(off)}
+	}
+	typ := data[off]
+	h := itemHeaderLen(typ)
+	if h == 0 {
+		return 0, &SyntaxError{Msg: fmt.Sprintf("unknown item type 0x%02x", typ), Offset: int64(off)}
+	}
+	if len(data)-off < h {
+		return 0, &SyntaxError{Msg: "unexpected end of input", Offset: int64(off)}
+	}
+	klen := int(data[off+1])
+	vlen := 0
+	switch h {
+	case 2:
+		vlen = fixedItemLen(typ)
+	case 3:
+		vlen = int(data[off+2])
+	case 6:
+		vlen = int(Uint32(data[off+2:]))
+	}
+	start := off + h + klen
+	if start > len(data) || vlen > len(data)-start {
+		return 0, &SyntaxError{Msg: "item length overruns its parent", Offset: int64(off)}
+	}
+	if klen > 0 && data[start-1] != 0 {
+		return 0, &SyntaxError{Msg: "unterminated key", Offset: int64(off)}
+	}
+	end := start + vlen
+	switch typ {
+	case KSPACK_STRING, KSPACK_SHORT_STRING:
+		if vlen == 0 || data[end-1] != 0 {
+			return 0, &SyntaxError{Msg: "unterminated string", Offset: int64(off)}
+		}
+		if max := s.limits.MaxStringLen; max > 0 && vlen-1 > max {
+			return 0, &LimitError{Limit: "MaxStringLen", Max: max, Offset: int64(off)}
+		}
+	case KSPACK_BINARY, KSPACK_SHORT_BINARY:
+		if max := s.limits.MaxBinaryLen; max > 0 && vlen > max {
+			return 0, &LimitError{Limit: "MaxBinaryLen", Max: max, Offset: int64(off)}
+		}
+	case KSPACK_ZONED_DATE:
+		if vlen < 8+4 {
+			return 0, &SyntaxError{Msg: "truncated date", Offset: int64(off)}
+		}
+	}
+	if typ != KSPACK_OBJECT && typ != KSPACK_ARRAY {
+		return end, nil
+	}
+
+	if depth++; depth > maxNestingDepth {
+		return 0, &SyntaxError{Msg: "exceeded max depth", Offset: int64(off)}
+	}
+	if max := s.limits.MaxDepth; max > 0 && depth > max {
+		return 0, &LimitError{Limit: "MaxDepth", Max: max, Offset: int64(off)}
+	}
+	sized := s.strict || vlen != 0
+	if !sized {
+		end = len(data)
+	}
+	if end-start < 4 {
+		return 0, &SyntaxError{Msg: "truncated member number", Offset: int64(off)}
+	}
+	n := int(Uint32(data[start:]))
+	if max := s.limits.MaxElements; max > 0 && n > max {
+		return 0, &LimitError{Limit: "MaxElements", Max: max, Offset: int64(off)}
+	}
+	p := start + 4
+	if n > (end-p)/minItemLen {
+		return 0, &SyntaxError{Msg: "member number overruns its parent", Offset: int64(off)}
+	}
+	for i := 0; i < n; i++ {
+		if s.strict && typ == KSPACK_OBJECT && p+1 < end && data[p+1] == 0 {
+			return 0, &SyntaxError{Msg: "empty key", Offset: int64(p)}
+		}
+		next, err := s.item(data[:end], p, depth)
+		if err != nil {
+			return 0, err
+		}
+		p = next
+	}
+	if sized && p != end {
+		return 0, &SyntaxError{Msg: "content length mismatch", Offset: int64(off)}
+	}
+	return p, nil
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckValid(t *testing.T) {
+	assert := assert.New(t)
+	for _, tt := range marshalTests {
+		if tt.out == nil {
+			continue
+		}
+		assert.Nil(checkValid(tt.out))
+	}
+
+	invalid := [][]byte{
+		nil,
+		{KSPACK_INT32, 0, 1, 0, 0},
+		{0x7f, 0, 1},
+		{KSPACK_SHORT_STRING, 0, 2, 'a', 'b'},
+		{KSPACK_STRING, 0, 0, 0, 0, 0},
+		{KSPACK_BINARY, 0, 0xff, 0xff, 0xff, 0xff, 'a'},
+		{KSPACK_BOOL, 0, 1, KSPACK_BOOL, 0, 1},
+		{KSPACK_OBJECT, 0, 9, 0, 0, 0, 1, 0, 0, 0, KSPACK_BOOL, 0, 1},
+		{KSPACK_ARRAY, 0, 9, 0, 0, 0, 2, 0, 0, 0, KSPACK_BOOL, 0, 1},
+		{KSPACK_ARRAY, 0, 0, 0, 0, 0, 1, 0, 0, 0, KSPACK_BOOL, 0, 1},
+	}
+	for _, b := range invalid {
+		assert.Error(checkValid(b), "%v", b)
+	}
+}
@@ -0,0 +1,653 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ToJSON writes the kspack item src to dst as JSON, without going
+// through Go values. See JSONOptions for the type mapping.
+func ToJSON(dst io.Writer, src []byte) error {
+	return JSONOptions{}.ToJSON(dst, src)
+}
+
+// FromJSON reads the JSON values of src and writes each of them to dst
+// as a kspack item, without going through Go values. See JSONOptions for
+// the type mapping.
+func FromJSON(dst io.Writer, src io.Reader) error {
+	return JSONOptions{}.FromJSON(dst, src)
+}
+
+// JSONOptions configures how ToJSON and FromJSON map kspack types to
+// JSON. The zero value gives the behavior of the ToJSON and FromJSON
+// functions.
+//
+// Objects and arrays map to their JSON counterparts, keeping the order
+// of their members, strings to strings, BOOL items to booleans and NULL
+// items to null. Integers and floats map to numbers. Binaries map to
+// base64 strings and dates to RFC 3339 strings, which FromJSON reads
+// back as strings. The keys of array elements and of the top-level item
+// are dropped.
+type JSONOptions struct {
+	// Typed writes the items that would not be read back as themselves,
+	// integers other than INT64, FLOAT items, non-finite floats,
+	// binaries and dates, as objects holding a single member named
+	// after their type, such as {"$uint16": 7}, {"$float": 1.5},
+	// {"$double": "NaN"}, {"$binary": "AAE="} or
+	// {"$zoned_date": "2023-05-06T07:08:09+02:00"}, and reads such
+	// objects back as the items they describe, so that the items of
+	// the Go encoder round-trip through JSON unchanged. The members of
+	// an object whose only key is one of these names, which Typed
+	// cannot tell apart, should not be relied upon.
+	Typed bool
+
+	// IntType forces the type code FromJSON writes JSON integers as,
+	// from KSPACK_INT8 to KSPACK_UINT64. An integer out of its range is
+	// an error. When zero, integers are written as INT64, or UINT64
+	// beyond its range, as Marshal writes int and uint values, and
+	// integers beyond the range of UINT64 as floats.
+	IntType byte
+
+	// InferIntWidth writes JSON integers as the narrowest of INT8,
+	// INT16, INT32 and INT64 holding them, or UINT64 beyond. It is
+	// ignored if IntType is set.
+	InferIntWidth bool
+
+	// FloatType is the type code FromJSON writes JSON numbers with a
+	// fraction or an exponent as, KSPACK_DOUBLE if zero, or
+	// KSPACK_FLOAT.
+	FloatType byte
+
+	// Base64 is the encoding of binaries, base64.StdEncoding if nil.
+	Base64 *base64.Encoding
+}
+
+// jsonTags maps the names of the typed values of JSONOptions.Typed to
+// the type codes they stand for.
+var jsonTags = map[string]byte{
+	"$int8":       KSPACK_INT8,
+	"$int16":      KSPACK_INT16,
+	"$int32":      KSPACK_INT32,
+	"$int64":      KSPACK_INT64,
+	"$uint8":      KSPACK_UINT8,
+	"$uint16":     KSPACK_UINT16,
+	"$uint32":     KSPACK_UINT32,
+	"$uint64":     KSPACK_UINT64,
+	"$float":      KSPACK_FLOAT,
+	"$double":     KSPACK_DOUBLE,
+	"$binary":     KSPACK_BINARY,
+	"$date":       KSPACK_DATE,
+	"$zoned_date": KSPACK_ZONED_DATE,
+}
+
+// jsonTag returns the name of the typed value of an item of type typ.
+func jsonTag(typ byte) string {
+	if typ == KSPACK_ZONED_DATE {
+		return "$zoned_date"
+	}
+	return "$" + typeName(typ)
+}
+
+func (o JSONOptions) check() error {
+	switch o.IntType {
+	case 0, KSPACK_INT8, KSPACK_INT16, KSPACK_INT32, KSPACK_INT64,
+		KSPACK_UINT8, KSPACK_UINT16, KSPACK_UINT32, KSPACK_UINT64:
+	default:
+		return fmt.Errorf("kspack: invalid JSONOptions.IntType 0x%02x", o.IntType)
+	}
+	switch o.FloatType {
+	case 0, KSPACK_FLOAT, KSPACK_DOUBLE:
+	default:
+		return fmt.Errorf("kspack: invalid JSONOptions.FloatType 0x%02x", o.FloatType)
+	}
+	return nil
+}
+
+func (o JSONOptions) base64() *base64.Encoding {
+	if o.Base64 == nil {
+		return base64.StdEncoding
+	}
+	return o.Base64
+}
+
+// ToJSON writes the kspack item src to dst as JSON according to o.
+func (o JSONOptions) ToJSON(dst io.Writer, src []byte) error {
+	if err := o.check(); err != nil {
+		return err
+	}
+	if err := checkItem(src); err != nil {
+		return err
+	}
+	w := &jsonWriter{w: bufio.NewWriter(dst), opts: o, data: src}
+	if _, err := w.item(0); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// A jsonWriter writes the items of data as JSON.
+type jsonWriter struct {
+	w    *bufio.Writer
+	opts JSONOptions
+	data []byte
+	buf  []byte // scratch space for numbers and strings
+}
+
+// item writes the item at off and returns the offset just past it.
+func (w *jsonWriter) item(off int) (int, error) {
+	typ := w.data[off]
+	if typ == KSPACK_OBJECT || typ == KSPACK_ARRAY {
+		return w.container(off)
+	}
+
+	v := itemValue(w.data, off)
+	end := off + itemLen(w.data[off:])
+	typed := w.opts.Typed
+	switch typ {
+	case KSPACK_INT64, KSPACK_STRING, KSPACK_SHORT_STRING, KSPACK_BOOL, KSPACK_NULL:
+		typed = false
+	case KSPACK_DOUBLE:
+		typed = typed && !isFinite(Float64(v))
+	}
+	if typed {
+		w.w.WriteString(`{"`)
+		w.w.WriteString(jsonTag(typ))
+		w.w.WriteString(`":`)
+	}
+
+	b := w.buf[:0]
+	switch typ {
+	case KSPACK_STRING, KSPACK_SHORT_STRING:
+		b = appendJSONString(b, v[:len(v)-1])
+	case KSPACK_BINARY, KSPACK_SHORT_BINARY:
+		w.w.WriteByte('"')
+		enc := base64.NewEncoder(w.opts.base64(), w.w)
+		enc.Write(v)
+		enc.Close()
+		b = append(b, '"')
+	case KSPACK_INT8, KSPACK_INT16, KSPACK_INT32, KSPACK_INT64:
+		n, _ := decodeInt(w.data, off, 64)
+		b = strconv.AppendInt(b, n, 10)
+	case KSPACK_UINT8, KSPACK_UINT16, KSPACK_UINT32, KSPACK_UINT64:
+		n, _ := decodeUint(w.data, off, 64)
+		b = strconv.AppendUint(b, n, 10)
+	case KSPACK_FLOAT, KSPACK_DOUBLE:
+		bits := 64
+		if typ == KSPACK_FLOAT {
+			bits = 32
+		}
+		f, _ := decodeFloat(w.data, off, bits)
+		switch {
+		case isFinite(f):
+			b = appendJSONFloat(b, f, bits)
+		case typed:
+			b = append(b, '"')
+			b = strconv.AppendFloat(b, f, 'g', -1, bits)
+			b = append(b, '"')
+		default:
+			return 0, fmt.Errorf("kspack: unsupported value %v at offset %d", f, off)
+		}
+	case KSPACK_BOOL:
+		b = strconv.AppendBool(b, v[0] != 0)
+	case KSPACK_DATE, KSPACK_ZONED_DATE:
+		d := decodeState{data: w.data, off: off}
+		var t time.Time
+		if typ == KSPACK_DATE {
+			t = d.dateValue().UTC()
+		} else {
+			t = d.zonedDateValue()
+		}
+		b = append(b, '"')
+		b = t.AppendFormat(b, time.RFC3339Nano)
+		b = append(b, '"')
+	case KSPACK_NULL:
+		b = append(b, "null"...)
+	}
+	if typed {
+		b = append(b, '}')
+	}
+	w.w.Write(b)
+	w.buf = b
+	return end, nil
+}
+
+// type(1) | name length(1) | item size(4) | raw name bytes | 0x00
+// | members number(4) | member1 | ... | memberN
+func (w *jsonWriter) container(off int) (int, error) {
+	typ := w.data[off]
+	open, close := byte('['), byte(']')
+	if typ == KSPACK_OBJECT {
+		open, close = '{', '}'
+	}
+	w.w.WriteByte(open)
+
+	n := itemCount(w.data, off)
+	p := off + itemHeaderLen(typ) + int(w.data[off+1]) + 4
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			w.w.WriteByte(',')
+		}
+		if typ == KSPACK_OBJECT {
+			klen := int(w.data[p+1])
+			if klen == 0 {
+				return 0, &SyntaxError{Msg: "empty key", Offset: int64(p)}
+			}
+			kstart := p + itemHeaderLen(w.data[p])
+			w.buf = appendJSONString(w.buf[:0], w.data[kstart:kstart+klen-1])
+			w.buf = append(w.buf, ':')
+			w.w.Write(w.buf)
+		}
+		var err error
+		if p, err = w.item(p); err != nil {
+			return 0, err
+		}
+	}
+	w.w.WriteByte(close)
+	return p, nil
+}
+
+func isFinite(f float64) bool {
+	return !math.IsInf(f, 0) && !math.IsNaN(f)
+}
+
+// appendJSONFloat appends the finite float f of the given bit size as
+// encoding/json writes it.
+func appendJSONFloat(b []byte, f float64, bits int) []byte {
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) ||
+			bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	b = strconv.AppendFloat(b, f, format, -1, bits)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends s as a JSON string. Invalid UTF-8 is replaced
+// with U+FFFD, as encoding/json does.
+func appendJSONString(b []byte, s []byte) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRune(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 end lines in JavaScript
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
+
+// FromJSON reads the JSON values of src and writes each of them to dst
+// as a kspack item according to o. Each item is written with a single
+// call to dst.Write once its value is read.
+func (o JSONOptions) FromJSON(dst io.Writer, src io.Reader) error {
+	if err := o.check(); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(src)
+	dec.UseNumber()
+	r := &jsonReader{dec: dec, opts: o}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r.e.off = 0
+		if err := r.value("", tok); err != nil {
+			return err
+		}
+		if _, err := dst.Write(r.e.data[:r.e.off]); err != nil {
+			return err
+		}
+	}
+}
+
+// A jsonReader writes the JSON values read from dec as kspack items.
+type jsonReader struct {
+	dec  *json.Decoder
+	opts JSONOptions
+	e    encodeState
+}
+
+// errorf returns an error at the current offset of the JSON input.
+func (r *jsonReader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("kspack: "+format+" at JSON offset %d", append(args, r.dec.InputOffset())...)
+}
+
+// value writes the JSON value starting with tok under the key k. The
+// decoder bounds the nesting of arrays and objects.
+func (r *jsonReader) value(k string, tok json.Token) error {
+	e := &r.e
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '[' {
+			return r.array(k)
+		}
+		return r.object(k)
+	case string:
+		e.string(k, tok)
+	case json.Number:
+		return r.number(k, tok)
+	case bool:
+		e.bool(k, tok)
+	case nil:
+		nilEncoder(e, k, reflect.Value{})
+	}
+	return nil
+}
+
+// type(1) | name length(1) | item size(4) | raw name bytes | 0x00
+// | members number(4) | member1 | ... | memberN
+func (r *jsonReader) begin(typ byte, k string) int {
+	e := &r.e
+	e.resizeIfNeeded(1 + 1 + 4 + len(k) + 1 + 4)
+	start := e.off
+	e.setType(typ)
+	l := e.setKeyLen(k)
+	e.off += 4 // item size
+	e.setKey(k, l)
+	e.off += 4 // members number
+	return start
+}
+
+func (r *jsonReader) array(k string) error {
+	start := r.begin(KSPACK_ARRAY, k)
+	n := 0
+	for {
+		tok, err := r.dec.Token()
+		if err != nil {
+			return err
+		}
+		if tok == json.Delim(']') {
+			break
+		}
+		if err := r.value("", tok); err != nil {
+			return err
+		}
+		n++
+	}
+	endContainer(r.e.data[:r.e.off], start, n)
+	return nil
+}
+
+func (r *jsonReader) object(k string) error {
+	start := -1
+	n := 0
+	for {
+		tok, err := r.dec.Token()
+		if err != nil {
+			return err
+		}
+		if tok == json.Delim('}') {
+			break
+		}
+		key := tok.(string)
+		if n == 0 && r.opts.Typed {
+			if typ, ok := jsonTags[key]; ok {
+				return r.typed(k, key, typ)
+			}
+		}
+		if key == "" {
+			return r.errorf("empty key")
+		}
+		if len(key) > KSPACK_KEY_MAX_LEN {
+			return r.errorf("len(key) exceeds %d", KSPACK_KEY_MAX_LEN)
+		}
+		if start < 0 {
+			start = r.begin(KSPACK_OBJECT, k)
+		}
+		if tok, err = r.dec.Token(); err != nil {
+			return err
+		}
+		if err := r.value(key, tok); err != nil {
+			return err
+		}
+		n++
+	}
+	if start < 0 {
+		start = r.begin(KSPACK_OBJECT, k)
+	}
+	endContainer(r.e.data[:r.e.off], start, n)
+	return nil
+}
+
+// typed writes the typed value named tag, of type typ, under the key k.
+func (r *jsonReader) typed(k, tag string, typ byte) error {
+	tok, err := r.dec.Token()
+	if err != nil {
+		return err
+	}
+	e := &r.e
+	switch typ {
+	case KSPACK_BINARY:
+		s, ok := tok.(string)
+		if !ok {
+			return r.errorf("%s value is not a string", tag)
+		}
+		b, err := r.opts.base64().DecodeString(s)
+		if err != nil {
+			return r.errorf("%s value: %v", tag, err)
+		}
+		e.binary(k, b)
+	case KSPACK_DATE, KSPACK_ZONED_DATE:
+		s, ok := tok.(string)
+		if !ok {
+			return r.errorf("%s value is not a string", tag)
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return r.errorf("%s value: %v", tag, err)
+		}
+		nsec, ok := dateNanos(t)
+		if !ok {
+			return r.errorf("%s value %s out of DATE range", tag, s)
+		}
+		if typ == KSPACK_DATE {
+			e.date(k, nsec)
+		} else {
+			_, offset := t.Zone()
+			e.zonedDate(k, nsec, int32(offset))
+		}
+	case KSPACK_FLOAT, KSPACK_DOUBLE:
+		var s string
+		switch tok := tok.(type) {
+		case json.Number:
+			s = string(tok)
+		case string:
+			if tok != "NaN" && tok != "+Inf" && tok != "-Inf" {
+				return r.errorf("%s value %q is not a number", tag, tok)
+			}
+			s = tok
+		default:
+			return r.errorf("%s value is not a number", tag)
+		}
+		if err := r.float(k, s, typ); err != nil {
+			return err
+		}
+	default:
+		n, ok := tok.(json.Number)
+		if !ok || !isJSONInt(n) {
+			return r.errorf("%s value is not an integer", tag)
+		}
+		if err := r.int(k, n, typ); err != nil {
+			return err
+		}
+	}
+
+	if tok, err = r.dec.Token(); err != nil {
+		return err
+	}
+	if tok != json.Delim('}') {
+		return r.errorf("%s value followed by other members", tag)
+	}
+	return nil
+}
+
+// isJSONInt reports whether the JSON number n has neither a fraction
+// nor an exponent.
+func isJSONInt(n json.Number) bool {
+	return !strings.ContainsAny(string(n), ".eE")
+}
+
+func (r *jsonReader) number(k string, n json.Number) error {
+	switch {
+	case !isJSONInt(n):
+	case r.opts.IntType != 0:
+		return r.int(k, n, r.opts.IntType)
+	default:
+		if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+			typ := byte(KSPACK_INT64)
+			if r.opts.InferIntWidth {
+				switch {
+				case int64(int8(i)) == i:
+					typ = KSPACK_INT8
+				case int64(int16(i)) == i:
+					typ = KSPACK_INT16
+				case int64(int32(i)) == i:
+					typ = KSPACK_INT32
+				}
+			}
+			return r.int(k, n, typ)
+		}
+		if _, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+			return r.int(k, n, KSPACK_UINT64)
+		}
+	}
+	typ := r.opts.FloatType
+	if typ == 0 {
+		typ = KSPACK_DOUBLE
+	}
+	return r.float(k, string(n), typ)
+}
+
+// int writes the integer n as an item of the integer type typ.
+func (r *jsonReader) int(k string, n json.Number, typ byte) error {
+	e := &r.e
+	bits := fixedItemLen(typ) * 8
+	switch typ {
+	case KSPACK_INT8, KSPACK_INT16, KSPACK_INT32, KSPACK_INT64:
+		i, err := strconv.ParseInt(string(n), 10, bits)
+		if err != nil {
+			return r.errorf("JSON number %s overflows %s", n, typeName(typ))
+		}
+		switch typ {
+		case KSPACK_INT8:
+			e.int8(k, int8(i))
+		case KSPACK_INT16:
+			e.int16(k, int16(i))
+		case KSPACK_INT32:
+			e.int32(k, int32(i))
+		default:
+			e.int64(k, i)
+		}
+	default:
+		u, err := strconv.ParseUint(string(n), 10, bits)
+		if err != nil {
+			return r.errorf("JSON number %s overflows %s", n, typeName(typ))
+		}
+		switch typ {
+		case KSPACK_UINT8:
+			e.uint8(k, uint8(u))
+		case KSPACK_UINT16:
+			e.uint16(k, uint16(u))
+		case KSPACK_UINT32:
+			e.uint32(k, uint32(u))
+		default:
+			e.uint64(k, u)
+		}
+	}
+	return nil
+}
+
+// float writes the number s as an item of the float type typ.
+func (r *jsonReader) float(k string, s string, typ byte) error {
+	bits := 64
+	if typ == KSPACK_FLOAT {
+		bits = 32
+	}
+	f, err := strconv.ParseFloat(s, bits)
+	if err != nil {
+		return r.errorf("JSON number %s overflows %s", s, typeName(typ))
+	}
+	if typ == KSPACK_FLOAT {
+		r.e.float32(k, float32(f))
+	} else {
+		r.e.float64(k, f)
+	}
+	return nil
+}
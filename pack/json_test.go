@@ -0,0 +1,324 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// everyType has a field encoded with each item type code.
+type everyType struct {
+	Object      map[string]int64
+	Array       []bool
+	String      string
+	ShortString string
+	Binary      []byte
+	ShortBinary []byte
+	Int8        int8
+	Int16       int16
+	Int32       int32
+	Int64       int64
+	Uint8       uint8
+	Uint16      uint16
+	Uint32      uint32
+	Uint64      uint64
+	Bool        bool
+	Float       float32
+	Double      float64
+	Date        time.Time
+	ZonedDate   time.Time
+	Null        *int
+}
+
+func newEveryType() everyType {
+	return everyType{
+		Object:      map[string]int64{"n": -1},
+		Array:       []bool{true, false},
+		String:      strings.Repeat("s", 300),
+		ShortString: "short \"quoted\"\n\x01 é \u2028",
+		Binary:      bytes.Repeat([]byte{0xff, 0}, 200),
+		ShortBinary: []byte{1, 2, 3},
+		Int8:        math.MinInt8,
+		Int16:       math.MaxInt16,
+		Int32:       -1,
+		Int64:       math.MinInt64,
+		Uint8:       math.MaxUint8,
+		Uint16:      1,
+		Uint32:      math.MaxUint32,
+		Uint64:      math.MaxUint64,
+		Bool:        true,
+		Float:       1.5,
+		Double:      1e-7,
+		Date:        time.Date(2023, 5, 6, 7, 8, 9, 10, time.UTC),
+		ZonedDate:   time.Date(2023, 5, 6, 7, 8, 9, 0, time.FixedZone("", -3*3600-1800)),
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	b, err := Marshal(newEveryType())
+	assert.NoError(err)
+	var out bytes.Buffer
+	assert.NoError(ToJSON(&out, b))
+	assert.True(json.Valid(out.Bytes()))
+	assert.Equal(`{"Object":{"n":-1},"Array":[true,false],`+
+		`"String":"`+strings.Repeat("s", 300)+`",`+
+		`"ShortString":"short \"quoted\"\n\u0001 é \u2028",`+
+		`"Binary":"`+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0xff, 0}, 200))+`",`+
+		`"ShortBinary":"AQID",`+
+		`"Int8":-128,"Int16":32767,"Int32":-1,"Int64":-9223372036854775808,`+
+		`"Uint8":255,"Uint16":1,"Uint32":4294967295,"Uint64":18446744073709551615,`+
+		`"Bool":true,"Float":1.5,"Double":1e-7,`+
+		`"Date":"2023-05-06T07:08:09.00000001Z",`+
+		`"ZonedDate":"2023-05-06T07:08:09-03:30",`+
+		`"Null":null}`, out.String())
+
+	// the output is read by encoding/json as Marshal would write it
+	var v map[string]interface{}
+	assert.NoError(json.Unmarshal(out.Bytes(), &v))
+	want, err := json.Marshal(newEveryType())
+	assert.NoError(err)
+	var w map[string]interface{}
+	assert.NoError(json.Unmarshal(want, &w))
+	assert.Equal(w, v)
+
+	// invalid UTF-8 is replaced
+	b, err = Marshal("a\xffb")
+	assert.NoError(err)
+	out.Reset()
+	assert.NoError(ToJSON(&out, b))
+	assert.Equal(`"a\ufffdb"`, out.String())
+
+	out.Reset()
+	b, err = Marshal([]float32{float32(math.Inf(-1))})
+	assert.NoError(err)
+	assert.EqualError(ToJSON(&out, b), "kspack: unsupported value -Inf at offset 10")
+
+	assert.Error(ToJSON(&out, b[:len(b)-1]))
+	assert.EqualError(JSONOptions{FloatType: KSPACK_INT8}.ToJSON(&out, b), "kspack: invalid JSONOptions.FloatType 0x11")
+}
+
+func TestToJSONTyped(t *testing.T) {
+	assert := assert.New(t)
+
+	b, err := Marshal(struct {
+		A uint64
+		B float32
+		C []float64
+		D []byte
+		E time.Time
+		F time.Time
+		G int64
+		H int8
+	}{
+		A: 1, B: 0.5, C: []float64{math.NaN(), math.Inf(1), 2}, D: []byte{0},
+		E: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		F: time.Date(2023, 1, 1, 0, 0, 0, 0, time.FixedZone("", 0)),
+		G: 7, H: -7,
+	})
+	assert.NoError(err)
+	var out bytes.Buffer
+	assert.NoError(JSONOptions{Typed: true}.ToJSON(&out, b))
+	assert.Equal(`{"A":{"$uint64":1},"B":{"$float":0.5},`+
+		`"C":[{"$double":"NaN"},{"$double":"+Inf"},2],"D":{"$binary":"AA=="},`+
+		`"E":{"$date":"2023-01-01T00:00:00Z"},"F":{"$zoned_date":"2023-01-01T00:00:00Z"},`+
+		`"G":7,"H":{"$int8":-7}}`, out.String())
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	nan := newEveryType()
+	nan.Double = math.NaN()
+	nan.Float = float32(math.Inf(1))
+	nan.Date = time.Time{}
+	nan.Object = map[string]int64{}
+	nan.Array = nil
+	nested := []interface{}{
+		map[string]interface{}{"a": []interface{}{}, "b": map[string]interface{}{"c": nil}},
+		[]interface{}{[]interface{}{uint16(1)}},
+	}
+
+	opts := JSONOptions{Typed: true}
+	for _, v := range []interface{}{newEveryType(), nan, nested, "top", int8(1), nil} {
+		b, err := Marshal(v)
+		assert.NoError(err)
+		var js bytes.Buffer
+		assert.NoError(opts.ToJSON(&js, b))
+		var out bytes.Buffer
+		assert.NoError(opts.FromJSON(&out, &js), js.String())
+		assert.Equal(b, out.Bytes(), js.String())
+	}
+
+	// every type code appears in the round trip
+	b, err := Marshal(newEveryType())
+	assert.NoError(err)
+	for _, typ := range []byte{
+		KSPACK_OBJECT, KSPACK_ARRAY, KSPACK_STRING, KSPACK_SHORT_STRING,
+		KSPACK_BINARY, KSPACK_SHORT_BINARY, KSPACK_INT8, KSPACK_INT16,
+		KSPACK_INT32, KSPACK_INT64, KSPACK_UINT8, KSPACK_UINT16,
+		KSPACK_UINT32, KSPACK_UINT64, KSPACK_BOOL, KSPACK_FLOAT,
+		KSPACK_DOUBLE, KSPACK_DATE, KSPACK_ZONED_DATE, KSPACK_NULL,
+	} {
+		assert.Contains(string(b), string([]byte{typ, byte(len(typeField(typ)) + 1)}), typeName(typ))
+	}
+}
+
+// typeField returns the field of everyType encoded with the type code typ.
+func typeField(typ byte) string {
+	switch typ {
+	case KSPACK_SHORT_STRING:
+		return "ShortString"
+	case KSPACK_SHORT_BINARY:
+		return "ShortBinary"
+	case KSPACK_ZONED_DATE:
+		return "ZonedDate"
+	case KSPACK_FLOAT:
+		return "Float"
+	}
+	return strings.ToUpper(typeName(typ)[:1]) + typeName(typ)[1:]
+}
+
+func fromJSON(opts JSONOptions, s string) (interface{}, error) {
+	var out bytes.Buffer
+	if err := opts.FromJSON(&out, strings.NewReader(s)); err != nil {
+		return nil, err
+	}
+	var v interface{}
+	err := Unmarshal(out.Bytes(), &v)
+	return v, err
+}
+
+func TestFromJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	v, err := fromJSON(JSONOptions{}, `{"a": 1, "b": -1.5, "c": "x", "d": [true, null], "e": 18446744073709551615}`)
+	assert.NoError(err)
+	assert.Equal(map[string]interface{}{
+		"a": int64(1), "b": -1.5, "c": "x", "d": []interface{}{true, nil}, "e": uint64(math.MaxUint64),
+	}, v)
+
+	// members keep their order
+	var out bytes.Buffer
+	assert.NoError(FromJSON(&out, strings.NewReader(`{"z": 1, "a": 2}`)))
+	b, err := Marshal(struct {
+		Z int `kspack:"z"`
+		A int `kspack:"a"`
+	}{1, 2})
+	assert.NoError(err)
+	assert.Equal(b, out.Bytes())
+
+	// a stream of values gives a stream of items
+	out.Reset()
+	assert.NoError(FromJSON(&out, strings.NewReader("1 \"two\"\n[3]")))
+	dec := NewDecoder(&out)
+	var items []interface{}
+	for dec.More() {
+		var v interface{}
+		assert.NoError(dec.Decode(&v))
+		items = append(items, v)
+	}
+	assert.Equal([]interface{}{int64(1), "two", []interface{}{int64(3)}}, items)
+}
+
+func TestFromJSONNumbers(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		opts JSONOptions
+		in   string
+		typ  byte
+		want interface{}
+	}{
+		{JSONOptions{}, `1`, KSPACK_INT64, int64(1)},
+		{JSONOptions{}, `9223372036854775808`, KSPACK_UINT64, uint64(1 << 63)},
+		{JSONOptions{}, `18446744073709551616`, KSPACK_DOUBLE, float64(1 << 64)},
+		{JSONOptions{}, `1.0`, KSPACK_DOUBLE, 1.0},
+		{JSONOptions{FloatType: KSPACK_FLOAT}, `1e2`, KSPACK_FLOAT, float32(100)},
+		{JSONOptions{InferIntWidth: true}, `-128`, KSPACK_INT8, int8(-128)},
+		{JSONOptions{InferIntWidth: true}, `128`, KSPACK_INT16, int16(128)},
+		{JSONOptions{InferIntWidth: true}, `-32769`, KSPACK_INT32, int32(-32769)},
+		{JSONOptions{InferIntWidth: true}, `2147483648`, KSPACK_INT64, int64(2147483648)},
+		{JSONOptions{InferIntWidth: true}, `9223372036854775808`, KSPACK_UINT64, uint64(1 << 63)},
+		{JSONOptions{IntType: KSPACK_UINT16, InferIntWidth: true}, `7`, KSPACK_UINT16, uint16(7)},
+		{JSONOptions{IntType: KSPACK_INT32}, `-7`, KSPACK_INT32, int32(-7)},
+		{JSONOptions{IntType: KSPACK_INT32}, `0.5`, KSPACK_DOUBLE, 0.5},
+		{JSONOptions{Typed: true}, `{"$int16": 7}`, KSPACK_INT16, int16(7)},
+		{JSONOptions{Typed: true}, `{"$int64": 7}`, KSPACK_INT64, int64(7)},
+		{JSONOptions{Typed: true, IntType: KSPACK_UINT8}, `{"$uint32": 300}`, KSPACK_UINT32, uint32(300)},
+		{JSONOptions{Typed: true}, `{"$float": "-Inf"}`, KSPACK_FLOAT, float32(math.Inf(-1))},
+		{JSONOptions{Typed: true}, `{"$double": 2}`, KSPACK_DOUBLE, 2.0},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if !assert.NoError(tt.opts.FromJSON(&out, strings.NewReader(tt.in)), tt.in) {
+			continue
+		}
+		assert.Equal(tt.typ, out.Bytes()[0], tt.in)
+		var v interface{}
+		assert.NoError(Unmarshal(out.Bytes(), &v))
+		assert.Equal(tt.want, v, tt.in)
+	}
+}
+
+func TestFromJSONErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		opts JSONOptions
+		in   string
+		err  string
+	}{
+		{JSONOptions{IntType: KSPACK_UINT8}, `[1, 256]`, "kspack: JSON number 256 overflows uint8 at JSON offset 7"},
+		{JSONOptions{IntType: KSPACK_UINT8}, `-1`, "kspack: JSON number -1 overflows uint8 at JSON offset 2"},
+		{JSONOptions{FloatType: KSPACK_FLOAT}, `1e39`, "kspack: JSON number 1e39 overflows float at JSON offset 4"},
+		{JSONOptions{IntType: KSPACK_STRING}, `1`, "kspack: invalid JSONOptions.IntType 0x50"},
+		{JSONOptions{}, `{"": 1}`, "kspack: empty key at JSON offset 3"},
+		{JSONOptions{}, `{"` + strings.Repeat("k", 255) + `": 1}`, "kspack: len(key) exceeds 254 at JSON offset 258"},
+		{JSONOptions{}, `{"a": }`, "missing value after object key"},
+		{JSONOptions{}, `1e400`, "kspack: JSON number 1e400 overflows double at JSON offset 5"},
+		{JSONOptions{Typed: true}, `{"$int8": 1, "b": 2}`, "kspack: $int8 value followed by other members at JSON offset 16"},
+		{JSONOptions{Typed: true}, `{"$int8": 1.5}`, "kspack: $int8 value is not an integer at JSON offset 13"},
+		{JSONOptions{Typed: true}, `{"$binary": "!"}`, "kspack: $binary value: illegal base64 data at input byte 0 at JSON offset 15"},
+		{JSONOptions{Typed: true}, `{"$date": 1}`, "kspack: $date value is not a string at JSON offset 11"},
+		{JSONOptions{Typed: true}, `{"$date": "3000-01-01T00:00:00Z"}`, "kspack: $date value 3000-01-01T00:00:00Z out of DATE range at JSON offset 32"},
+		{JSONOptions{Typed: true}, `{"$double": "inf"}`, `kspack: $double value "inf" is not a number at JSON offset 17`},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		assert.EqualError(tt.opts.FromJSON(&out, strings.NewReader(tt.in)), tt.err, tt.in)
+	}
+
+	// a tag is an ordinary key unless Typed
+	v, err := fromJSON(JSONOptions{}, `{"$int8": 1}`)
+	assert.NoError(err)
+	assert.Equal(map[string]interface{}{"$int8": int64(1)}, v)
+
+	// nesting is bounded
+	var out bytes.Buffer
+	deep := strings.Repeat("[", maxNestingDepth+1) + strings.Repeat("]", maxNestingDepth+1)
+	assert.EqualError(FromJSON(&out, strings.NewReader(deep)), "exceeded max depth")
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import "reflect"
+
+// Precompile builds and caches the encoders, sizers and decoders of
+// the given types, and of every type their fields and elements hold, so
+// that the first Marshal, Size or Unmarshal of them does not pay for
+// it. It is meant to be called at startup with the types a program
+// exchanges, each given either as a reflect.Type or as a value of that
+// type:
+//
+//	pack.Precompile(Order{}, reflect.TypeOf((*Invoice)(nil)).Elem())
+//
+// Both a type and the pointer to it are compiled. Precompile uses
+// DefaultTagKey; types read with another tag key are compiled by
+// PrecompileTagKey.
+func Precompile(types ...interface{}) {
+	PrecompileTagKey(DefaultTagKey, types...)
+}
+
+// PrecompileTagKey is like Precompile for the encoders and decoders reading
+// field names from the struct tag key tagKey, as set by the TagKey
+// field of EncodeOptions and DecodeOptions.
+func PrecompileTagKey(tagKey string, types ...interface{}) {
+	for _, v := range types {
+		t, ok := v.(reflect.Type)
+		if !ok {
+			t = reflect.TypeOf(v)
+		}
+		if t == nil {
+			continue
+		}
+		if t.Kind() != reflect.Ptr {
+			t = reflect.PtrTo(t)
+		}
+		// the pointer and pointed-to types
+		for _, t := range []reflect.Type{t, t.Elem()} {
+			typeEncoder(t, tagKey)
+			typeSizer(t, tagKey)
+			typeDecoder(t, tagKey)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type shipment struct {
+	ID     string
+	Parcel []parcel
+	Labels map[string]tag
+}
+
+type parcel struct {
+	Weight float64
+	Next   *parcel
+}
+
+type tag struct {
+	Text string `ks:"text"`
+}
+
+func TestPrecompile(t *testing.T) {
+	assert := assert.New(t)
+
+	Precompile(shipment{}, nil)
+	PrecompileTagKey("ks", reflect.TypeOf(tag{}))
+	for _, typ := range []reflect.Type{
+		reflect.TypeOf(shipment{}),
+		reflect.TypeOf(&shipment{}),
+		reflect.TypeOf([]parcel{}),
+		reflect.TypeOf(&parcel{}),
+		reflect.TypeOf(tag{}),
+	} {
+		_, ok := encoderCache.Load(cacheKey{typ, DefaultTagKey})
+		assert.True(ok, "encoder of %v", typ)
+		_, ok = decoderCache.Load(cacheKey{typ, DefaultTagKey})
+		assert.True(ok, "decoder of %v", typ)
+	}
+	_, ok := encoderCache.Load(cacheKey{reflect.TypeOf(tag{}), "ks"})
+	assert.True(ok)
+	_, ok = decoderCache.Load(cacheKey{reflect.TypeOf(&tag{}), "ks"})
+	assert.True(ok)
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	assert := assert.New(t)
+
+	type ring struct {
+		Name string
+		Next *ring
+		All  []ring
+	}
+	in := ring{Name: "a", Next: &ring{Name: "b", All: []ring{}}, All: []ring{{Name: "c", All: []ring{}}}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := Marshal(in)
+			assert.NoError(err)
+			var out ring
+			assert.NoError(Unmarshal(data, &out))
+			assert.Equal(in, out)
+		}()
+	}
+	wg.Wait()
+}
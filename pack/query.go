@@ -0,0 +1,920 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Query returns the items of data selected by the JSONPath-like
+// expression expr, in document order:
+//
+//	skus, err := pack.Query(data, "$.orders[*].items[?(@.qty > 2)].sku")
+//
+// An expression starts with $, the root item, and goes on with segments
+// selecting among the members of an OBJECT or the elements of an ARRAY:
+//
+//	.name or ['name']   the member with key name
+//	.* or [*]           every member or element
+//	[i]                 the element at index i, from the end if negative
+//	[start:end:step]    the elements of a slice, as in Python
+//	[?(filter)]         every member or element the filter holds for
+//	..segment           the segment applied to an item and to all the
+//	                    items below it
+//
+// Brackets may list several names, indices and slices separated by
+// commas. A filter compares @, the member or element tested, or a path
+// below it such as @.qty or @['unit price'][0], to a number, a quoted
+// string, true, false, null or another such path, with ==, !=, <, <=, >
+// or >=. A path alone tests that the item exists. Comparisons combine
+// with &&, || and !, and group with parentheses. Numbers compare by
+// value whatever their item type; items of different kinds are never
+// equal, and only numbers and strings are ordered.
+//
+// Like Get, Query does not decode data: the matches still refer to it,
+// for their Raw items or decoded values. Query parses expr on each call;
+// CompileQuery parses it once to run it on many inputs.
+func Query(data []byte, expr string) ([]Value, error) {
+	q, err := CompileQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	return q.Find(data)
+}
+
+// A CompiledQuery is the parsed form of a Query expression. It is safe
+// for concurrent use.
+type CompiledQuery struct {
+	expr string
+	segs []segment
+}
+
+// CompileQuery parses a Query expression. It fails with a
+// *QuerySyntaxError.
+func CompileQuery(expr string) (q *CompiledQuery, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			err = r.(error)
+		}
+	}()
+	p := queryParser{expr: expr}
+	return &CompiledQuery{expr: expr, segs: p.query()}, nil
+}
+
+// MustCompileQuery is like CompileQuery but panics if expr cannot be
+// parsed.
+func MustCompileQuery(expr string) *CompiledQuery {
+	q, err := CompileQuery(expr)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// String returns the expression q was compiled from.
+func (q *CompiledQuery) String() string {
+	return q.expr
+}
+
+// Find returns the items of data selected by q.
+func (q *CompiledQuery) Find(data []byte) ([]Value, error) {
+	return q.FindAppend(nil, data)
+}
+
+// FindAppend appends the items of data selected by q to dst and returns
+// the extended slice. Reusing dst across inputs, a query without
+// negative slice steps allocates nothing unless it fails. On error, dst
+// is returned unchanged.
+func (q *CompiledQuery) FindAppend(dst []Value, data []byte) ([]Value, error) {
+	end, err := skip(data, 0, len(data))
+	if err != nil {
+		return dst, err
+	}
+	s := queryState{out: dst}
+	if err := s.run(Value{data: data, off: 0, end: end}, q.segs); err != nil {
+		return dst, err
+	}
+	return s.out, nil
+}
+
+// A segment is a step of a query, selecting among the members or
+// elements of the items matched so far.
+type segment struct {
+	descendant bool // also applied to every item below
+	sels       []selector
+}
+
+// selector kinds
+const (
+	selectName = iota
+	selectIndex
+	selectAll
+	selectSlice
+	selectFilter
+)
+
+type selector struct {
+	kind   int
+	name   string
+	index  int // index, or start of slice
+	end    int
+	step   int
+	bounds int // hasStart and hasEnd, for slices
+	filter filterNode
+}
+
+// slice bound flags of selector.bounds
+const (
+	hasStart = 1 << iota
+	hasEnd
+)
+
+// queryState collects the matches of a query.
+type queryState struct {
+	out   []Value
+	depth int // of the item being run on, bounded by maxNestingDepth
+}
+
+// run applies segs to v, appending the matches to s.out.
+func (s *queryState) run(v Value, segs []segment) error {
+	if len(segs) == 0 {
+		s.out = append(s.out, v)
+		return nil
+	}
+	if s.depth++; s.depth > maxNestingDepth {
+		return &SyntaxError{Msg: "exceeded max depth", Offset: int64(v.off)}
+	}
+	err := s.step(v, segs)
+	s.depth--
+	return err
+}
+
+// step applies the first of segs to v, and runs the rest on the items
+// it selects.
+func (s *queryState) step(v Value, segs []segment) error {
+	seg := &segs[0]
+	for i := range seg.sels {
+		if err := s.apply(v, &seg.sels[i], segs[1:]); err != nil {
+			return err
+		}
+	}
+	if seg.descendant && isContainer(v.Type()) {
+		return v.each(func(c Value) error {
+			return s.run(c, segs)
+		})
+	}
+	return nil
+}
+
+// apply runs rest on each member or element of v selected by sel.
+func (s *queryState) apply(v Value, sel *selector, rest []segment) error {
+	typ := v.Type()
+	switch sel.kind {
+	case selectName:
+		if typ != KSPACK_OBJECT {
+			return nil
+		}
+		c, ok, err := v.member(sel.name)
+		if !ok {
+			return err
+		}
+		return s.run(c, rest)
+	case selectIndex:
+		if typ != KSPACK_ARRAY {
+			return nil
+		}
+		i := sel.index
+		if i < 0 {
+			i += v.count()
+		}
+		c, ok, err := v.element(i)
+		if !ok {
+			return err
+		}
+		return s.run(c, rest)
+	case selectAll:
+		if !isContainer(typ) {
+			return nil
+		}
+		return v.each(func(c Value) error {
+			return s.run(c, rest)
+		})
+	case selectSlice:
+		if typ != KSPACK_ARRAY {
+			return nil
+		}
+		start, end := sel.sliceBounds(v.count())
+		if sel.step < 0 {
+			// elements in reverse order
+			var elems []Value
+			if err := v.each(func(c Value) error {
+				elems = append(elems, c)
+				return nil
+			}); err != nil {
+				return err
+			}
+			for i := start; i > end; i += sel.step {
+				if err := s.run(elems[i], rest); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		i := -1
+		return v.each(func(c Value) error {
+			if i++; i < start || i >= end || (i-start)%sel.step != 0 {
+				return nil
+			}
+			return s.run(c, rest)
+		})
+	case selectFilter:
+		if !isContainer(typ) {
+			return nil
+		}
+		return v.each(func(c Value) error {
+			ok, err := sel.filter.match(c)
+			if !ok || err != nil {
+				return err
+			}
+			return s.run(c, rest)
+		})
+	}
+	return nil
+}
+
+// sliceBounds returns the first index of the slice sel of an array of n
+// elements, and the index it stops before.
+func (sel *selector) sliceBounds(n int) (start, end int) {
+	norm := func(i, lo, hi int) int {
+		if i < 0 {
+			i += n
+		}
+		if i < lo {
+			return lo
+		}
+		if i > hi {
+			return hi
+		}
+		return i
+	}
+	if sel.step > 0 {
+		start, end = 0, n
+		if sel.bounds&hasStart != 0 {
+			start = norm(sel.index, 0, n)
+		}
+		if sel.bounds&hasEnd != 0 {
+			end = norm(sel.end, 0, n)
+		}
+		return start, end
+	}
+	start, end = n-1, -1
+	if sel.bounds&hasStart != 0 {
+		start = norm(sel.index, -1, n-1)
+	}
+	if sel.bounds&hasEnd != 0 {
+		end = norm(sel.end, -1, n-1)
+	}
+	return start, end
+}
+
+func isContainer(typ byte) bool {
+	return typ == KSPACK_OBJECT || typ == KSPACK_ARRAY
+}
+
+// each calls fn with each member or element of the OBJECT or ARRAY v,
+// in order, and stops at the first error.
+func (v Value) each(fn func(c Value) error) error {
+	var ferr error
+	err := v.walk(func(off, end int) bool {
+		ferr = fn(Value{data: v.data, off: off, end: end})
+		return ferr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return ferr
+}
+
+// A filterNode is a boolean expression of a filter selector.
+type filterNode interface {
+	match(v Value) (bool, error)
+}
+
+type andNode struct{ x, y filterNode }
+
+func (n *andNode) match(v Value) (bool, error) {
+	ok, err := n.x.match(v)
+	if !ok || err != nil {
+		return false, err
+	}
+	return n.y.match(v)
+}
+
+type orNode struct{ x, y filterNode }
+
+func (n *orNode) match(v Value) (bool, error) {
+	ok, err := n.x.match(v)
+	if ok || err != nil {
+		return ok, err
+	}
+	return n.y.match(v)
+}
+
+type notNode struct{ x filterNode }
+
+func (n *notNode) match(v Value) (bool, error) {
+	ok, err := n.x.match(v)
+	return !ok, err
+}
+
+// existsNode holds when its path leads to an item.
+type existsNode struct{ path []pathStep }
+
+func (n *existsNode) match(v Value) (bool, error) {
+	_, ok, err := follow(v, n.path)
+	return ok, err
+}
+
+// comparison operators
+const (
+	opEq = iota
+	opNe
+	opLt
+	opLe
+	opGt
+	opGe
+)
+
+var compareOps = []string{opEq: "==", opNe: "!=", opLt: "<", opLe: "<=", opGt: ">", opGe: ">="}
+
+// compareNode holds when its operands compare as op tells. It never
+// holds if one of them is a path leading to no item.
+type compareNode struct {
+	op   int
+	x, y operand
+}
+
+func (n *compareNode) match(v Value) (bool, error) {
+	x, ok, err := n.x.eval(v)
+	if !ok || err != nil {
+		return false, err
+	}
+	y, ok, err := n.y.eval(v)
+	if !ok || err != nil {
+		return false, err
+	}
+	return compareScalars(n.op, x, y), nil
+}
+
+// A pathStep is a member key or an array index of a filter path.
+type pathStep struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+// follow returns the item reached from v through path.
+func follow(v Value, path []pathStep) (Value, bool, error) {
+	for _, step := range path {
+		var (
+			ok  bool
+			err error
+		)
+		if step.isIndex {
+			i := step.index
+			if i < 0 && v.Type() == KSPACK_ARRAY {
+				i += v.count()
+			}
+			v, ok, err = v.element(i)
+		} else {
+			v, ok, err = v.member(step.name)
+		}
+		if !ok {
+			return Value{}, false, err
+		}
+	}
+	return v, true, nil
+}
+
+// An operand of a comparison is a path from @ or a literal.
+type operand struct {
+	path   []pathStep
+	isPath bool
+	lit    scalar
+}
+
+func (o *operand) eval(v Value) (scalar, bool, error) {
+	if !o.isPath {
+		return o.lit, true, nil
+	}
+	v, ok, err := follow(v, o.path)
+	if !ok {
+		return scalar{}, false, err
+	}
+	return scalarOf(v), true, nil
+}
+
+// scalar kinds; items of any other kind are never equal to anything
+const (
+	otherScalar = iota
+	numberScalar
+	stringScalar
+	boolScalar
+	nullScalar
+)
+
+// A scalar is the value of an item or literal, as compared by filters.
+type scalar struct {
+	kind int
+	num  number
+	str  []byte
+	b    bool
+}
+
+// A number is a signed ('i'), unsigned ('u') or floating point ('f')
+// number.
+type number struct {
+	kind byte
+	i    int64
+	u    uint64
+	f    float64
+}
+
+func (n number) float() float64 {
+	switch n.kind {
+	case 'i':
+		return float64(n.i)
+	case 'u':
+		return float64(n.u)
+	}
+	return n.f
+}
+
+// scalarOf returns the value of the item v, without copying strings.
+func scalarOf(v Value) scalar {
+	switch typ := v.Type(); typ {
+	case KSPACK_INT8, KSPACK_INT16, KSPACK_INT32, KSPACK_INT64:
+		n, _ := decodeInt(v.data, v.off, 64)
+		return scalar{kind: numberScalar, num: number{kind: 'i', i: n}}
+	case KSPACK_UINT8, KSPACK_UINT16, KSPACK_UINT32, KSPACK_UINT64:
+		n, _ := decodeUint(v.data, v.off, 64)
+		return scalar{kind: numberScalar, num: number{kind: 'u', u: n}}
+	case KSPACK_FLOAT, KSPACK_DOUBLE:
+		f, _ := decodeFloat(v.data, v.off, 64)
+		return scalar{kind: numberScalar, num: number{kind: 'f', f: f}}
+	case KSPACK_STRING, KSPACK_SHORT_STRING:
+		b := itemValue(v.data, v.off)
+		return scalar{kind: stringScalar, str: b[:len(b)-1]}
+	case KSPACK_BOOL:
+		b, _ := decodeBool(v.data, v.off)
+		return scalar{kind: boolScalar, b: b}
+	case KSPACK_NULL:
+		return scalar{kind: nullScalar}
+	}
+	return scalar{}
+}
+
+// compareScalars reports whether x op y holds.
+func compareScalars(op int, x, y scalar) bool {
+	if x.kind != y.kind || x.kind == otherScalar {
+		return op == opNe
+	}
+	c := 0
+	switch x.kind {
+	case numberScalar:
+		var ok bool
+		if c, ok = compareNumbers(x.num, y.num); !ok {
+			return op == opNe // NaN
+		}
+	case stringScalar:
+		c = bytes.Compare(x.str, y.str)
+	case boolScalar:
+		if x.b != y.b {
+			return op == opNe
+		}
+		return op == opEq || op == opLe || op == opGe
+	case nullScalar:
+		return op == opEq || op == opLe || op == opGe
+	}
+	switch op {
+	case opEq:
+		return c == 0
+	case opNe:
+		return c != 0
+	case opLt:
+		return c < 0
+	case opLe:
+		return c <= 0
+	case opGt:
+		return c > 0
+	}
+	return c >= 0
+}
+
+// compareNumbers returns -1, 0 or 1 as x is less than, equal to or
+// greater than y. It reports false if one of them is NaN.
+func compareNumbers(x, y number) (int, bool) {
+	switch {
+	case x.kind == 'f' || y.kind == 'f':
+		a, b := x.float(), y.float()
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		case a == b:
+			return 0, true
+		}
+		return 0, false
+	case x.kind == 'i' && y.kind == 'i':
+		return compareUints(uint64(x.i)+1<<63, uint64(y.i)+1<<63), true
+	case x.kind == 'u' && y.kind == 'u':
+		return compareUints(x.u, y.u), true
+	case x.kind == 'i':
+		if x.i < 0 {
+			return -1, true
+		}
+		return compareUints(uint64(x.i), y.u), true
+	}
+	if y.i < 0 {
+		return 1, true
+	}
+	return compareUints(x.u, uint64(y.i)), true
+}
+
+func compareUints(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// A QuerySyntaxError describes a malformed Query expression.
+type QuerySyntaxError struct {
+	Expr   string // the expression
+	Msg    string // description of error
+	Offset int    // error occurred at this byte offset in Expr
+}
+
+func (e *QuerySyntaxError) Error() string {
+	return "kspack: invalid query " + strconv.Quote(e.Expr) + ": " + e.Msg + " at offset " + strconv.Itoa(e.Offset)
+}
+
+// queryParser parses a Query expression, panicking with a
+// *QuerySyntaxError on the first error.
+type queryParser struct {
+	expr string
+	pos  int
+}
+
+func (p *queryParser) error(msg string) {
+	panic(&QuerySyntaxError{Expr: p.expr, Msg: msg, Offset: p.pos})
+}
+
+func (p *queryParser) peek() byte {
+	if p.pos < len(p.expr) {
+		return p.expr[p.pos]
+	}
+	return 0
+}
+
+// consume skips s if the expression goes on with it.
+func (p *queryParser) consume(s string) bool {
+	if strings.HasPrefix(p.expr[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) expect(s string) {
+	if !p.consume(s) {
+		p.error("expected " + s)
+	}
+}
+
+func (p *queryParser) skipSpace() {
+	for p.pos < len(p.expr) && (p.expr[p.pos] == ' ' || p.expr[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// query = "$" segment*
+func (p *queryParser) query() []segment {
+	p.expect("$")
+	var segs []segment
+	for p.pos < len(p.expr) {
+		segs = append(segs, p.segment())
+	}
+	return segs
+}
+
+// segment = ("." | "..") (name | "*") | [".."] "[" selectors "]"
+func (p *queryParser) segment() segment {
+	var seg segment
+	switch {
+	case p.consume(".."):
+		seg.descendant = true
+		if p.peek() == '[' {
+			seg.sels = p.brackets()
+		} else {
+			seg.sels = []selector{p.dotSelector()}
+		}
+	case p.consume("."):
+		seg.sels = []selector{p.dotSelector()}
+	case p.peek() == '[':
+		seg.sels = p.brackets()
+	default:
+		p.error("expected . or [")
+	}
+	return seg
+}
+
+func (p *queryParser) dotSelector() selector {
+	if p.consume("*") {
+		return selector{kind: selectAll}
+	}
+	return selector{kind: selectName, name: p.name()}
+}
+
+func isNameByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '_' || c == '-' || c >= 0x80
+}
+
+// name reads a member key written without quotes.
+func (p *queryParser) name() string {
+	start := p.pos
+	for p.pos < len(p.expr) && isNameByte(p.expr[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		p.error("expected name")
+	}
+	return p.expr[start:p.pos]
+}
+
+// brackets reads the selectors of a bracketed segment: a filter, or a
+// list of names, indices, slices and wildcards.
+func (p *queryParser) brackets() []selector {
+	p.expect("[")
+	p.skipSpace()
+	if p.consume("?") {
+		p.skipSpace()
+		p.expect("(")
+		f := p.or()
+		p.skipSpace()
+		p.expect(")")
+		p.skipSpace()
+		p.expect("]")
+		return []selector{{kind: selectFilter, filter: f}}
+	}
+	var sels []selector
+	for {
+		p.skipSpace()
+		sels = append(sels, p.bracketSelector())
+		p.skipSpace()
+		if !p.consume(",") {
+			break
+		}
+	}
+	p.expect("]")
+	return sels
+}
+
+func (p *queryParser) bracketSelector() selector {
+	switch c := p.peek(); {
+	case c == '*':
+		p.pos++
+		return selector{kind: selectAll}
+	case c == '\'' || c == '"':
+		return selector{kind: selectName, name: p.quoted()}
+	case c == ':' || c == '-' || '0' <= c && c <= '9':
+		sel := selector{kind: selectIndex}
+		if c != ':' {
+			sel.index = p.int()
+			sel.bounds |= hasStart
+		}
+		p.skipSpace()
+		if !p.consume(":") {
+			return sel
+		}
+		sel.kind = selectSlice
+		sel.step = 1
+		if p.skipSpace(); p.peek() == '-' || '0' <= p.peek() && p.peek() <= '9' {
+			sel.end = p.int()
+			sel.bounds |= hasEnd
+		}
+		p.skipSpace()
+		if p.consume(":") {
+			if p.skipSpace(); p.peek() == '-' || '0' <= p.peek() && p.peek() <= '9' {
+				pos := p.pos
+				if sel.step = p.int(); sel.step == 0 {
+					p.pos = pos
+					p.error("slice step cannot be zero")
+				}
+			}
+		}
+		return sel
+	}
+	p.error("expected name, index, slice or *")
+	return selector{}
+}
+
+func (p *queryParser) int() int {
+	start := p.pos
+	p.consume("-")
+	for '0' <= p.peek() && p.peek() <= '9' {
+		p.pos++
+	}
+	n, err := strconv.Atoi(p.expr[start:p.pos])
+	if err != nil {
+		p.pos = start
+		p.error("invalid integer")
+	}
+	return n
+}
+
+// quoted reads a string in single or double quotes. A backslash escapes
+// the quote, itself, or stands for a control character as in Go.
+func (p *queryParser) quoted() string {
+	q := p.expr[p.pos]
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.expr) {
+			p.error("unterminated string")
+		}
+		c := p.expr[p.pos]
+		p.pos++
+		switch c {
+		case q:
+			return b.String()
+		case '\\':
+			switch e := p.peek(); e {
+			case '\\', '\'', '"', '/':
+				b.WriteByte(e)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				p.error("invalid escape")
+			}
+			p.pos++
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// or = and ("||" and)*
+func (p *queryParser) or() filterNode {
+	x := p.and()
+	for p.skipSpace(); p.consume("||"); p.skipSpace() {
+		x = &orNode{x, p.and()}
+	}
+	return x
+}
+
+// and = unary ("&&" unary)*
+func (p *queryParser) and() filterNode {
+	x := p.unary()
+	for p.skipSpace(); p.consume("&&"); p.skipSpace() {
+		x = &andNode{x, p.unary()}
+	}
+	return x
+}
+
+// unary = "!" unary | "(" or ")" | operand [op operand]
+func (p *queryParser) unary() filterNode {
+	p.skipSpace()
+	switch {
+	case p.consume("!"):
+		return &notNode{p.unary()}
+	case p.consume("("):
+		x := p.or()
+		p.skipSpace()
+		p.expect(")")
+		return x
+	}
+	start := p.pos
+	x := p.operand()
+	p.skipSpace()
+	op := -1
+	for _, o := range []int{opEq, opNe, opLe, opGe, opLt, opGt} {
+		if p.consume(compareOps[o]) {
+			op = o
+			break
+		}
+	}
+	if op < 0 {
+		if !x.isPath {
+			p.pos = start
+			p.error("expected path to test or compare")
+		}
+		return &existsNode{x.path}
+	}
+	return &compareNode{op: op, x: x, y: p.operand()}
+}
+
+// operand = "@" (("." name) | ("[" (quoted | int) "]"))* | literal
+func (p *queryParser) operand() operand {
+	p.skipSpace()
+	switch c := p.peek(); {
+	case c == '@':
+		p.pos++
+		o := operand{isPath: true}
+		for {
+			switch {
+			case p.peek() == '.':
+				p.pos++
+				o.path = append(o.path, pathStep{name: p.name()})
+				continue
+			case p.consume("["):
+				p.skipSpace()
+				if c := p.peek(); c == '\'' || c == '"' {
+					o.path = append(o.path, pathStep{name: p.quoted()})
+				} else {
+					o.path = append(o.path, pathStep{index: p.int(), isIndex: true})
+				}
+				p.skipSpace()
+				p.expect("]")
+				continue
+			}
+			return o
+		}
+	case c == '\'' || c == '"':
+		return operand{lit: scalar{kind: stringScalar, str: []byte(p.quoted())}}
+	case c == '-' || '0' <= c && c <= '9':
+		return operand{lit: scalar{kind: numberScalar, num: p.number()}}
+	}
+	start := p.pos
+	for p.pos < len(p.expr) && isNameByte(p.expr[p.pos]) {
+		p.pos++
+	}
+	switch p.expr[start:p.pos] {
+	case "true":
+		return operand{lit: scalar{kind: boolScalar, b: true}}
+	case "false":
+		return operand{lit: scalar{kind: boolScalar}}
+	case "null":
+		return operand{lit: scalar{kind: nullScalar}}
+	}
+	p.pos = start
+	p.error("expected @, number, string, true, false or null")
+	return operand{}
+}
+
+// number reads a number literal, as an integer if it has neither
+// fraction nor exponent.
+func (p *queryParser) number() number {
+	start := p.pos
+	for p.pos < len(p.expr) && strings.IndexByte("+-.0123456789eE", p.expr[p.pos]) >= 0 {
+		p.pos++
+	}
+	s := p.expr[start:p.pos]
+	if strings.IndexAny(s, ".eE") < 0 {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return number{kind: 'i', i: i}
+		}
+		if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return number{kind: 'u', u: u}
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		p.pos = start
+		p.error("invalid number")
+	}
+	return number{kind: 'f', f: f}
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type queryLine struct {
+	Sku   string      `json:"sku"`
+	Qty   interface{} `json:"qty"`
+	Price float64     `json:"price"`
+}
+
+type queryOrder struct {
+	ID    uint32      `json:"id"`
+	Items []queryLine `json:"items"`
+	Tags  []string    `json:"tags,omitempty"`
+	Paid  bool        `json:"paid"`
+	Note  *string     `json:"note"`
+}
+
+type queryDoc struct {
+	Store  string        `json:"store"`
+	Orders []queryOrder  `json:"orders"`
+	Odd    []interface{} `json:"odd key"`
+}
+
+func querySample() []byte {
+	b, err := Marshal(queryDoc{
+		Store: "north",
+		Orders: []queryOrder{
+			{ID: 1, Items: []queryLine{{"a", int8(1), 2.5}, {"b", int32(3), 1}}, Tags: []string{"x", "y"}, Paid: true},
+			{ID: 2, Items: []queryLine{{"c", uint64(5), 0.5}}},
+			{ID: 3, Items: []queryLine{{"d", 2.5, 10}, {"e", "3", 4}}, Paid: true},
+		},
+		Odd: []interface{}{math.NaN(), nil, []byte{1}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	data := querySample()
+	for _, tt := range []struct {
+		expr string
+		want []interface{}
+	}{
+		{`$.store`, []interface{}{"north"}},
+		{`$['store']`, []interface{}{"north"}},
+		{`$["odd key"][1]`, []interface{}{nil}},
+		{`$.missing`, nil},
+		{`$.store.name`, nil},
+		{`$.orders[*].id`, []interface{}{uint32(1), uint32(2), uint32(3)}},
+		{`$.orders.*.id`, []interface{}{uint32(1), uint32(2), uint32(3)}},
+		{`$.orders[0].id.*`, nil},
+		{`$.orders[0].tags.*`, []interface{}{"x", "y"}},
+		{`$.orders[-1].id`, []interface{}{uint32(3)}},
+		{`$.orders[3].id`, nil},
+		{`$.orders[-4].id`, nil},
+		{`$.orders[2, 0].id`, []interface{}{uint32(3), uint32(1)}},
+		{`$.orders[1:].id`, []interface{}{uint32(2), uint32(3)}},
+		{`$.orders[:2].id`, []interface{}{uint32(1), uint32(2)}},
+		{`$.orders[::2].id`, []interface{}{uint32(1), uint32(3)}},
+		{`$.orders[-2:10].id`, []interface{}{uint32(2), uint32(3)}},
+		{`$.orders[::-1].id`, []interface{}{uint32(3), uint32(2), uint32(1)}},
+		{`$.orders[1::-1].id`, []interface{}{uint32(2), uint32(1)}},
+		{`$.orders[2:0:-2].id`, []interface{}{uint32(3)}},
+		{`$.orders[2:1].id`, nil},
+		{`$..sku`, []interface{}{"a", "b", "c", "d", "e"}},
+		{`$..tags[1]`, []interface{}{"y"}},
+		{`$..items[?(@.qty > 2)].sku`, []interface{}{"b", "c", "d"}},
+		{`$.orders[*].items[?(@.qty > 2)].sku`, []interface{}{"b", "c", "d"}},
+		{`$.orders[*].items[?(@.qty == 3)].sku`, []interface{}{"b"}},
+		{`$.orders[*].items[?(@.qty == '3')].sku`, []interface{}{"e"}},
+		{`$.orders[*].items[?(@.qty != 3)].sku`, []interface{}{"a", "c", "d", "e"}},
+		{`$.orders[*].items[?(@.qty >= 2.5 && @.price < 5)].sku`, []interface{}{"b", "c"}},
+		{`$.orders[*].items[?(@.qty < 2 || @.sku == "e")].sku`, []interface{}{"a", "e"}},
+		{`$.orders[*].items[?(!(@.qty > 2))].sku`, []interface{}{"a", "e"}},
+		{`$.orders[*].items[?(@.price > @.qty)].sku`, []interface{}{"a", "d"}},
+		{`$.orders[?(@.paid == true)].id`, []interface{}{uint32(1), uint32(3)}},
+		{`$.orders[?(@.note == null)].id`, []interface{}{uint32(1), uint32(2), uint32(3)}},
+		{`$.orders[?(@.tags)].id`, []interface{}{uint32(1)}},
+		{`$.orders[?(@.tags[-1] >= 'y')].id`, []interface{}{uint32(1)}},
+		{`$.orders[?(@['items'][1])].id`, []interface{}{uint32(1), uint32(3)}},
+		{`$.orders[?(@.id > -1)].id`, []interface{}{uint32(1), uint32(2), uint32(3)}},
+		{`$.orders[?(@.id < 18446744073709551615)].id`, []interface{}{uint32(1), uint32(2), uint32(3)}},
+		{`$.orders[0].tags[?(@ > 'x')]`, []interface{}{"y"}},
+		{`$["odd key"][?(@ == @)]`, []interface{}{nil}},
+		{`$["odd key"][?(@ != @)]`, []interface{}{math.NaN(), []byte{1}}},
+	} {
+		vs, err := Query(data, tt.expr)
+		if !assert.NoError(err, tt.expr) {
+			continue
+		}
+		var got []interface{}
+		for _, v := range vs {
+			x, err := v.Interface()
+			assert.NoError(err, tt.expr)
+			got = append(got, x)
+		}
+		if len(tt.want) > 0 && tt.want[0] != tt.want[0] { // NaN
+			if assert.Len(got, len(tt.want), tt.expr) {
+				assert.True(math.IsNaN(got[0].(float64)), tt.expr)
+				assert.Equal(tt.want[1:], got[1:], tt.expr)
+			}
+			continue
+		}
+		assert.Equal(tt.want, got, tt.expr)
+	}
+
+	// the matches are the items of the input
+	vs, err := Query(data, `$`)
+	assert.NoError(err)
+	if assert.Len(vs, 1) {
+		assert.Equal(data, vs[0].Raw())
+	}
+	vs, err = Query(data, `$.orders[1]`)
+	assert.NoError(err)
+	if assert.Len(vs, 1) {
+		var o queryOrder
+		assert.NoError(Unmarshal(vs[0].Raw(), &o))
+		assert.Equal(uint32(2), o.ID)
+	}
+}
+
+func TestCompiledQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	q := MustCompileQuery(`$.orders[*].items[?(@.qty > 2)].sku`)
+	assert.Equal(`$.orders[*].items[?(@.qty > 2)].sku`, q.String())
+
+	data := querySample()
+	out, err := q.FindAppend(nil, data)
+	assert.NoError(err)
+	assert.Len(out, 3)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		out, _ = q.FindAppend(out[:0], data)
+	})
+	assert.Zero(allocs)
+
+	// objects and arrays without their content length
+	unsized, _ := beginArray(nil, "")
+	unsized, obj := beginObject(unsized, "")
+	unsized = appendInt8(unsized, "qty", 3)
+	unsized = appendString(unsized, "sku", "z")
+	unsized = endContainer(unsized, obj, 2)
+	unsized = endContainer(unsized, 0, 1)
+	for _, off := range []int{0, obj} {
+		copy(unsized[off+2:], []byte{0, 0, 0, 0})
+	}
+	out, err = MustCompileQuery(`$[?(@.qty > 2)].sku`).Find(unsized)
+	assert.NoError(err)
+	if assert.Len(out, 1) {
+		s, err := out[0].String()
+		assert.NoError(err)
+		assert.Equal("z", s)
+	}
+
+	// broken items are reported once reached, leaving dst as it was
+	broken := append([]byte(nil), data...)
+	sku, err := Get(data, "orders", 2, "items", 1, "sku")
+	assert.NoError(err)
+	broken[sku.end-1] = 'x' // string terminator
+	out, err = q.FindAppend(out[:1], broken)
+	assert.EqualError(err, "kspack: unterminated string at offset "+strconv.Itoa(sku.off))
+	assert.Len(out, 1)
+	_, err = MustCompileQuery(`$.store`).Find(broken)
+	assert.NoError(err)
+
+	// recursive descent stops at the nesting limit of the decoder
+	var deep []byte
+	var starts []int
+	for i := 0; i <= maxNestingDepth; i++ {
+		var start int
+		deep, start = beginArray(deep, "")
+		starts = append(starts, start)
+	}
+	deep = endContainer(deep, starts[maxNestingDepth], 0)
+	for i := maxNestingDepth - 1; i >= 0; i-- {
+		deep = endContainer(deep, starts[i], 1)
+	}
+	_, err = Query(deep, `$..*`)
+	assert.EqualError(err, "kspack: exceeded max depth at offset "+strconv.Itoa(starts[maxNestingDepth]))
+
+	assert.Panics(func() { MustCompileQuery(`store`) })
+}
+
+func TestQuerySyntaxErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tt := range []struct {
+		expr string
+		err  string
+	}{
+		{``, `expected $ at offset 0`},
+		{`store`, `expected $ at offset 0`},
+		{`$store`, `expected . or [ at offset 1`},
+		{`$.`, `expected name at offset 2`},
+		{`$.a..`, `expected name at offset 5`},
+		{`$[`, `expected name, index, slice or * at offset 2`},
+		{`$[0`, `expected ] at offset 3`},
+		{`$[0 1]`, `expected ] at offset 4`},
+		{`$['a`, `unterminated string at offset 4`},
+		{`$['a\x']`, `invalid escape at offset 5`},
+		{`$[-]`, `invalid integer at offset 2`},
+		{`$[::0]`, `slice step cannot be zero at offset 4`},
+		{`$[?@.a]`, `expected ( at offset 3`},
+		{`$[?(@.a > )]`, `expected @, number, string, true, false or null at offset 10`},
+		{`$[?(@.a > 1]`, `expected ) at offset 11`},
+		{`$[?(1)]`, `expected path to test or compare at offset 4`},
+		{`$[?(@.a = 1)]`, `expected ) at offset 8`},
+		{`$[?(@.a == 1e)]`, `invalid number at offset 11`},
+		{`$[?(@.a == yes)]`, `expected @, number, string, true, false or null at offset 11`},
+		{`$[?(@.*)]`, `expected name at offset 6`},
+	} {
+		_, err := CompileQuery(tt.expr)
+		if assert.IsType(&QuerySyntaxError{}, err, tt.expr) {
+			assert.Equal("kspack: invalid query "+strconv.Quote(tt.expr)+": "+tt.err, err.Error())
+		}
+	}
+
+	_, err := Query(querySample(), `$.orders[`)
+	assert.IsType(&QuerySyntaxError{}, err)
+}
@@ -0,0 +1,418 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Size returns the length of the kspack encoding of v, as Marshal would
+// produce it, without encoding it. It fails with the same errors as
+// Marshal. Size lets callers pre-size network frames or reject oversized
+// values up front; Marshal itself already allocates its result only once.
+//
+// Values implementing Marshaler, encoding.TextMarshaler or
+// encoding.BinaryMarshaler are measured by calling their marshal method.
+func Size(v interface{}) (int, error) {
+	return EncodeOptions{}.Size(v)
+}
+
+// Size returns the length of the kspack encoding of v according to o.
+func (o EncodeOptions) Size(v interface{}) (n int, err error) {
+	e := newEncodeState()
+	defer putEncodeState(e)
+	e.setOptions(o)
+
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			if s, ok := r.(string); ok {
+				panic(s)
+			}
+			n, err = 0, r.(error)
+		}
+	}()
+	if n = valueSizer(reflect.ValueOf(v), e.tagKey)(e, "", reflect.ValueOf(v)); n == 0 {
+		// only a skipped unsupported value writes nothing
+		return 0, &UnsupportedTypeError{Type: reflect.TypeOf(v)}
+	}
+	return n, nil
+}
+
+// A sizerFunc returns the length of the item its encoderFunc writes for
+// v under the key k. It panics with the errors the encoder would.
+type sizerFunc func(e *encodeState, k string, v reflect.Value) int
+
+var sizerCache sync.Map // map[cacheKey]sizerFunc
+
+func valueSizer(v reflect.Value, tagKey string) sizerFunc {
+	if !v.IsValid() {
+		return nilSizer
+	}
+	return typeSizer(v.Type(), tagKey)
+}
+
+func typeSizer(t reflect.Type, tagKey string) sizerFunc {
+	key := cacheKey{t, tagKey}
+	if fi, ok := sizerCache.Load(key); ok {
+		return fi.(sizerFunc)
+	}
+
+	// Recursive types are dealt with as in typeEncoder.
+	var (
+		wg sync.WaitGroup
+		f  sizerFunc
+	)
+	wg.Add(1)
+	fi, loaded := sizerCache.LoadOrStore(key, sizerFunc(func(e *encodeState, k string, v reflect.Value) int {
+		wg.Wait()
+		return f(e, k, v)
+	}))
+	if loaded {
+		return fi.(sizerFunc)
+	}
+
+	f = newTypeSizer(t, tagKey)
+	wg.Done()
+	sizerCache.Store(key, f)
+	return f
+}
+
+// newTypeSizer constructs the sizerFunc matching newTypeEncoder.
+func newTypeSizer(t reflect.Type, tagKey string) sizerFunc {
+	if implementsOrAddr(t, marshalerType) {
+		return newEncodedSizer(typeEncoder(t, tagKey))
+	}
+	if t == timeType {
+		return timeSizer
+	}
+	if t.Kind() == reflect.Ptr && t.Elem() == timeType {
+		return newPtrSizer(t, tagKey)
+	}
+	if implementsOrAddr(t, textMarshalerType) || implementsOrAddr(t, binaryMarshalerType) {
+		return newEncodedSizer(typeEncoder(t, tagKey))
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return fixedSizer(1)
+	case reflect.Int8, reflect.Uint8:
+		return fixedSizer(1)
+	case reflect.Int16, reflect.Uint16:
+		return fixedSizer(2)
+	case reflect.Int32, reflect.Uint32, reflect.Float32:
+		return fixedSizer(4)
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64, reflect.Uintptr, reflect.Float64:
+		return fixedSizer(8)
+	case reflect.String:
+		return stringSizer
+	case reflect.Interface:
+		return interfaceSizer
+	case reflect.Struct:
+		return newStructSizer(t, tagKey)
+	case reflect.Map:
+		return newMapSizer(t, tagKey)
+	case reflect.Slice:
+		return newSliceSizer(t, tagKey)
+	case reflect.Array:
+		return newArraySizer(t, tagKey)
+	case reflect.Ptr:
+		return newPtrSizer(t, tagKey)
+	default:
+		return unsupportedTypeSizer
+	}
+}
+
+// implementsOrAddr reports whether t, or the pointer to t, implements
+// the interface type it.
+func implementsOrAddr(t, it reflect.Type) bool {
+	return t.Implements(it) || t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(it)
+}
+
+// keySize returns the length of the key k and its 0x00 in an item.
+func keySize(k string) int {
+	if len(k) > KSPACK_KEY_MAX_LEN {
+		panic(fmt.Errorf("len(key) exceeds %d", KSPACK_KEY_MAX_LEN))
+	}
+	if len(k) == 0 {
+		return 0
+	}
+	return len(k) + 1
+}
+
+func unsupportedTypeSizer(e *encodeState, k string, v reflect.Value) int {
+	unsupportedTypeEncoder(e, k, v)
+	return 0
+}
+
+// type(1) | name length(1) | raw name bytes | 0x00 | 0x00
+func nilSizer(e *encodeState, k string, v reflect.Value) int {
+	return 1 + 1 + keySize(k) + 1
+}
+
+// fixedSizer returns the sizer of the fixed size items holding n bytes.
+func fixedSizer(n int) sizerFunc {
+	return func(e *encodeState, k string, v reflect.Value) int {
+		return 1 + 1 + keySize(k) + n
+	}
+}
+
+func timeSizer(e *encodeState, k string, v reflect.Value) int {
+	t := v.Interface().(time.Time)
+	if !t.IsZero() && (t.Before(minDate) || t.After(maxDate)) {
+		panic(dateRangeError(v, t))
+	}
+	if t.Location() == time.UTC {
+		return 1 + 1 + keySize(k) + 8
+	}
+	return 1 + 1 + 1 + keySize(k) + 8 + 4
+}
+
+func stringSizer(e *encodeState, k string, v reflect.Value) int {
+	return e.stringSize(k, v.Len())
+}
+
+// stringSize returns the length of the string item of n bytes written
+// by e.string.
+func (e *encodeState) stringSize(k string, n int) int {
+	vlen := n + 1
+	if vlen < MAX_SHORT_VITEM_LEN || e.canonical && vlen == MAX_SHORT_VITEM_LEN {
+		return 1 + 1 + 1 + keySize(k) + vlen
+	}
+	return 1 + 1 + 4 + keySize(k) + vlen
+}
+
+// binarySize returns the length of the binary item of n bytes written
+// by e.binary.
+func binarySize(k string, n int) int {
+	if n <= MAX_SHORT_VITEM_LEN {
+		return 1 + 1 + 1 + keySize(k) + n
+	}
+	return 1 + 1 + 4 + keySize(k) + n
+}
+
+func binarySizer(e *encodeState, k string, v reflect.Value) int {
+	return binarySize(k, v.Len())
+}
+
+func interfaceSizer(e *encodeState, k string, v reflect.Value) int {
+	if v.IsNil() {
+		return nilSizer(e, k, v)
+	}
+	return valueSizer(v.Elem(), e.tagKey)(e, k, v.Elem())
+}
+
+// newEncodedSizer measures the items of marshalers by encoding them
+// with enc, since their length is only known once marshaled.
+func newEncodedSizer(enc encoderFunc) sizerFunc {
+	return func(e *encodeState, k string, v reflect.Value) int {
+		scratch := encodeState{canonical: e.canonical, skipUnsupported: e.skipUnsupported, tagKey: e.tagKey}
+		enc(&scratch, k, v)
+		return scratch.off
+	}
+}
+
+type structSizer struct {
+	fields     structFields
+	fieldSizes []sizerFunc
+	inlineSize sizerFunc
+}
+
+func (ss *structSizer) size(e *encodeState, k string, v reflect.Value) int {
+	// type(1) | klen(1) | vlen(4) | key(len(k)) | 0x00 | field number(4)
+	n := 1 + 1 + 4 + keySize(k) + 4
+	var name string
+	defer func() {
+		if r := recover(); r != nil {
+			addPath(r, name)
+		}
+	}()
+
+	// Members of the inline map are checked first and measured last,
+	// as the encoder does, so that errors are reported alike.
+	var mv reflect.Value
+	var keys []reflect.Value
+	if im := ss.fields.inlineMap; im != nil {
+		name = im.name
+		if mv = fieldByIndex(v, im.index); mv.IsValid() {
+			keys = mv.MapKeys()
+			for _, mk := range keys {
+				if _, ok := ss.fields.byName[mk.String()]; ok {
+					panic(&UnsupportedValueError{Value: mv, Str: "inline map key " + strconv.Quote(mk.String()) + " duplicates a field"})
+				}
+			}
+		}
+	}
+
+	for i := range ss.fields.list {
+		f := &ss.fields.list[i]
+		name = f.name
+		fv := fieldByIndex(v, f.index)
+		if !fv.IsValid() || f.omitEmpty && isEmptyValue(fv) || f.omitZero && f.isZero(fv) {
+			continue
+		}
+		n += ss.fieldSizes[i](e, f.name, fv)
+	}
+	for _, mk := range keys {
+		name = mk.String()
+		n += ss.inlineSize(e, name, mv.MapIndex(mk))
+	}
+	return n
+}
+
+func newStructSizer(t reflect.Type, tagKey string) sizerFunc {
+	fields := cachedTypeFields(t, tagKey)
+	ss := &structSizer{
+		fields:     fields,
+		fieldSizes: make([]sizerFunc, len(fields.list)),
+	}
+	for i, f := range fields.list {
+		if f.quoted {
+			ss.fieldSizes[i] = newEncodedSizer(quotedEncoder)
+		} else {
+			ss.fieldSizes[i] = typeSizer(typeByIndex(t, f.index), tagKey)
+		}
+	}
+	if fields.inlineMap != nil {
+		ss.inlineSize = typeSizer(fields.inlineMap.typ.Elem(), tagKey)
+	}
+	return ss.size
+}
+
+type mapSizer struct {
+	elemSize sizerFunc
+}
+
+func (ms *mapSizer) size(e *encodeState, k string, v reflect.Value) int {
+	if e.ptrLevel++; e.ptrLevel > startDetectingCyclesAfter {
+		ptr := v.Pointer()
+		e.enter(ptr, v)
+		defer e.leave(ptr)
+	}
+	n := 1 + 1 + 4 + keySize(k) + 4
+	var name string
+	defer func() {
+		if r := recover(); r != nil {
+			addPath(r, name)
+		}
+	}()
+	iter := v.MapRange()
+	for iter.Next() {
+		kv := reflectWithString{v: iter.Key()}
+		name = "" // keys failing to resolve are reported at the map
+		kv.resolve()
+		name = kv.s
+		n += ms.elemSize(e, kv.s, iter.Value())
+	}
+	e.ptrLevel--
+	return n
+}
+
+func newMapSizer(t reflect.Type, tagKey string) sizerFunc {
+	switch t.Key().Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Bool:
+	default:
+		if !t.Key().Implements(textMarshalerType) {
+			return unsupportedTypeSizer
+		}
+	}
+	ms := &mapSizer{typeSizer(t.Elem(), tagKey)}
+	return ms.size
+}
+
+type sliceSizer struct {
+	arraySize sizerFunc
+}
+
+func (ss *sliceSizer) size(e *encodeState, k string, v reflect.Value) int {
+	if e.ptrLevel++; e.ptrLevel > startDetectingCyclesAfter {
+		ptr := struct {
+			ptr uintptr
+			len int
+		}{v.Pointer(), v.Len()}
+		e.enter(ptr, v)
+		defer e.leave(ptr)
+	}
+	n := ss.arraySize(e, k, v)
+	e.ptrLevel--
+	return n
+}
+
+func newSliceSizer(t reflect.Type, tagKey string) sizerFunc {
+	if t.Elem().Kind() == reflect.Uint8 {
+		return binarySizer
+	}
+	ss := &sliceSizer{newArraySizer(t, tagKey)}
+	return ss.size
+}
+
+type arraySizer struct {
+	elemSize sizerFunc
+}
+
+func (as *arraySizer) size(e *encodeState, k string, v reflect.Value) int {
+	// type(1) | klen(1) | vlen(4) | key(len(k)) | 0x00 | element number(4)
+	n := 1 + 1 + 4 + keySize(k) + 4
+	i := 0
+	defer func() {
+		if r := recover(); r != nil {
+			addPath(r, "["+strconv.Itoa(i)+"]")
+		}
+	}()
+	for ; i < v.Len(); i++ {
+		n += as.elemSize(e, "", v.Index(i))
+	}
+	return n
+}
+
+func newArraySizer(t reflect.Type, tagKey string) sizerFunc {
+	as := &arraySizer{typeSizer(t.Elem(), tagKey)}
+	return as.size
+}
+
+type ptrSizer struct {
+	elemSize sizerFunc
+}
+
+func (ps *ptrSizer) size(e *encodeState, k string, v reflect.Value) int {
+	if v.IsNil() {
+		return nilSizer(e, k, v)
+	}
+	if e.ptrLevel++; e.ptrLevel > startDetectingCyclesAfter {
+		ptr := v.Interface()
+		e.enter(ptr, v)
+		defer e.leave(ptr)
+	}
+	n := ps.elemSize(e, k, v.Elem())
+	e.ptrLevel--
+	return n
+}
+
+func newPtrSizer(t reflect.Type, tagKey string) sizerFunc {
+	ps := &ptrSizer{typeSizer(t.Elem(), tagKey)}
+	return ps.size
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSize(t *testing.T) {
+	assert := assert.New(t)
+
+	paid := true
+	when := time.Date(2023, 2, 9, 10, 0, 0, 0, time.FixedZone("CST", 8*3600))
+	values := []interface{}{
+		nil,
+		true,
+		int8(-1), int16(2), int32(3), int64(4), 5,
+		uint8(6), uint16(7), uint32(8), uint64(9), uint(10),
+		float32(1.5), 2.5,
+		"", "short", strings.Repeat("s", 253), strings.Repeat("s", 254), strings.Repeat("s", 255), strings.Repeat("s", 1000),
+		[]byte{}, []byte("bin"), make([]byte, 255), make([]byte, 256),
+		time.Time{}, time.Date(2023, 2, 9, 0, 0, 0, 0, time.UTC), when, &when, (*time.Time)(nil),
+		[]int{1, 2, 3}, [2]string{"a", "b"}, []interface{}{"x", nil, 1.5, []int{}},
+		map[string]interface{}{"a": 1, "b": map[string]string{"c": strings.Repeat("d", 300)}},
+		tables{
+			Ints:   map[int64]string{-7: "neg", 1 << 40: "big"},
+			Uints:  map[uint8]int{255: 1},
+			Flags:  map[bool]string{true: "t"},
+			Coords: map[coord]bool{{1, 2}: true},
+		},
+		host{IP: net.IPv4(10, 0, 0, 1), Amount: *big.NewInt(42), Sums: []digest{{1, 2, 3, 4}}, When: &when},
+		lineOrder{ID: 1, Price: 2.5, Paid: &paid, Dims: [2]int{1, 0}, Audit: audit{By: "me", At: when}, Extra: map[string]string{"x": "y"}},
+		lineOrder{},
+		account{ID: 1, Password: "p", Internal: "i", Balance: 3},
+		&treeNode{Name: "root", Children: []*treeNode{{Name: "leaf"}}},
+		RawMessage{KSPACK_OBJECT, 0, 0, 0, 0, 0, 1, 0, 0, 0, KSPACK_INT8, 2, 'z', 0, 1},
+		purchase{ID: 1, Items: []lineItem{{Sku: "a", Notify: make(chan int)}}, Total: 1i},
+	}
+	for _, opts := range []EncodeOptions{{}, {Canonical: true}, {SkipUnsupported: true}, {TagKey: "msgpack"}} {
+		for _, v := range values {
+			data, err := opts.Marshal(v)
+			n, serr := opts.Size(v)
+			if err != nil {
+				assert.EqualError(serr, err.Error(), "%+v %#v", opts, v)
+				continue
+			}
+			if assert.NoError(serr, "%+v %#v", opts, v) {
+				assert.Equal(len(data), n, "%+v %#v", opts, v)
+			}
+		}
+	}
+
+	n, err := Size(make(chan int))
+	assert.Zero(n)
+	assert.EqualError(err, "kspack: unsupported type chan int")
+
+	root := &treeNode{Name: "root"}
+	root.Children = []*treeNode{{Name: "child", Parent: root}}
+	_, merr := Marshal(root)
+	_, err = Size(root)
+	assert.Error(err)
+	assert.IsType(merr, err)
+}
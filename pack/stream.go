@@ -0,0 +1,248 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A Decoder reads and decodes kspack items from an input stream.
+//
+// The stream is a plain concatenation of top-level items. Each item is
+// framed by the content length in its header, so objects and arrays must
+// carry their real content length, as written by Marshal.
+type Decoder struct {
+	r       io.Reader
+	buf     []byte
+	d       decodeState
+	scanp   int   // start of unread data in buf
+	scanned int64 // amount of data already scanned
+	err     error
+}
+
+// NewDecoder returns a new decoder that reads from r.
+//
+// The decoder introduces its own buffering and may read data from r
+// beyond the items requested.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode reads the next item from its input and stores it in the value
+// pointed to by v.
+//
+// See the documentation for Unmarshal for details about the conversion
+// of kspack items into Go values.
+func (dec *Decoder) Decode(v interface{}) error {
+	n, err := dec.readItem()
+	if err != nil {
+		return err
+	}
+
+	dec.d.init(dec.buf[dec.scanp : dec.scanp+n])
+	dec.scanp += n
+	return dec.d.unmarshal(v)
+}
+
+// DisallowUnknownFields causes the Decoder to return an
+// *UnknownFieldError when the destination is a struct and the input
+// contains object keys which do not match any exported field in the
+// destination.
+func (dec *Decoder) DisallowUnknownFields() { dec.d.opts.DisallowUnknownFields = true }
+
+// SetOptions sets the options used by subsequent calls to Decode,
+// replacing any set by DisallowUnknownFields. Offsets in errors are
+// relative to the start of the item being decoded, except those of
+// errors in the framing of the stream, which are relative to its start.
+func (dec *Decoder) SetOptions(opts DecodeOptions) {
+	dec.d.opts = opts
+}
+
+// CollectUnknownFields causes the Decoder to record, rather than skip
+// silently, object keys which do not match any exported field of the
+// destination struct. Decoding is not interrupted; the keys are
+// available from UnknownFields.
+func (dec *Decoder) CollectUnknownFields() { dec.d.collectUnknownFields = true }
+
+// UnknownFields returns the unmatched object keys recorded by the last
+// call to Decode, in input order. It is always empty unless
+// CollectUnknownFields was called.
+func (dec *Decoder) UnknownFields() []UnknownFieldError {
+	return dec.d.unknownFields
+}
+
+// Buffered returns a reader of the data remaining in the Decoder's
+// buffer. The reader is valid until the next call to Decode.
+func (dec *Decoder) Buffered() io.Reader {
+	return bytes.NewReader(dec.buf[dec.scanp:])
+}
+
+// More reports whether there is another item in the input stream.
+func (dec *Decoder) More() bool {
+	for dec.scanp >= len(dec.buf) {
+		if dec.err != nil {
+			return false
+		}
+		dec.err = dec.refill()
+	}
+	return true
+}
+
+// readItem reads until buf[scanp:] holds one complete item and returns
+// its length.
+func (dec *Decoder) readItem() (int, error) {
+	need := 2 // type + klen
+	for {
+		if avail := dec.buf[dec.scanp:]; len(avail) >= need {
+			h := itemHeaderLen(avail[0])
+			if h == 0 {
+				return 0, &SyntaxError{Msg: fmt.Sprintf("unknown item type 0x%02x", avail[0]), Offset: dec.inputOffset()}
+			}
+			if len(avail) >= h {
+				n := itemLen(avail)
+				if max := dec.d.opts.MaxItemSize; max > 0 && n > max {
+					return 0, &LimitError{Limit: "MaxItemSize", Max: max, Offset: dec.inputOffset()}
+				}
+				if len(avail) >= n {
+					return n, nil
+				}
+				need = n
+			} else {
+				need = h
+			}
+		}
+
+		if dec.err != nil {
+			if dec.err == io.EOF && len(dec.buf) > dec.scanp {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, dec.err
+		}
+		dec.err = dec.refill()
+	}
+}
+
+// inputOffset returns the offset in the input stream of the next
+// unread item.
+func (dec *Decoder) inputOffset() int64 {
+	return dec.scanned + int64(dec.scanp)
+}
+
+func (dec *Decoder) refill() error {
+	// Make room to read more into the buffer.
+	// First slide down data already consumed.
+	if dec.scanp > 0 {
+		dec.scanned += int64(dec.scanp)
+		n := copy(dec.buf, dec.buf[dec.scanp:])
+		dec.buf = dec.buf[:n]
+		dec.scanp = 0
+	}
+
+	// Grow buffer if not large enough.
+	const minRead = 512
+	if cap(dec.buf)-len(dec.buf) < minRead {
+		newBuf := make([]byte, len(dec.buf), 2*cap(dec.buf)+minRead)
+		copy(newBuf, dec.buf)
+		dec.buf = newBuf
+	}
+
+	// Read. Delay error for next iteration (after scan).
+	n, err := dec.r.Read(dec.buf[len(dec.buf):cap(dec.buf)])
+	dec.buf = dec.buf[0 : len(dec.buf)+n]
+
+	return err
+}
+
+// An Encoder writes kspack items to an output stream. It reuses its
+// buffer from one item to the next.
+type Encoder struct {
+	w    io.Writer
+	err  error
+	opts EncodeOptions
+	e    encodeState
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the kspack encoding of v to the stream.
+//
+// See the documentation for Marshal for details about the conversion of
+// Go values to kspack items.
+func (enc *Encoder) Encode(v interface{}) error {
+	if enc.err != nil {
+		return enc.err
+	}
+
+	e := &enc.e
+	e.reset()
+	e.setOptions(enc.opts)
+	if err := e.marshal(v); err != nil {
+		return err
+	}
+	if _, err := enc.w.Write(e.data[:e.off]); err != nil {
+		enc.err = err
+		return err
+	}
+	return nil
+}
+
+// SetOptions sets the options used by subsequent calls to Encode.
+func (enc *Encoder) SetOptions(opts EncodeOptions) {
+	enc.opts = opts
+}
+
+// Reset makes enc write to w, clearing any write error of the previous
+// stream. Its options and buffer are kept, so that a pool of Encoders
+// can serve many streams without allocating.
+func (enc *Encoder) Reset(w io.Writer) {
+	enc.w = w
+	enc.err = nil
+}
+
+// RawMessage is a raw encoded kspack item, as stored in the input,
+// including the key it was stored under. It implements Marshaler and
+// Unmarshaler and can be used to delay decoding a part of a document or
+// to forward it untouched. When encoded, it is written verbatim under
+// the name of the field, map entry or array element that holds it.
+type RawMessage []byte
+
+// MarshalKSPACK returns m as the kspack encoding of m.
+// A nil RawMessage encodes as a NULL item.
+func (m RawMessage) MarshalKSPACK() ([]byte, error) {
+	if m == nil {
+		return []byte{KSPACK_NULL, 0, 0}, nil
+	}
+	return m, nil
+}
+
+// UnmarshalKSPACK sets *m to a copy of data.
+func (m *RawMessage) UnmarshalKSPACK(data []byte) error {
+	if m == nil {
+		return errors.New("kspack.RawMessage: UnmarshalKSPACK on nil pointer")
+	}
+	*m = append((*m)[0:0], data...)
+	return nil
+}
+
+var _ Marshaler = (*RawMessage)(nil)
+var _ Unmarshaler = (*RawMessage)(nil)
@@ -0,0 +1,271 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var streamTests = []interface{}{
+	&T{A: true, X: "x", Y: 1},
+	"a long string " + strings.Repeat("z", 300),
+	[]byte("bytes"),
+	int8(-3),
+	map[string]interface{}{"k": "v"},
+	[]uint16{1, 2},
+}
+
+func TestEncoderDecoder(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, v := range streamTests {
+		assert.NoError(enc.Encode(v))
+	}
+
+	for _, r := range []io.Reader{
+		bytes.NewReader(buf.Bytes()),
+		iotest.OneByteReader(bytes.NewReader(buf.Bytes())),
+		iotest.DataErrReader(bytes.NewReader(buf.Bytes())),
+	} {
+		dec := NewDecoder(r)
+		var got []interface{}
+		for dec.More() {
+			var v interface{}
+			assert.NoError(dec.Decode(&v))
+			got = append(got, v)
+		}
+		assert.Len(got, len(streamTests))
+		assert.Equal(map[string]interface{}{"A": true, "X": "x", "Y": int64(1)}, got[0])
+		assert.Equal(streamTests[1], got[1])
+		assert.Equal(streamTests[2], got[2])
+		assert.Equal(int8(-3), got[3])
+		assert.Equal(streamTests[4], got[4])
+		assert.Equal([]interface{}{uint16(1), uint16(2)}, got[5])
+
+		var v interface{}
+		assert.Equal(io.EOF, dec.Decode(&v))
+	}
+}
+
+func TestDecoderBuffered(t *testing.T) {
+	assert := assert.New(t)
+	data, err := Marshal(map[string]string{"a": "b"})
+	assert.NoError(err)
+
+	dec := NewDecoder(bytes.NewReader(append(data, "tail"...)))
+	var m map[string]string
+	assert.NoError(dec.Decode(&m))
+	assert.Equal(map[string]string{"a": "b"}, m)
+
+	rest, err := io.ReadAll(dec.Buffered())
+	assert.NoError(err)
+	assert.Equal([]byte("tail"), rest)
+}
+
+func TestDecoderErrors(t *testing.T) {
+	assert := assert.New(t)
+	data, err := Marshal(&T{A: true, X: "x", Y: 1})
+	assert.NoError(err)
+
+	var v interface{}
+	dec := NewDecoder(bytes.NewReader(data[:len(data)-1]))
+	assert.True(dec.More())
+	assert.Equal(io.ErrUnexpectedEOF, dec.Decode(&v))
+
+	dec = NewDecoder(bytes.NewReader([]byte{0x7f, 0, 0}))
+	assert.Equal(&SyntaxError{Msg: "unknown item type 0x7f", Offset: 0}, dec.Decode(&v))
+
+	// offsets count from the start of the stream, not of the buffer
+	dec = NewDecoder(io.MultiReader(bytes.NewReader(data), bytes.NewReader([]byte{0x7f, 0, 0})))
+	assert.NoError(dec.Decode(&v))
+	assert.EqualError(dec.Decode(&v), fmt.Sprintf("kspack: unknown item type 0x7f at offset %d", len(data)))
+
+	// the declared length is checked before the item is read
+	dec = NewDecoder(io.MultiReader(bytes.NewReader([]byte{KSPACK_BINARY, 0, 0xff, 0xff, 0xff, 0xff}), iotest.ErrReader(errors.New("read on"))))
+	dec.SetOptions(DecodeOptions{MaxItemSize: 1 << 20})
+	assert.EqualError(dec.Decode(&v), "kspack: item at offset 0 exceeds MaxItemSize of 1048576")
+	dec = NewDecoder(bytes.NewReader(data))
+	dec.SetOptions(DecodeOptions{MaxItemSize: len(data)})
+	assert.NoError(dec.Decode(&v))
+
+	readErr := errors.New("read failed")
+	dec = NewDecoder(iotest.ErrReader(readErr))
+	assert.False(dec.More())
+	assert.Equal(readErr, dec.Decode(&v))
+}
+
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) { return 0, io.ErrShortWrite }
+
+func TestEncoderError(t *testing.T) {
+	assert := assert.New(t)
+	enc := NewEncoder(failWriter{})
+	assert.Equal(io.ErrShortWrite, enc.Encode(1))
+	assert.Equal(io.ErrShortWrite, enc.Encode(2))
+
+	enc = NewEncoder(io.Discard)
+	assert.Error(enc.Encode(badItem{}))
+	assert.NoError(enc.Encode(1))
+}
+
+type envelope struct {
+	Route   string     `json:"route"`
+	Payload RawMessage `json:"payload"`
+}
+
+type order struct {
+	ID    int64    `json:"id"`
+	Items []string `json:"items"`
+}
+
+func TestRawMessage(t *testing.T) {
+	assert := assert.New(t)
+	payload, err := Marshal(&order{ID: 7, Items: []string{"a", "b"}})
+	assert.NoError(err)
+
+	data, err := Marshal(&envelope{Route: "orders", Payload: payload})
+	assert.NoError(err)
+
+	var env envelope
+	assert.NoError(Unmarshal(data, &env))
+	assert.Equal("orders", env.Route)
+	// the stored item carries the key it was stored under
+	assert.Equal(byte(KSPACK_OBJECT), env.Payload[0])
+	assert.Equal([]byte("payload\x00"), []byte(env.Payload[6:14]))
+
+	// forwarding re-keys the item and leaves the content untouched
+	forwarded, err := Marshal(&envelope{Route: "archive", Payload: env.Payload})
+	assert.NoError(err)
+	var fwd envelope
+	assert.NoError(Unmarshal(forwarded, &fwd))
+	assert.Equal(env.Payload, fwd.Payload)
+
+	var o order
+	assert.NoError(Unmarshal(fwd.Payload, &o))
+	assert.Equal(order{ID: 7, Items: []string{"a", "b"}}, o)
+
+	top, err := Marshal(fwd.Payload)
+	assert.NoError(err)
+	assert.Equal(payload, top)
+
+	// nil messages are NULL items, and decode back to a NULL item
+	data, err = Marshal(&envelope{Route: "none"})
+	assert.NoError(err)
+	env = envelope{}
+	assert.NoError(Unmarshal(data, &env))
+	assert.Equal(RawMessage{KSPACK_NULL, 8, 'p', 'a', 'y', 'l', 'o', 'a', 'd', 0, 0}, env.Payload)
+
+	var raw RawMessage
+	assert.NoError(Unmarshal(payload, &raw))
+	assert.Equal(RawMessage(payload), raw)
+
+	_, err = Marshal(RawMessage{KSPACK_INT32, 0, 1})
+	assert.Error(err)
+	assert.Error((*RawMessage)(nil).UnmarshalKSPACK(payload))
+}
+
+type customerV2 struct {
+	Name  string
+	Email string
+	Tier  int8
+	Inner obj
+}
+
+type customerV1 struct {
+	Name  string
+	Inner obj
+}
+
+func TestDecoderUnknownFields(t *testing.T) {
+	assert := assert.New(t)
+	data, err := Marshal(&customerV2{Name: "n", Email: "e", Tier: 2, Inner: obj{Foo: "f"}})
+	assert.NoError(err)
+
+	var v customerV1
+	dec := NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&v)
+	var ue *UnknownFieldError
+	assert.True(errors.As(err, &ue))
+	assert.Equal("Email", ue.Key)
+	assert.Equal(reflect.TypeOf(customerV1{}), ue.Type)
+	assert.Equal(int64(20), ue.Offset)
+	assert.EqualError(err, `kspack: unknown field "Email" in pack.customerV1`)
+
+	// case-insensitive matches are not unknown
+	lower, err := Marshal(map[string]interface{}{"name": "n", "inner": map[string]string{"FOO": "f"}})
+	assert.NoError(err)
+	dec = NewDecoder(bytes.NewReader(lower))
+	dec.DisallowUnknownFields()
+	v = customerV1{}
+	assert.NoError(dec.Decode(&v))
+	assert.Equal(customerV1{Name: "n", Inner: obj{Foo: "f"}}, v)
+
+	dec = NewDecoder(bytes.NewReader(append(data, data...)))
+	dec.CollectUnknownFields()
+	for dec.More() {
+		v = customerV1{}
+		assert.NoError(dec.Decode(&v))
+		assert.Equal(customerV1{Name: "n", Inner: obj{Foo: "f"}}, v)
+		keys := []string{}
+		for _, f := range dec.UnknownFields() {
+			assert.Equal(reflect.TypeOf(customerV1{}), f.Type)
+			keys = append(keys, f.Key)
+		}
+		assert.Equal([]string{"Email", "Tier"}, keys)
+	}
+
+	// maps have no unknown keys
+	dec = NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var m map[string]interface{}
+	assert.NoError(dec.Decode(&m))
+	assert.Len(m, 4)
+}
+
+func TestEncoderReset(t *testing.T) {
+	assert := assert.New(t)
+
+	var first bytes.Buffer
+	enc := NewEncoder(failWriter{})
+	enc.SetOptions(EncodeOptions{Canonical: true})
+	assert.Error(enc.Encode(1))
+
+	enc.Reset(&first)
+	assert.NoError(enc.Encode(map[string]int{"b": 2, "a": 1}))
+	want, err := MarshalCanonical(map[string]int{"a": 1, "b": 2})
+	assert.NoError(err)
+	assert.Equal(want, first.Bytes())
+
+	var second bytes.Buffer
+	enc.Reset(&second)
+	assert.NoError(enc.Encode(uint8(3)))
+	assert.Equal([]byte{KSPACK_UINT8, 0, 3}, second.Bytes())
+	assert.Equal(want, first.Bytes())
+}
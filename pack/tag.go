@@ -20,11 +20,13 @@ import (
 	"strings"
 )
 
-// tagOptions is the string following a comma in a struct field's "json"
-// tag, or the empty string. It does not include the leading comma.
+// tagOptions is the string following a comma in a struct field's
+// kspack or json tag, or the empty string. It does not include the
+// leading comma. The options understood are omitempty, omitzero,
+// string, inline and required.
 type tagOptions string
 
-// parseTag splits a struct field's json tag into its name and
+// parseTag splits a struct field's tag into its name and
 // comma-separated options.
 func parseTag(tag string) (string, tagOptions) {
 	if idx := strings.Index(tag, ","); idx != -1 {